@@ -10,13 +10,18 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/yesaswi/shift-claiming-automation/internal/cloudtasks"
 	"github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/notify"
+	"github.com/yesaswi/shift-claiming-automation/internal/portal"
 	"github.com/yesaswi/shift-claiming-automation/internal/shiftclaiming"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"github.com/yesaswi/shift-claiming-automation/internal/web"
 	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
@@ -27,6 +32,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter, "shiftclaiming")
+	if err != nil {
+		fmt.Printf(`{"message": "Failed to initialize tracing", "error": "%v", "severity": "critical"}`+"\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize the Firestore client
 	firestoreClient, err := firestore.NewClient(context.Background(), cfg.ProjectID, cfg.DatabaseID)
 	if err != nil {
@@ -53,24 +65,70 @@ func main() {
 		}
 	}(cloudTasksClient)
 
+	// Initialize the portal client, sized for the claims sent at once
+	portalClient, err := portal.NewClient(portal.Options{
+		Timeout:      cfg.PortalTimeout,
+		MaxConns:     cfg.ClaimParallelism + 1,
+		ProxyURL:     cfg.PortalProxyURL,
+		CAFile:       cfg.PortalCAFile,
+		SchedulePath: cfg.SchedulePath,
+	})
+	if err != nil {
+		fmt.Printf(`{"message": "Failed to initialize portal client", "error": "%v", "severity": "critical"}`+"\n", err)
+		os.Exit(1)
+	}
+	defer portalClient.Close()
+
 	// Initialize the Shift Claiming Service
-	service := shiftclaiming.NewService(firestoreClient, cloudTasksClient)
+	notifyClient := notify.NewClient(cfg.NotifyWebhookURL)
+	service := shiftclaiming.NewService(cfg, firestoreClient, cloudTasksClient, notifyClient, portalClient)
 
 	// Create a new HTTP router
 	router := mux.NewRouter()
 
-	// Register the start and stop command handlers
-	router.HandleFunc("/start", service.HandleStartCommand).Methods(http.MethodPost)
-	router.HandleFunc("/stop", service.HandleStopCommand).Methods(http.MethodPost)
+	// Register the start and stop command handlers. Routes that change state
+	// or expose the configuration require a verified caller, see RequireAuth.
+	router.HandleFunc("/start", service.RequireAuth(service.HandleStartCommand)).Methods(http.MethodPost)
+	router.HandleFunc("/stop", service.RequireAuth(service.HandleStopCommand)).Methods(http.MethodPost)
 	router.HandleFunc("/claim", service.HandleClaimCommand).Methods(http.MethodPost)
+	router.HandleFunc("/plan", service.HandlePlan).Methods(http.MethodGet)
+	router.HandleFunc("/claim/link", service.HandleClaimLinkPage).Methods(http.MethodGet)
+	router.HandleFunc("/claim/link", service.HandleClaimLink).Methods(http.MethodPost)
+	router.HandleFunc("/campaigns", service.RequireAuth(service.HandleCreateCampaign)).Methods(http.MethodPost)
+	router.HandleFunc("/campaigns", service.RequireAuth(service.HandleListCampaigns)).Methods(http.MethodGet)
+	router.HandleFunc("/campaigns/{id}", service.RequireAuth(service.HandleCancelCampaign)).Methods(http.MethodDelete)
+	router.HandleFunc("/pubsub/push", service.HandlePubSubPush).Methods(http.MethodPost)
+	router.HandleFunc("/stats/rollup", service.HandleRollupStats).Methods(http.MethodPost)
+
+	// Register the read endpoints
+	router.HandleFunc("/health", service.HandleHealthCheck).Methods(http.MethodGet)
+	router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	router.HandleFunc("/status", service.HandleStatus).Methods(http.MethodGet)
+	router.HandleFunc("/claims", service.HandleListClaims).Methods(http.MethodGet)
+	router.HandleFunc("/shifts/seen", service.HandleListSeenShifts).Methods(http.MethodGet)
+	router.HandleFunc("/events/stream", service.HandleEventStream).Methods(http.MethodGet)
+	router.HandleFunc("/audit", service.RequireAuth(service.HandleListAudit)).Methods(http.MethodGet)
+	router.HandleFunc("/stats", service.HandleStats).Methods(http.MethodGet)
+
+	// Register the configuration endpoints
+	router.HandleFunc("/config/shift", service.HandleGetShiftConfig).Methods(http.MethodGet)
+	router.HandleFunc("/config/shift", service.RequireAuth(service.HandleUpdateShiftConfig)).Methods(http.MethodPut)
+	router.HandleFunc("/config/shift/preview", service.HandlePreviewShiftConfig).Methods(http.MethodPost)
+	router.HandleFunc("/config/auth", service.RequireAuth(service.HandleUpdateAuthConfig)).Methods(http.MethodPut)
+	router.HandleFunc("/config/history", service.RequireAuth(service.HandleConfigHistory)).Methods(http.MethodGet)
+	router.HandleFunc("/config/rollback/{version}", service.RequireAuth(service.HandleConfigRollback)).Methods(http.MethodPost)
+
+	// Serve the dashboard for every other path
+	router.PathPrefix("/").Handler(web.Handler()).Methods(http.MethodGet)
 
 	// Start the HTTP server
 	port := fmt.Sprintf(":%d", cfg.Port)
 	fmt.Printf(`{"message": "Starting server on port %s", "severity": "info"}`+"\n", port)
 	server := &http.Server{
 		Addr:    port,
-		Handler: router,
+		Handler: otelhttp.NewHandler(router, "shiftclaiming"),
 	}
+	server.RegisterOnShutdown(service.CloseStreams)
 
 	// Start the server in a goroutine
 	go func() {
@@ -85,12 +143,24 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
-	// Shutdown the server gracefully
+	// Shutdown the server gracefully. In-flight requests, including claim
+	// tasks, and the poll in progress finish before the clients are closed.
+	// Both steps share one deadline, within the time Cloud Run allows, and
+	// the server only gets the first two thirds of it so that a slow
+	// shutdown leaves the service time to flush buffered writes.
 	fmt.Println(`{"message": "Shutting down the server...", "severity": "info"}`)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
+	serverCtx, cancelServer := context.WithTimeout(ctx, cfg.ShutdownTimeout*2/3)
+	defer cancelServer()
+	if err := server.Shutdown(serverCtx); err != nil {
 		fmt.Printf(`{"message": "Server shutdown error", "error": "%v", "severity": "error"}`+"\n", err)
 	}
+	if err := service.Close(ctx); err != nil {
+		fmt.Printf(`{"message": "Failed to close the service", "error": "%v", "severity": "error"}`+"\n", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		fmt.Printf(`{"message": "Failed to flush traces", "error": "%v", "severity": "error"}`+"\n", err)
+	}
 	fmt.Println(`{"message": "Server stopped.", "severity": "info"}`)
 }
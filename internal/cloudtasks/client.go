@@ -2,6 +2,7 @@ package cloudtasks
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -11,6 +12,11 @@ import (
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewClient(ctx context.Context) (*cloudtasks.Client, error) {
@@ -18,40 +24,78 @@ func NewClient(ctx context.Context) (*cloudtasks.Client, error) {
     return cloudtasks.NewClient(ctx)
 }
 
-func CreateTask(client *cloudtasks.Client, projectID, locationID, queueID, targetURL string, scheduleTime time.Time) (*taskspb.Task, error) {
+// TaskPayload is the body of every task. It carries the trace context of the
+// span that scheduled the task, since Cloud Run replaces the traceparent
+// header with its own.
+type TaskPayload struct {
+    TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// CreateTask queues a POST to targetURL at scheduleTime. With a service
+// account the request carries an OIDC token for it, issued for audience, so
+// that the target can tell the task from other callers.
+func CreateTask(ctx context.Context, client *cloudtasks.Client, projectID, locationID, queueID, targetURL, serviceAccount, audience string, scheduleTime time.Time) (task *taskspb.Task, err error) {
+    ctx, span := tracing.Tracer().Start(ctx, "cloudtasks.CreateTask", trace.WithSpanKind(trace.SpanKindProducer))
+    defer func() { tracing.End(span, err) }()
+    span.SetAttributes(attribute.String("cloudtasks.queue", queueID), attribute.String("cloudtasks.schedule_time", scheduleTime.Format(time.RFC3339)))
+
     // Create a new task with the specified target URL and schedule time
     fmt.Printf("Creating task with target URL: %s\n", targetURL)
+    traceContext := tracing.Inject(ctx)
+    body, err := json.Marshal(TaskPayload{TraceContext: traceContext})
+    if err != nil {
+        return nil, err
+    }
+    headers := map[string]string{"Content-Type": "application/json"}
+    for key, value := range traceContext {
+        headers[key] = value
+    }
+    httpRequest := &taskspb.HttpRequest{
+        HttpMethod: taskspb.HttpMethod_POST,
+        Url:        targetURL,
+        Headers:    headers,
+        Body:       body,
+    }
+    if serviceAccount != "" {
+        httpRequest.AuthorizationHeader = &taskspb.HttpRequest_OidcToken{
+            OidcToken: &taskspb.OidcToken{
+                ServiceAccountEmail: serviceAccount,
+                Audience:            audience,
+            },
+        }
+    }
     req := &taskspb.CreateTaskRequest{
         Parent: fmt.Sprintf("projects/%s/locations/%s/queues/%s", projectID, locationID, queueID),
         Task: &taskspb.Task{
             MessageType: &taskspb.Task_HttpRequest{
-                HttpRequest: &taskspb.HttpRequest{
-                    HttpMethod: taskspb.HttpMethod_POST,
-                    Url:        targetURL,
-                },
+                HttpRequest: httpRequest,
             },
             ScheduleTime: timestamppb.New(scheduleTime),
         },
     }
-    return client.CreateTask(context.Background(), req)
+    task, err = client.CreateTask(ctx, req)
+    metrics.TasksTotal.WithLabelValues("created", metrics.Result(err)).Inc()
+    return task, err
 }
 
-func DeleteTask(client *cloudtasks.Client, projectID, locationID, queueID, taskID string) error {
+func DeleteTask(ctx context.Context, client *cloudtasks.Client, projectID, locationID, queueID, taskID string) error {
     // Delete the task with the specified ID
     fmt.Printf("Deleting task with ID: %s\n", taskID)
     req := &taskspb.DeleteTaskRequest{
         Name: fmt.Sprintf("projects/%s/locations/%s/queues/%s/tasks/%s", projectID, locationID, queueID, taskID),
     }
-    return client.DeleteTask(context.Background(), req)
+    err := client.DeleteTask(ctx, req)
+    metrics.TasksTotal.WithLabelValues("deleted", metrics.Result(err)).Inc()
+    return err
 }
 
-func DeleteAllTasks(client *cloudtasks.Client, projectID, locationID, queueID string) error {
+func DeleteAllTasks(ctx context.Context, client *cloudtasks.Client, projectID, locationID, queueID string) error {
     // Delete all tasks in the specified queue
     fmt.Printf("Deleting all tasks in queue: %s\n", queueID)
     req := &taskspb.ListTasksRequest{
         Parent: fmt.Sprintf("projects/%s/locations/%s/queues/%s", projectID, locationID, queueID),
     }
-    it := client.ListTasks(context.Background(), req)
+    it := client.ListTasks(ctx, req)
     for {
         task, err := it.Next()
         if err == iterator.Done {
@@ -62,7 +106,7 @@ func DeleteAllTasks(client *cloudtasks.Client, projectID, locationID, queueID st
         }
         // Extract the task ID from the task name
         taskID := extractTaskID(task.Name)
-        if err := DeleteTask(client, projectID, locationID, queueID, taskID); err != nil {
+        if err := DeleteTask(ctx, client, projectID, locationID, queueID, taskID); err != nil {
             continue
         }
     }
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a single message published on the bus. IDs increase monotonically
+// for the lifetime of the process.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Bus is an in-process publish/subscribe hub that keeps the most recent events
+// in a bounded ring buffer so subscribers can resume after a disconnect.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	ring        []Event
+	start       int
+	size        int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the events published after it was created. Events are
+// dropped for a subscriber whose channel is full rather than blocking
+// publishers. The channel is closed when the bus is.
+type Subscription struct {
+	C     chan Event
+	types map[string]bool
+}
+
+func NewBus(capacity int) *Bus {
+	// Initialize and return a new event bus
+	return &Bus{
+		nextID:      1,
+		ring:        make([]Event, capacity),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish records an event and fans it out to the matching subscribers.
+func (b *Bus) Publish(eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now(), Data: data}
+	b.nextID++
+	if len(b.ring) > 0 {
+		if b.size < len(b.ring) {
+			b.ring[(b.start+b.size)%len(b.ring)] = event
+			b.size++
+		} else {
+			b.ring[b.start] = event
+			b.start = (b.start + 1) % len(b.ring)
+		}
+	}
+	for sub := range b.subscribers {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the given event types, or every type if
+// none are given. It returns the buffered events newer than lastID so the
+// caller can replay them before reading from the subscription.
+func (b *Bus) Subscribe(lastID uint64, types []string) (*Subscription, []Event) {
+	sub := &Subscription{C: make(chan Event, 64)}
+	if len(types) > 0 {
+		sub.types = map[string]bool{}
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var backlog []Event
+	for i := 0; i < b.size; i++ {
+		event := b.ring[(b.start+i)%len(b.ring)]
+		if event.ID > lastID && sub.wants(event.Type) {
+			backlog = append(backlog, event)
+		}
+	}
+	if b.closed {
+		close(sub.C)
+		return sub, backlog
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub, backlog
+}
+
+// Unsubscribe stops delivering events to the subscription.
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+}
+
+// Close closes the channels of all subscriptions, and of those made later, so
+// that their readers stop. Events are still recorded for replay.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subscribers {
+		close(sub.C)
+		delete(b.subscribers, sub)
+	}
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	return s.types == nil || s.types[eventType]
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func eventIDs(events []Event) []uint64 {
+	var ids []uint64
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestBusSubscribeBacklog(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		publish  []string
+		lastID   uint64
+		types    []string
+		want     []uint64
+	}{
+		{"everything buffered", 4, []string{"poll", "claim", "poll"}, 0, nil, []uint64{1, 2, 3}},
+		{"ring wraps", 3, []string{"poll", "poll", "poll", "poll", "poll"}, 0, nil, []uint64{3, 4, 5}},
+		{"resume after last event", 4, []string{"poll", "poll", "poll"}, 2, nil, []uint64{3}},
+		{"resume after wrapping", 3, []string{"poll", "poll", "poll", "poll", "poll"}, 3, nil, []uint64{4, 5}},
+		{"resume from an event no longer buffered", 3, []string{"poll", "poll", "poll", "poll", "poll"}, 1, nil, []uint64{3, 4, 5}},
+		{"resume when up to date", 3, []string{"poll", "poll"}, 2, nil, nil},
+		{"type filter", 4, []string{"poll", "claim", "poll", "claim"}, 0, []string{"claim"}, []uint64{2, 4}},
+		{"type filter with resume", 4, []string{"poll", "claim", "poll", "claim"}, 2, []string{"claim", "cooldown"}, []uint64{4}},
+		{"no buffer", 0, []string{"poll", "poll"}, 0, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewBus(tt.capacity)
+			for _, eventType := range tt.publish {
+				bus.Publish(eventType, nil)
+			}
+			_, backlog := bus.Subscribe(tt.lastID, tt.types)
+			assert.Equal(t, tt.want, eventIDs(backlog))
+		})
+	}
+}
+
+func TestBusDelivery(t *testing.T) {
+	bus := NewBus(4)
+	all, _ := bus.Subscribe(0, nil)
+	claims, _ := bus.Subscribe(0, []string{"claim"})
+	bus.Publish("poll", nil)
+	bus.Publish("claim", "1234")
+
+	assert.Equal(t, []uint64{1, 2}, eventIDs(drain(all)))
+	got := drain(claims)
+	assert.Equal(t, []uint64{2}, eventIDs(got))
+	assert.Equal(t, "1234", got[0].Data)
+
+	bus.Unsubscribe(all)
+	bus.Publish("poll", nil)
+	assert.Empty(t, drain(all))
+}
+
+func TestBusDropsForFullSubscriber(t *testing.T) {
+	bus := NewBus(0)
+	sub, _ := bus.Subscribe(0, nil)
+	for i := 0; i < cap(sub.C)+10; i++ {
+		bus.Publish("poll", nil)
+	}
+	got := drain(sub)
+	assert.Len(t, got, cap(sub.C))
+	assert.Equal(t, uint64(1), got[0].ID)
+}
+
+func TestBusClose(t *testing.T) {
+	bus := NewBus(4)
+	before, _ := bus.Subscribe(0, nil)
+	bus.Close()
+	bus.Close()
+	_, ok := <-before.C
+	assert.False(t, ok)
+
+	// Publishing after close is still recorded for replay
+	bus.Publish("poll", nil)
+	after, backlog := bus.Subscribe(0, nil)
+	assert.Equal(t, []uint64{1}, eventIDs(backlog))
+	_, ok = <-after.C
+	assert.False(t, ok)
+	bus.Unsubscribe(after)
+}
+
+// drain returns the events waiting on a subscription.
+func drain(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
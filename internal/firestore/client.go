@@ -2,11 +2,39 @@ package firestore
 
 import (
 	"context"
+	"path"
+	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 )
 
+// writeMethods are the Firestore RPCs that write documents.
+var writeMethods = map[string]bool{
+	"Commit":     true,
+	"BatchWrite": true,
+}
+
 func NewClient(ctx context.Context, projectID string, databaseID string) (*firestore.Client, error) {
 	// Initialize and return a new Firestore client
-	return firestore.NewClientWithDatabase(ctx, projectID, databaseID)
+	return firestore.NewClientWithDatabase(ctx, projectID, databaseID,
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(observeWrites)))
+}
+
+// observeWrites records the latency of every write RPC and traces it.
+func observeWrites(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	name := path.Base(method)
+	if !writeMethods[name] {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "firestore."+name, trace.WithSpanKind(trace.SpanKindClient))
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	metrics.StoreWriteDuration.WithLabelValues(name, metrics.Result(err)).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	return err
 }
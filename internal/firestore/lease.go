@@ -0,0 +1,187 @@
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Locker keeps leases in a collection, one document per lease name, and
+// changes them in transactions. A released or revoked lease keeps its document
+// so that the next lease continues from its token.
+type Locker struct {
+	client *firestore.Client
+	leases *firestore.CollectionRef
+}
+
+func NewLocker(client *firestore.Client, collection string) *Locker {
+	return &Locker{client: client, leases: client.Collection(collection)}
+}
+
+// leaseDoc is the stored form of a lease. An empty holder means the lease is
+// free.
+type leaseDoc struct {
+	Holder     string    `firestore:"holder"`
+	Token      int64     `firestore:"token"`
+	AcquiredAt time.Time `firestore:"acquiredAt"`
+	ExpiresAt  time.Time `firestore:"expiresAt"`
+}
+
+func (l *Locker) get(tx *firestore.Transaction, name string) (*leaseDoc, error) {
+	snap, err := tx.Get(l.leases.Doc(name))
+	if status.Code(err) == codes.NotFound {
+		return &leaseDoc{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc leaseDoc
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse lease %s: %v", name, err)
+	}
+	return &doc, nil
+}
+
+func (l *Locker) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (*lease.Lease, error) {
+	var acquired *lease.Lease
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := l.get(tx, name)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		switch {
+		case doc.Holder == holder:
+		case doc.Holder != "" && doc.ExpiresAt.After(now):
+			return fmt.Errorf("%w: %s", lease.ErrHeld, doc.Holder)
+		default:
+			doc.Token++
+			doc.Holder = holder
+			doc.AcquiredAt = now
+		}
+		doc.ExpiresAt = now.Add(ttl)
+		acquired = &lease.Lease{Name: name, Holder: holder, Token: doc.Token, ExpiresAt: doc.ExpiresAt}
+		return tx.Set(l.leases.Doc(name), doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acquired, nil
+}
+
+func (l *Locker) Renew(ctx context.Context, held *lease.Lease, ttl time.Duration) (*lease.Lease, error) {
+	var renewed *lease.Lease
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := l.get(tx, held.Name)
+		if err != nil {
+			return err
+		}
+		if !current(doc, held) {
+			return lease.ErrLost
+		}
+		doc.ExpiresAt = time.Now().Add(ttl)
+		renewed = &lease.Lease{Name: held.Name, Holder: held.Holder, Token: held.Token, ExpiresAt: doc.ExpiresAt}
+		return tx.Set(l.leases.Doc(held.Name), doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renewed, nil
+}
+
+func (l *Locker) Release(ctx context.Context, held *lease.Lease) error {
+	return l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := l.get(tx, held.Name)
+		if err != nil || !current(doc, held) {
+			return err
+		}
+		doc.Holder = ""
+		doc.ExpiresAt = time.Now()
+		return tx.Set(l.leases.Doc(held.Name), doc)
+	})
+}
+
+func (l *Locker) Revoke(ctx context.Context, name string) error {
+	return l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := l.get(tx, name)
+		if err != nil || doc.Holder == "" {
+			return err
+		}
+		doc.Holder = ""
+		doc.ExpiresAt = time.Now()
+		return tx.Set(l.leases.Doc(name), doc)
+	})
+}
+
+func (l *Locker) Current(ctx context.Context, name string) (*lease.Lease, error) {
+	snap, err := l.leases.Doc(name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc leaseDoc
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse lease %s: %v", name, err)
+	}
+	if doc.Holder == "" || !doc.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &lease.Lease{Name: name, Holder: doc.Holder, Token: doc.Token, ExpiresAt: doc.ExpiresAt}, nil
+}
+
+func (l *Locker) Check(ctx context.Context, held *lease.Lease) error {
+	return l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return l.CheckTx(tx, held)
+	}, firestore.ReadOnly)
+}
+
+// CheckTx fails with lease.ErrLost unless the lease is still current, as part
+// of a transaction whose writes it fences.
+func (l *Locker) CheckTx(tx *firestore.Transaction, held *lease.Lease) error {
+	doc, err := l.get(tx, held.Name)
+	if err != nil {
+		return err
+	}
+	if !current(doc, held) {
+		return lease.ErrLost
+	}
+	return nil
+}
+
+func (l *Locker) Watch(ctx context.Context, held *lease.Lease) <-chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		iter := l.leases.Doc(held.Name).Snapshots(ctx)
+		defer iter.Stop()
+		for {
+			snap, err := iter.Next()
+			if err != nil {
+				// Renewals find out whether the lease is still held
+				<-ctx.Done()
+				return
+			}
+			var doc leaseDoc
+			if snap.Exists() {
+				if err := snap.DataTo(&doc); err != nil {
+					return
+				}
+			}
+			if !current(&doc, held) {
+				return
+			}
+		}
+	}()
+	return lost
+}
+
+func current(doc *leaseDoc, held *lease.Lease) bool {
+	return doc.Holder == held.Holder && doc.Token == held.Token
+}
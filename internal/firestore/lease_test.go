@@ -0,0 +1,28 @@
+package firestore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease/leasetest"
+)
+
+// TestLocker runs against the Firestore emulator, started with
+// `gcloud emulators firestore start` and named by FIRESTORE_EMULATOR_HOST.
+func TestLocker(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	client, err := firestore.NewClient(context.Background(), "shiftclaiming-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	leasetest.Run(t, func(t *testing.T) lease.Locker {
+		// Every test starts from its own collection
+		return NewLocker(client, "leases-"+client.Collection("leases").NewDoc().ID)
+	})
+}
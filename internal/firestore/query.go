@@ -0,0 +1,68 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidCursor is returned by ListPage for a cursor that names no
+// document of the collection.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is one page of documents returned by ListPage. NextCursor is empty on
+// the last page.
+type Page struct {
+	Items      []map[string]interface{} `json:"items"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// ListPage runs the query starting after the document named by the cursor and
+// returns up to limit documents. The cursor is the ID of the last document of
+// the previous page, and every item carries its document ID under
+// "documentId".
+func ListPage(ctx context.Context, collection *firestore.CollectionRef, query firestore.Query, cursor string, limit int) (*Page, error) {
+	if cursor != "" {
+		cursorDoc := collection.Doc(cursor)
+		if cursorDoc == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+		}
+		cursorSnap, err := cursorDoc.Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cursor: %v", err)
+		}
+		query = query.StartAfter(cursorSnap)
+	}
+
+	// Fetch one extra document to know whether another page follows
+	iter := query.Limit(limit + 1).Documents(ctx)
+	defer iter.Stop()
+	page := &Page{Items: []map[string]interface{}{}}
+	var lastID string
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = lastID
+			break
+		}
+		item := doc.Data()
+		item["documentId"] = doc.Ref.ID
+		page.Items = append(page.Items, item)
+		lastID = doc.Ref.ID
+	}
+	return page, nil
+}
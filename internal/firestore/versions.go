@@ -0,0 +1,277 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// Redacted is stored in versions in place of the value of a secret field.
+const Redacted = "[redacted]"
+
+// Version is an immutable revision of a versioned document. Data holds the
+// whole document as it was after the write, so any version can be restored.
+type Version struct {
+	Version    int64                  `json:"version" firestore:"version"`
+	Document   string                 `json:"document" firestore:"document"`
+	Author     string                 `json:"author" firestore:"author"`
+	Timestamp  time.Time              `json:"timestamp" firestore:"timestamp"`
+	Diff       map[string]FieldChange `json:"diff" firestore:"diff"`
+	Data       map[string]interface{} `json:"data" firestore:"data"`
+	RollbackOf int64                  `json:"rollback_of,omitempty" firestore:"rollbackOf,omitempty"`
+}
+
+// FieldChange is the value of a field before and after a write. A nil value
+// means the field was absent.
+type FieldChange struct {
+	Before interface{} `json:"before" firestore:"before"`
+	After  interface{} `json:"after" firestore:"after"`
+}
+
+// VersionPage is one page of versions returned by History. NextCursor is empty
+// on the last page.
+type VersionPage struct {
+	Versions   []*Version `json:"versions"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// VersionStore writes documents through field updates and records every
+// change as a new version in its collection. The collection's "head" document
+// holds the latest version number; it has no version field, so it never shows
+// up in the history.
+//
+// Secret fields are written to their document but never to a version: their
+// values are replaced with Redacted, also when reading versions written before
+// the field was secret, and a rollback leaves them as they are.
+type VersionStore struct {
+	client   *firestore.Client
+	versions *firestore.CollectionRef
+	head     *firestore.DocumentRef
+	// secrets are the secret fields by document path
+	secrets map[string]map[string]bool
+}
+
+func NewVersionStore(client *firestore.Client, collection string) *VersionStore {
+	versions := client.Collection(collection)
+	return &VersionStore{
+		client:   client,
+		versions: versions,
+		head:     versions.Doc("head"),
+		secrets:  map[string]map[string]bool{},
+	}
+}
+
+// Secret marks fields of the document at the given path, such as
+// "configuration/auth", as secret. It must be called before the store is used.
+func (v *VersionStore) Secret(document string, fields ...string) *VersionStore {
+	if v.secrets[document] == nil {
+		v.secrets[document] = map[string]bool{}
+	}
+	for _, field := range fields {
+		v.secrets[document][field] = true
+	}
+	return v
+}
+
+// Merge updates the given fields of the document, leaving every other field
+// untouched. A firestore.Delete value removes the field. It returns nil when
+// no field changes.
+func (v *VersionStore) Merge(ctx context.Context, doc *firestore.DocumentRef, author string, fields map[string]interface{}) (*Version, error) {
+	return v.write(ctx, doc, author, 0, func(map[string]interface{}) map[string]interface{} {
+		return fields
+	})
+}
+
+// Rollback restores the document of the given version to its state after that
+// version, recorded as a new version. Secret fields keep their current value
+// since versions do not hold them.
+func (v *VersionStore) Rollback(ctx context.Context, version int64, author string) (*Version, error) {
+	target, err := v.Get(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	secrets := v.secrets[target.Document]
+	return v.write(ctx, v.client.Doc(target.Document), author, version, func(current map[string]interface{}) map[string]interface{} {
+		fields := map[string]interface{}{}
+		for field := range current {
+			if _, ok := target.Data[field]; !ok && !secrets[field] {
+				fields[field] = firestore.Delete
+			}
+		}
+		for field, value := range target.Data {
+			if !secrets[field] {
+				fields[field] = value
+			}
+		}
+		return fields
+	})
+}
+
+// Get returns a single version.
+func (v *VersionStore) Get(ctx context.Context, version int64) (*Version, error) {
+	snap, err := v.versions.Doc(versionID(version)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve version %d: %v", version, err)
+	}
+	var ver Version
+	if err := snap.DataTo(&ver); err != nil {
+		return nil, fmt.Errorf("failed to parse version %d: %v", version, err)
+	}
+	redact(&ver, v.secrets[ver.Document])
+	return &ver, nil
+}
+
+// History returns versions newest first, optionally only those of one
+// document. The cursor is the last version number of the previous page.
+func (v *VersionStore) History(ctx context.Context, document string, cursor int64, limit int) (*VersionPage, error) {
+	query := v.versions.Query
+	if document != "" {
+		query = query.Where("document", "==", document)
+	}
+	query = query.OrderBy("version", firestore.Desc)
+	if cursor > 0 {
+		query = query.StartAfter(cursor)
+	}
+
+	// Fetch one extra version to know whether another page follows
+	iter := query.Limit(limit + 1).Documents(ctx)
+	defer iter.Stop()
+	page := &VersionPage{Versions: []*Version{}}
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Versions) == limit {
+			page.NextCursor = fmt.Sprint(page.Versions[limit-1].Version)
+			break
+		}
+		var ver Version
+		if err := snap.DataTo(&ver); err != nil {
+			return nil, fmt.Errorf("failed to parse version %s: %v", snap.Ref.ID, err)
+		}
+		redact(&ver, v.secrets[ver.Document])
+		page.Versions = append(page.Versions, &ver)
+	}
+	return page, nil
+}
+
+// write applies the fields returned by change, given the current document, and
+// stores the resulting version in the same transaction.
+func (v *VersionStore) write(ctx context.Context, doc *firestore.DocumentRef, author string, rollbackOf int64, change func(current map[string]interface{}) map[string]interface{}) (*Version, error) {
+	var written *Version
+	err := v.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		written = nil
+		headSnap, err := tx.Get(v.head)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		docSnap, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var latest int64
+		if headSnap.Exists() {
+			latest, _ = headSnap.Data()["latest"].(int64)
+		}
+		current := map[string]interface{}{}
+		if docSnap.Exists() {
+			current = docSnap.Data()
+		}
+
+		fields := change(current)
+		data := map[string]interface{}{}
+		for field, value := range current {
+			data[field] = value
+		}
+		diff := map[string]FieldChange{}
+		var updates []firestore.Update
+		for field, value := range fields {
+			if value == firestore.Delete {
+				delete(data, field)
+			} else {
+				data[field] = value
+			}
+			if before, after := current[field], data[field]; !sameValue(before, after) {
+				diff[field] = FieldChange{Before: before, After: after}
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{field}, Value: value})
+			}
+		}
+		if len(diff) == 0 {
+			return nil
+		}
+		sort.Slice(updates, func(i, j int) bool { return updates[i].FieldPath[0] < updates[j].FieldPath[0] })
+
+		if docSnap.Exists() {
+			err = tx.Update(doc, updates)
+		} else {
+			err = tx.Create(doc, data)
+		}
+		if err != nil {
+			return err
+		}
+		written = &Version{
+			Version:    latest + 1,
+			Document:   doc.Parent.ID + "/" + doc.ID,
+			Author:     author,
+			Timestamp:  time.Now(),
+			Diff:       diff,
+			Data:       data,
+			RollbackOf: rollbackOf,
+		}
+		redact(written, v.secrets[written.Document])
+		if err := tx.Set(v.head, map[string]interface{}{"latest": written.Version}); err != nil {
+			return err
+		}
+		return tx.Create(v.versions.Doc(versionID(written.Version)), written)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", doc.ID, err)
+	}
+	return written, nil
+}
+
+// redact replaces the values of the secret fields in a version.
+func redact(version *Version, secrets map[string]bool) {
+	for field := range secrets {
+		if _, ok := version.Data[field]; ok {
+			version.Data[field] = Redacted
+		}
+		if change, ok := version.Diff[field]; ok {
+			if change.Before != nil {
+				change.Before = Redacted
+			}
+			if change.After != nil {
+				change.After = Redacted
+			}
+			version.Diff[field] = change
+		}
+	}
+}
+
+// sameValue compares stored and incoming values loosely, since Firestore
+// returns integers as int64 where JSON decodes them as float64.
+func sameValue(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b) || (a != nil && b != nil && fmt.Sprint(a) == fmt.Sprint(b))
+}
+
+// versionID pads version numbers so document IDs sort in version order.
+func versionID(version int64) string {
+	return fmt.Sprintf("%010d", version)
+}
@@ -0,0 +1,63 @@
+package firestore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	secrets := map[string]bool{"cookie": true, "x_api_token": true}
+	tests := []struct {
+		name    string
+		version Version
+		want    Version
+	}{
+		{
+			name: "changed secret",
+			version: Version{
+				Data: map[string]interface{}{"cookie": "new", "x_api_token": "token", "user_id": "42"},
+				Diff: map[string]FieldChange{"cookie": {Before: "old", After: "new"}, "user_id": {Before: "41", After: "42"}},
+			},
+			want: Version{
+				Data: map[string]interface{}{"cookie": Redacted, "x_api_token": Redacted, "user_id": "42"},
+				Diff: map[string]FieldChange{"cookie": {Before: Redacted, After: Redacted}, "user_id": {Before: "41", After: "42"}},
+			},
+		},
+		{
+			name: "added and removed secrets",
+			version: Version{
+				Data: map[string]interface{}{"cookie": "new"},
+				Diff: map[string]FieldChange{"cookie": {Before: nil, After: "new"}, "x_api_token": {Before: "token", After: nil}},
+			},
+			want: Version{
+				Data: map[string]interface{}{"cookie": Redacted},
+				Diff: map[string]FieldChange{"cookie": {Before: nil, After: Redacted}, "x_api_token": {Before: Redacted, After: nil}},
+			},
+		},
+		{
+			name: "no secrets",
+			version: Version{
+				Data: map[string]interface{}{"startStopFlag": true},
+				Diff: map[string]FieldChange{"startStopFlag": {Before: false, After: true}},
+			},
+			want: Version{
+				Data: map[string]interface{}{"startStopFlag": true},
+				Diff: map[string]FieldChange{"startStopFlag": {Before: false, After: true}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redact(&tt.version, secrets)
+			assert.Equal(t, tt.want, tt.version)
+		})
+	}
+}
+
+func TestSecretRegistersFields(t *testing.T) {
+	store := &VersionStore{secrets: map[string]map[string]bool{}}
+	store.Secret("configuration/auth", "cookie").Secret("configuration/auth", "x_api_token")
+	assert.Equal(t, map[string]bool{"cookie": true, "x_api_token": true}, store.secrets["configuration/auth"])
+	assert.Nil(t, store.secrets["configuration/config"])
+}
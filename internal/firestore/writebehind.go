@@ -0,0 +1,216 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+)
+
+// maxBatchWrites is the most writes Firestore accepts in one batch.
+const maxBatchWrites = 500
+
+// ErrClosed is returned for writes queued after the pipeline was closed.
+var ErrClosed = errors.New("write-behind buffer is closed")
+
+// WriteBehind buffers document writes and commits them in batches in the
+// background, so that callers on a latency-sensitive path do not wait for the
+// store. Writes are committed in the order they were given. A batch that
+// fails to commit is kept, together with every write after it, and retried on
+// the next commit.
+//
+// Writes made under a lease are fenced: they are committed only while the
+// lease is still current, so a holder that lost its lease cannot overwrite the
+// results of the next one.
+type WriteBehind struct {
+	client   *firestore.Client
+	locker   lease.Locker
+	writes   chan pendingWrite
+	flushes  chan chan error
+	closing  chan struct{}
+	done     chan struct{}
+	interval time.Duration
+
+	// mu guards closed against writes queued while the pipeline closes
+	mu     sync.RWMutex
+	closed bool
+	// err is the error of the last commit, once the pipeline has stopped
+	err error
+}
+
+type pendingWrite struct {
+	doc   *firestore.DocumentRef
+	data  interface{}
+	opts  []firestore.SetOption
+	fence *lease.Lease
+}
+
+// NewWriteBehind starts a pipeline buffering up to size writes and committing
+// them at least every interval. Fenced writes are checked against the leases
+// of locker.
+func NewWriteBehind(client *firestore.Client, locker lease.Locker, size int, interval time.Duration) *WriteBehind {
+	w := &WriteBehind{
+		client:   client,
+		locker:   locker,
+		writes:   make(chan pendingWrite, size),
+		flushes:  make(chan chan error),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+	go w.run()
+	return w
+}
+
+// Set queues a write of data to doc. It only blocks when the buffer is full,
+// and returns ErrClosed once the pipeline is closed.
+func (w *WriteBehind) Set(doc *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+	return w.queue(pendingWrite{doc: doc, data: data, opts: opts})
+}
+
+// SetFenced queues a write of data to doc that is dropped unless held is
+// still the current lease when it is committed. A nil lease leaves the write
+// unfenced.
+func (w *WriteBehind) SetFenced(held *lease.Lease, doc *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+	return w.queue(pendingWrite{doc: doc, data: data, opts: opts, fence: held})
+}
+
+func (w *WriteBehind) queue(write pendingWrite) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.writes <- write
+	return nil
+}
+
+// Flush waits until every write queued before it has been committed, and
+// returns the commit error when some of them could not be.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	ack := make(chan error, 1)
+	select {
+	case w.flushes <- ack:
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close commits the queued writes and stops the pipeline. Writes queued after
+// it is called are rejected with ErrClosed.
+func (w *WriteBehind) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *WriteBehind) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var pending []pendingWrite
+	for {
+		select {
+		case write := <-w.writes:
+			pending = append(pending, write)
+			if len(pending) >= maxBatchWrites {
+				pending, _ = w.commit(pending)
+			}
+		case <-ticker.C:
+			pending, _ = w.commit(pending)
+		case ack := <-w.flushes:
+			// Take the writes queued before the flush was requested
+			pending = w.drain(pending)
+			var err error
+			pending, err = w.commit(pending)
+			ack <- err
+		case <-w.closing:
+			// No writes are queued once closing is closed
+			_, w.err = w.commit(w.drain(pending))
+			return
+		}
+	}
+}
+
+// drain appends the writes waiting in the queue to pending.
+func (w *WriteBehind) drain(pending []pendingWrite) []pendingWrite {
+	for queued := len(w.writes); queued > 0; queued-- {
+		pending = append(pending, <-w.writes)
+	}
+	return pending
+}
+
+// commit writes the pending writes in batches. Each run of writes under the
+// same fence is committed separately. It returns the writes left to commit,
+// from the first run that failed on, and that run's error.
+func (w *WriteBehind) commit(pending []pendingWrite) ([]pendingWrite, error) {
+	for start := 0; start < len(pending); {
+		end := start + 1
+		for end < len(pending) && end-start < maxBatchWrites && pending[end].fence == pending[start].fence {
+			end++
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := w.commitRun(ctx, pending[start].fence, pending[start:end])
+		cancel()
+		switch {
+		case errors.Is(err, lease.ErrLost):
+			fmt.Printf(`{"message": "Dropped writes made under a lost lease", "writes": %d, "lease": "%s", "token": %d, "severity": "warning"}`+"\n", end-start, pending[start].fence.Name, pending[start].fence.Token)
+		case err != nil:
+			fmt.Printf(`{"message": "Failed to commit buffered writes, retrying", "writes": %d, "queued": %d, "error": "%v", "severity": "error"}`+"\n", end-start, len(pending)-start, err)
+			// Later writes wait for the failed run, as they may overwrite it
+			return append(pending[:0], pending[start:]...), fmt.Errorf("failed to commit %d buffered writes: %w", len(pending)-start, err)
+		}
+		start = end
+	}
+	return pending[:0], nil
+}
+
+func (w *WriteBehind) commitRun(ctx context.Context, fence *lease.Lease, writes []pendingWrite) error {
+	if fence == nil {
+		batch := w.client.Batch()
+		for _, write := range writes {
+			batch.Set(write.doc, write.data, write.opts...)
+		}
+		_, err := batch.Commit(ctx)
+		return err
+	}
+	if locker, ok := w.locker.(*Locker); ok {
+		// The lease is checked in the transaction that applies the writes
+		return w.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if err := locker.CheckTx(tx, fence); err != nil {
+				return err
+			}
+			for _, write := range writes {
+				if err := tx.Set(write.doc, write.data, write.opts...); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := w.locker.Check(ctx, fence); err != nil {
+		return err
+	}
+	return w.commitRun(ctx, nil, writes)
+}
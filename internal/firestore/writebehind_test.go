@@ -0,0 +1,114 @@
+package firestore
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// commitServer is an in-process Firestore that fails its first failures
+// commits and notes the documents of the rest, in the order written.
+type commitServer struct {
+	firestorepb.UnimplementedFirestoreServer
+
+	mu        sync.Mutex
+	failures  int
+	committed []string
+}
+
+func (c *commitServer) Commit(ctx context.Context, req *firestorepb.CommitRequest) (*firestorepb.CommitResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return nil, status.Error(codes.FailedPrecondition, "commit refused")
+	}
+	resp := &firestorepb.CommitResponse{CommitTime: timestamppb.Now()}
+	for _, write := range req.Writes {
+		name := write.GetUpdate().GetName()
+		c.committed = append(c.committed, name[strings.LastIndex(name, "/")+1:])
+		resp.WriteResults = append(resp.WriteResults, &firestorepb.WriteResult{UpdateTime: resp.CommitTime})
+	}
+	return resp, nil
+}
+
+func (c *commitServer) docs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.committed...)
+}
+
+// newWriteBehind returns a pipeline committing to server only when flushed or
+// closed.
+func newWriteBehind(t *testing.T, server *commitServer) (*WriteBehind, *firestore.Client) {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	client, err := firestore.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return NewWriteBehind(client, nil, 10, time.Hour), client
+}
+
+func TestWriteBehindRetriesFailedBatches(t *testing.T) {
+	server := &commitServer{failures: 1}
+	writes, client := newWriteBehind(t, server)
+	ctx := context.Background()
+
+	require.NoError(t, writes.Set(client.Doc("claims/a"), map[string]interface{}{"n": 1}))
+	assert.Error(t, writes.Flush(ctx), "the failed commit is reported")
+	assert.Empty(t, server.docs())
+
+	require.NoError(t, writes.Set(client.Doc("claims/b"), map[string]interface{}{"n": 2}))
+	require.NoError(t, writes.Flush(ctx))
+	assert.Equal(t, []string{"a", "b"}, server.docs(), "the failed write is retried ahead of later ones")
+	require.NoError(t, writes.Close(ctx))
+}
+
+func TestWriteBehindRejectsWritesAfterClose(t *testing.T) {
+	server := &commitServer{}
+	writes, client := newWriteBehind(t, server)
+	ctx := context.Background()
+
+	require.NoError(t, writes.Set(client.Doc("claims/a"), map[string]interface{}{"n": 1}))
+	require.NoError(t, writes.Close(ctx))
+	assert.Equal(t, []string{"a"}, server.docs(), "queued writes are committed on close")
+
+	assert.ErrorIs(t, writes.Set(client.Doc("claims/b"), map[string]interface{}{"n": 2}), ErrClosed)
+	assert.ErrorIs(t, writes.SetFenced(nil, client.Doc("claims/c"), map[string]interface{}{"n": 3}), ErrClosed)
+	assert.NoError(t, writes.Flush(ctx))
+	assert.NoError(t, writes.Close(ctx), "closing again is harmless")
+	assert.Equal(t, []string{"a"}, server.docs())
+}
+
+func TestWriteBehindCloseReportsUncommittedWrites(t *testing.T) {
+	server := &commitServer{failures: 1}
+	writes, client := newWriteBehind(t, server)
+
+	require.NoError(t, writes.Set(client.Doc("claims/a"), map[string]interface{}{"n": 1}))
+	assert.Error(t, writes.Close(context.Background()))
+	assert.Error(t, writes.Flush(context.Background()), "the writes stay uncommitted")
+}
@@ -0,0 +1,45 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrHeld = errors.New("lease is held by another holder")
+	ErrLost = errors.New("lease was lost")
+)
+
+// Lease grants its holder a named lock until it expires. The fencing token
+// increases with every new lease on the name, so writes made under a lease can
+// be rejected once a later lease has been granted.
+type Lease struct {
+	Name      string    `json:"name"`
+	Holder    string    `json:"holder"`
+	Token     int64     `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Locker grants leases. A holder acquiring a lease it already holds renews it
+// with the same token.
+type Locker interface {
+	// Acquire takes the named lease for ttl, failing with ErrHeld while
+	// another holder has it.
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (*Lease, error)
+	// Renew extends the lease by ttl, failing with ErrLost once it has been
+	// revoked or granted to another holder. An expired lease that nobody
+	// took over can still be renewed.
+	Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error)
+	// Release gives the lease up if it is still current.
+	Release(ctx context.Context, lease *Lease) error
+	// Revoke ends the named lease whoever holds it.
+	Revoke(ctx context.Context, name string) error
+	// Current returns the unexpired lease on the name, or nil.
+	Current(ctx context.Context, name string) (*Lease, error)
+	// Check fails with ErrLost unless the lease is still current.
+	Check(ctx context.Context, lease *Lease) error
+	// Watch returns a channel that is closed once the lease is lost, or when
+	// ctx is done.
+	Watch(ctx context.Context, lease *Lease) <-chan struct{}
+}
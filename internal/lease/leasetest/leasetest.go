@@ -0,0 +1,201 @@
+// Package leasetest tests implementations of lease.Locker against the same
+// steps, so that every lock store fences writes alike.
+package leasetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+)
+
+// step is an operation on the "poller" lease. Operations other than acquire
+// use the lease last granted to the step's holder.
+type step struct {
+	op        string
+	holder    string
+	ttl       time.Duration
+	wantErr   error
+	wantToken int64
+}
+
+const (
+	opAcquire = "acquire"
+	opRenew   = "renew"
+	opRelease = "release"
+	opRevoke  = "revoke"
+	opCheck   = "check"
+	opCurrent = "current"
+	opExpire  = "expire"
+)
+
+var lockerTests = []struct {
+	name  string
+	steps []step
+}{
+	{"acquire free lease", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opCheck, holder: "a"},
+		{op: opCurrent, holder: "a", wantToken: 1},
+	}},
+	{"held lease", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opAcquire, holder: "b", ttl: time.Minute, wantErr: lease.ErrHeld},
+		{op: opCheck, holder: "a"},
+	}},
+	{"holder reacquires with the same token", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opCheck, holder: "a"},
+	}},
+	{"renew", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opRenew, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opCheck, holder: "a"},
+	}},
+	{"release frees the lease and the next token is higher", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opRelease, holder: "a"},
+		{op: opCurrent},
+		{op: opCheck, holder: "a", wantErr: lease.ErrLost},
+		{op: opRenew, holder: "a", ttl: time.Minute, wantErr: lease.ErrLost},
+		{op: opAcquire, holder: "b", ttl: time.Minute, wantToken: 2},
+	}},
+	{"stale release leaves the new lease", []step{
+		{op: opAcquire, holder: "a", ttl: time.Millisecond, wantToken: 1},
+		{op: opExpire},
+		{op: opAcquire, holder: "b", ttl: time.Minute, wantToken: 2},
+		{op: opRelease, holder: "a"},
+		{op: opCurrent, holder: "b", wantToken: 2},
+		{op: opCheck, holder: "b"},
+	}},
+	{"revoke", []step{
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opRevoke},
+		{op: opCheck, holder: "a", wantErr: lease.ErrLost},
+		{op: opRenew, holder: "a", ttl: time.Minute, wantErr: lease.ErrLost},
+		{op: opAcquire, holder: "a", ttl: time.Minute, wantToken: 2},
+		{op: opRevoke},
+		{op: opRevoke},
+	}},
+	{"takeover after expiry", []step{
+		{op: opAcquire, holder: "a", ttl: time.Millisecond, wantToken: 1},
+		{op: opExpire},
+		{op: opCurrent},
+		{op: opAcquire, holder: "b", ttl: time.Minute, wantToken: 2},
+		{op: opCheck, holder: "a", wantErr: lease.ErrLost},
+		{op: opRenew, holder: "a", ttl: time.Minute, wantErr: lease.ErrLost},
+		{op: opCheck, holder: "b"},
+	}},
+	{"expired lease nobody took over can be renewed", []step{
+		{op: opAcquire, holder: "a", ttl: time.Millisecond, wantToken: 1},
+		{op: opExpire},
+		{op: opRenew, holder: "a", ttl: time.Minute, wantToken: 1},
+		{op: opCurrent, holder: "a", wantToken: 1},
+		{op: opAcquire, holder: "b", ttl: time.Minute, wantErr: lease.ErrHeld},
+	}},
+}
+
+// Run tests the fencing semantics of a Locker. newLocker returns a locker with
+// no leases for every test.
+func Run(t *testing.T, newLocker func(t *testing.T) lease.Locker) {
+	for _, tt := range lockerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			runSteps(t, newLocker(t), tt.steps)
+		})
+	}
+	t.Run("watch", func(t *testing.T) {
+		runWatch(t, newLocker)
+	})
+}
+
+func runSteps(t *testing.T, locker lease.Locker, steps []step) {
+	ctx := context.Background()
+	held := map[string]*lease.Lease{}
+	for i, step := range steps {
+		var got *lease.Lease
+		var err error
+		switch step.op {
+		case opAcquire:
+			got, err = locker.Acquire(ctx, "poller", step.holder, step.ttl)
+			if err == nil {
+				held[step.holder] = got
+			}
+		case opRenew:
+			got, err = locker.Renew(ctx, held[step.holder], step.ttl)
+		case opRelease:
+			err = locker.Release(ctx, held[step.holder])
+		case opRevoke:
+			err = locker.Revoke(ctx, "poller")
+		case opCheck:
+			err = locker.Check(ctx, held[step.holder])
+		case opCurrent:
+			got, err = locker.Current(ctx, "poller")
+			require.NoError(t, err, "step %d", i)
+			if step.holder == "" {
+				assert.Nil(t, got, "step %d", i)
+				continue
+			}
+			require.NotNil(t, got, "step %d", i)
+			assert.Equal(t, step.holder, got.Holder, "step %d", i)
+		case opExpire:
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		if step.wantErr != nil {
+			assert.ErrorIs(t, err, step.wantErr, "step %d", i)
+			continue
+		}
+		require.NoError(t, err, "step %d", i)
+		if got != nil {
+			assert.Equal(t, step.wantToken, got.Token, "step %d", i)
+		}
+	}
+}
+
+func runWatch(t *testing.T, newLocker func(t *testing.T) lease.Locker) {
+	ctx := context.Background()
+	for _, end := range []string{"release", "revoke", "takeover", "cancel"} {
+		t.Run(end, func(t *testing.T) {
+			locker := newLocker(t)
+			ttl := time.Minute
+			if end == "takeover" {
+				ttl = time.Millisecond
+			}
+			held, err := locker.Acquire(ctx, "poller", "a", ttl)
+			require.NoError(t, err)
+			watchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			lost := locker.Watch(watchCtx, held)
+
+			// Renewing keeps the lease
+			_, err = locker.Renew(ctx, held, ttl)
+			require.NoError(t, err)
+			select {
+			case <-lost:
+				t.Fatal("lease reported lost while held")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			switch end {
+			case "release":
+				require.NoError(t, locker.Release(ctx, held))
+			case "revoke":
+				require.NoError(t, locker.Revoke(ctx, "poller"))
+			case "takeover":
+				_, err := locker.Acquire(ctx, "poller", "b", time.Minute)
+				require.NoError(t, err)
+			case "cancel":
+				cancel()
+			}
+			select {
+			case <-lost:
+			case <-time.After(time.Second):
+				t.Fatal("lease not reported lost")
+			}
+		})
+	}
+}
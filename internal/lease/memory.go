@@ -0,0 +1,124 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memory is a Locker for a single process.
+type Memory struct {
+	mu      sync.Mutex
+	leases  map[string]*Lease
+	tokens  map[string]int64
+	changed chan struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		leases:  map[string]*Lease{},
+		tokens:  map[string]int64{},
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes the watchers. It must be called with the lock held.
+func (m *Memory) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *Memory) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (*Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	current := m.leases[name]
+	if current != nil && current.Holder == holder {
+		current.ExpiresAt = now.Add(ttl)
+		lease := *current
+		return &lease, nil
+	}
+	if current != nil && current.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("%w: %s", ErrHeld, current.Holder)
+	}
+	m.tokens[name]++
+	m.leases[name] = &Lease{Name: name, Holder: holder, Token: m.tokens[name], ExpiresAt: now.Add(ttl)}
+	m.notify()
+	lease := *m.leases[name]
+	return &lease, nil
+}
+
+func (m *Memory) Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.leases[lease.Name]
+	if current == nil || current.Token != lease.Token {
+		return nil, ErrLost
+	}
+	current.ExpiresAt = time.Now().Add(ttl)
+	renewed := *current
+	return &renewed, nil
+}
+
+func (m *Memory) Release(ctx context.Context, lease *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current := m.leases[lease.Name]; current != nil && current.Token == lease.Token {
+		delete(m.leases, lease.Name)
+		m.notify()
+	}
+	return nil
+}
+
+func (m *Memory) Revoke(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[name]; ok {
+		delete(m.leases, name)
+		m.notify()
+	}
+	return nil
+}
+
+func (m *Memory) Current(ctx context.Context, name string) (*Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.leases[name]
+	if current == nil || !current.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	lease := *current
+	return &lease, nil
+}
+
+func (m *Memory) Check(ctx context.Context, lease *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current := m.leases[lease.Name]; current == nil || current.Token != lease.Token {
+		return ErrLost
+	}
+	return nil
+}
+
+func (m *Memory) Watch(ctx context.Context, lease *Lease) <-chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for {
+			m.mu.Lock()
+			current := m.leases[lease.Name]
+			changed := m.changed
+			m.mu.Unlock()
+			if current == nil || current.Token != lease.Token {
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lost
+}
@@ -0,0 +1,14 @@
+package lease_test
+
+import (
+	"testing"
+
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease/leasetest"
+)
+
+func TestMemory(t *testing.T) {
+	leasetest.Run(t, func(t *testing.T) lease.Locker {
+		return lease.NewMemory()
+	})
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shiftclaiming"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	// PollsTotal counts polls of the swapboard by outcome.
+	PollsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "polls_total",
+		Help:      "Polls of the swapboard by outcome.",
+	}, []string{"outcome"})
+
+	// ShiftsSeenTotal counts shifts returned by the swapboard.
+	ShiftsSeenTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shifts_seen_total",
+		Help:      "Shifts returned by the swapboard.",
+	})
+
+	// SwapboardRecordErrorsTotal counts swapboard records skipped because
+	// they could not be decoded.
+	SwapboardRecordErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "swapboard_record_errors_total",
+		Help:      "Swapboard records skipped because they could not be decoded.",
+	})
+
+	// ClaimsTotal counts claim attempts by result.
+	ClaimsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "claims_total",
+		Help:      "Claim attempts by result.",
+	}, []string{"result"})
+
+	// ClaimVerificationsTotal counts successful claims checked against the
+	// user's schedule, by whether they were found on it.
+	ClaimVerificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "claim_verifications_total",
+		Help:      "Successful claims checked against the user's schedule by result.",
+	}, []string{"result"})
+
+	// CooldownsTotal counts cooldowns by the poll result that caused them.
+	CooldownsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cooldowns_total",
+		Help:      "Cooldowns by reason.",
+	}, []string{"reason"})
+
+	// PortalRequestDuration observes requests to the portal by endpoint and
+	// status code, or "error" when no response was received.
+	PortalRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "portal_request_duration_seconds",
+		Help:      "Latency of portal requests by endpoint and status.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint", "status"})
+
+	// PortalConnectionsTotal counts the connections portal requests went
+	// over by endpoint and whether the connection was reused.
+	PortalConnectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "portal_connections_total",
+		Help:      "Connections used by portal requests by endpoint and reuse.",
+	}, []string{"endpoint", "reused"})
+
+	// TasksTotal counts Cloud Tasks operations by operation and result.
+	TasksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloud_tasks_total",
+		Help:      "Cloud Tasks created and deleted by result.",
+	}, []string{"operation", "result"})
+
+	// StoreWriteDuration observes Firestore writes by RPC method.
+	StoreWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_write_duration_seconds",
+		Help:      "Latency of Firestore writes by method and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "result"})
+
+	// PollerState is 1 for the current state of the poller and 0 otherwise.
+	PollerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "poller_state",
+		Help:      "Current poller state, 1 for the active state.",
+	}, []string{"state"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		PollsTotal,
+		ShiftsSeenTotal,
+		SwapboardRecordErrorsTotal,
+		ClaimsTotal,
+		ClaimVerificationsTotal,
+		CooldownsTotal,
+		PortalRequestDuration,
+		PortalConnectionsTotal,
+		TasksTotal,
+		StoreWriteDuration,
+		PollerState,
+	)
+}
+
+// SetPollerState marks state as the current poller state among states.
+func SetPollerState(state string, states []string) {
+	for _, s := range states {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		PollerState.WithLabelValues(s).Set(value)
+	}
+}
+
+// Result returns the result label for an error.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
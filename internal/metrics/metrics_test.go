@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPollerState(t *testing.T) {
+	states := []string{"running", "paused", "stopped"}
+	SetPollerState("paused", states)
+	assert.Equal(t, 0.0, testutil.ToFloat64(PollerState.WithLabelValues("running")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(PollerState.WithLabelValues("paused")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(PollerState.WithLabelValues("stopped")))
+
+	SetPollerState("stopped", states)
+	assert.Equal(t, 0.0, testutil.ToFloat64(PollerState.WithLabelValues("paused")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(PollerState.WithLabelValues("stopped")))
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, "ok", Result(nil))
+	assert.Equal(t, "error", Result(errors.New("unavailable")))
+}
+
+func TestHandler(t *testing.T) {
+	PollsTotal.WithLabelValues("ok").Inc()
+	ClaimsTotal.WithLabelValues("success").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `shiftclaiming_polls_total{outcome="ok"}`)
+	assert.Contains(t, body, `shiftclaiming_claims_total{result="success"}`)
+	assert.Contains(t, body, "go_goroutines", "runtime metrics are exported with the service's")
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// signaturePattern matches the signature of a signed link, whose query
+// separator JSON may have escaped. Whoever has a signed link can use it, so
+// signatures are kept out of the log.
+var signaturePattern = regexp.MustCompile(`((?:[?&]|\\u0026)sig=)[^&\s"\\]+`)
+
+// Client posts notifications to a chat webhook. Without a webhook URL the
+// notifications are only written to the log, where signed links lose their
+// signatures.
+type Client struct {
+	webhookURL string
+	httpClient *http.Client
+}
+
+func NewClient(webhookURL string) *Client {
+	// Initialize and return a new notification client
+	return &Client{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send delivers a notification. The "text" field is understood by Slack and
+// Google Chat incoming webhooks; the structured fields are kept for other
+// consumers.
+func (c *Client) Send(ctx context.Context, subject, text string, fields map[string]interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"subject": subject,
+		"text":    fmt.Sprintf("*%s*\n%s", subject, text),
+		"fields":  fields,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %v", err)
+	}
+	fmt.Printf(`{"message": "Notification", "notification": %s, "severity": "notice"}`+"\n", redactSignatures(payload))
+	if c.webhookURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notification webhook returned %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// redactSignatures replaces the signatures of the links in a notification.
+func redactSignatures(payload []byte) []byte {
+	return signaturePattern.ReplaceAll(payload, []byte("${1}REDACTED"))
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSignatures(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "escaped separator",
+			text: "Claim it: https://example.com/claim/link?exp=1&n=ab12&schid=7&sig=0f3a9c",
+			want: "Claim it: https://example.com/claim/link?exp=1&n=ab12&schid=7&sig=REDACTED",
+		},
+		{
+			name: "first parameter",
+			text: "https://example.com/claim/link?sig=0f3a9c&schid=7",
+			want: "https://example.com/claim/link?sig=REDACTED&schid=7",
+		},
+		{
+			name: "no link",
+			text: "Shift 7 matched rule \"nights\"",
+			want: "Shift 7 matched rule \"nights\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(map[string]interface{}{"text": tt.text, "fields": map[string]interface{}{"claimLink": tt.text}})
+			assert.NoError(t, err)
+
+			var redacted struct {
+				Text   string `json:"text"`
+				Fields struct {
+					ClaimLink string `json:"claimLink"`
+				} `json:"fields"`
+			}
+			assert.NoError(t, json.Unmarshal(redactSignatures(payload), &redacted))
+			assert.Equal(t, tt.want, redacted.Text)
+			assert.Equal(t, tt.want, redacted.Fields.ClaimLink)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package portal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+)
+
+// maxDrainBytes is the most of an unread response body that is read on close
+// so that its connection can be reused.
+const maxDrainBytes = 64 << 10
+
+// Options configure the connections to the portal. Zero values take the
+// defaults.
+type Options struct {
+	// Timeout bounds each request, including reading its response body.
+	Timeout time.Duration
+	// MaxConns is the number of connections kept open to the portal.
+	MaxConns int
+	// IdleTimeout is how long an unused connection is kept open.
+	IdleTimeout time.Duration
+	// ProxyURL routes requests through a proxy. Without it the proxy is
+	// taken from the environment.
+	ProxyURL string
+	// CAFile is a PEM file of certificates trusted in addition to the
+	// system roots.
+	CAFile string
+	// SchedulePath is the path of the user's schedule, for portals that do
+	// not serve it at SchedulePath.
+	SchedulePath string
+}
+
+// Client sends requests to the portal over a shared pool of kept-alive
+// connections.
+type Client struct {
+	http    *http.Client
+	timeout time.Duration
+	conns   int
+	// schedulePath is the path of the user's schedule
+	schedulePath string
+
+	mu   sync.Mutex
+	warm *time.Timer
+}
+
+// NewClient builds the transport to the portal from the options.
+func NewClient(opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxConns <= 0 {
+		opts.MaxConns = 8
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 90 * time.Second
+	}
+	if opts.SchedulePath == "" {
+		opts.SchedulePath = SchedulePath
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid portal proxy URL: %v", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read portal CA file: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in portal CA file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          opts.MaxConns,
+		MaxIdleConnsPerHost:   opts.MaxConns,
+		IdleConnTimeout:       opts.IdleTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &Client{
+		http:         &http.Client{Transport: transport},
+		timeout:      opts.Timeout,
+		conns:        opts.MaxConns,
+		schedulePath: opts.SchedulePath,
+	}, nil
+}
+
+// ScheduleURL returns the address of the user's schedule, the shifts already
+// assigned to them.
+func (c *Client) ScheduleURL() string {
+	return BaseURL + c.schedulePath
+}
+
+// send sends a request under the client's deadline, counting whether it went
+// over a reused connection. The deadline is lifted when the response body is
+// closed.
+func (c *Client) send(endpoint string, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.PortalConnectionsTotal.WithLabelValues(endpoint, strconv.FormatBool(info.Reused)).Inc()
+		},
+	})
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// deadlineBody drains what is left of a response body on close, so that the
+// connection goes back to the pool, and then ends the request's deadline.
+type deadlineBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *deadlineBody) Close() error {
+	_, _ = io.Copy(io.Discard, io.LimitReader(b.ReadCloser, maxDrainBytes))
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Warm opens connections to the portal ahead of a poll, as many as the pool
+// keeps, so that the poll does not wait for TLS handshakes.
+func (c *Client) Warm(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, BaseURL, nil)
+			if err != nil {
+				return
+			}
+			resp, err := c.send(EndpointWarm, req)
+			if err != nil {
+				fmt.Printf(`{"message": "Failed to warm portal connection", "error": "%v", "severity": "warning"}`+"\n", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// WarmAt warms the connections at t, replacing any warm-up planned before.
+func (c *Client) WarmAt(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.warm != nil {
+		c.warm.Stop()
+	}
+	c.warm = time.AfterFunc(time.Until(t), func() {
+		c.Warm(context.Background())
+	})
+}
+
+// Close cancels any planned warm-up and closes the idle connections.
+func (c *Client) Close() {
+	c.mu.Lock()
+	if c.warm != nil {
+		c.warm.Stop()
+	}
+	c.mu.Unlock()
+	c.http.CloseIdleConnections()
+}
@@ -0,0 +1,111 @@
+package portal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// portalServer is a portal that counts the connections opened to it and the
+// warm-up requests it served.
+type portalServer struct {
+	*httptest.Server
+	conns atomic.Int32
+	warms atomic.Int32
+}
+
+// newPortalServer starts a portal and points BaseURL at it for the test.
+func newPortalServer(t *testing.T) *portalServer {
+	t.Helper()
+	p := &portalServer{}
+	p.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			p.warms.Add(1)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	p.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			p.conns.Add(1)
+		}
+	}
+	p.Start()
+	t.Cleanup(p.Close)
+	baseURL := BaseURL
+	BaseURL = p.URL
+	t.Cleanup(func() { BaseURL = baseURL })
+	return p
+}
+
+func newTestClient(t *testing.T, conns int) *Client {
+	t.Helper()
+	client, err := NewClient(Options{MaxConns: conns})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func get(t *testing.T, client *Client) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, BaseURL+"/swapboard", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(EndpointSwapboard, req)
+	require.NoError(t, err)
+	// Closing the body unread must still hand the connection back
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestClientWarm(t *testing.T) {
+	server := newPortalServer(t)
+	client := newTestClient(t, 4)
+
+	client.Warm(context.Background())
+	assert.Equal(t, int32(4), server.warms.Load(), "one warm-up request per pooled connection")
+	opened := server.conns.Load()
+	assert.Positive(t, opened)
+	assert.LessOrEqual(t, opened, int32(4))
+
+	for i := 0; i < 10; i++ {
+		get(t, client)
+	}
+	assert.Equal(t, opened, server.conns.Load(), "requests after a warm-up reuse its connections")
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	server := newPortalServer(t)
+	client := newTestClient(t, 2)
+
+	for i := 0; i < 10; i++ {
+		get(t, client)
+	}
+	assert.Equal(t, int32(1), server.conns.Load())
+}
+
+func TestClientWarmAt(t *testing.T) {
+	server := newPortalServer(t)
+	client := newTestClient(t, 2)
+
+	client.WarmAt(time.Now().Add(time.Hour))
+	// Replaces the warm-up planned before
+	client.WarmAt(time.Now().Add(10 * time.Millisecond))
+	require.Eventually(t, func() bool { return server.warms.Load() == 2 }, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestClientCloseCancelsWarmAt(t *testing.T) {
+	server := newPortalServer(t)
+	client := newTestClient(t, 2)
+
+	client.WarmAt(time.Now().Add(50 * time.Millisecond))
+	client.Close()
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, server.warms.Load(), "no warm-up runs after Close")
+	assert.Zero(t, server.conns.Load())
+}
@@ -0,0 +1,49 @@
+package portal
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BaseURL is the address of the portal. It is a variable so that tools can
+// point the service at a fake portal.
+var BaseURL = "https://tmwork.net"
+
+const (
+	SwapboardPath = "/api/shift/swapboard"
+	ClaimPath     = "/api/shift/swap/claim"
+	// SchedulePath is where the user's own shifts are listed, unless the
+	// client is given another path
+	SchedulePath = "/api/shift/schedule"
+)
+
+// Endpoint labels for portal requests.
+const (
+	EndpointSwapboard = "swapboard"
+	EndpointClaim     = "claim"
+	EndpointWarm      = "warm"
+	EndpointSchedule  = "schedule"
+)
+
+// Do sends a request to the portal in a span under the request's context and
+// records its latency under the given endpoint label.
+func (c *Client) Do(endpoint string, req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), "portal."+endpoint, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(attribute.String("http.method", req.Method), attribute.String("portal.endpoint", endpoint))
+	start := time.Now()
+	resp, err := c.send(endpoint, req.WithContext(ctx))
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	}
+	metrics.PortalRequestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	return resp, err
+}
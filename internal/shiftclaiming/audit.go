@@ -0,0 +1,250 @@
+package shiftclaiming
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/iterator"
+)
+
+// Sources an operation can originate from.
+const (
+	SourceHTTP      = "http"
+	SourcePubSub    = "pubsub"
+	SourceScheduler = "scheduler"
+	SourceWatchdog  = "watchdog"
+	SourcePoller    = "poller"
+)
+
+// Audited actions.
+const (
+	AuditStart             = "start"
+	AuditStop              = "stop"
+	AuditUpdateShiftConfig = "update_shift_config"
+	AuditUpdateAuthConfig  = "update_auth_config"
+	AuditRollbackConfig    = "rollback_config"
+	AuditCreateCampaign    = "create_campaign"
+	AuditCancelCampaign    = "cancel_campaign"
+	AuditClaimLinkedShift  = "claim_linked_shift"
+	AuditPubSubCommand     = "pubsub_command"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailed  = "failed"
+)
+
+const (
+	// actorSystem is recorded for operations the service starts on its own,
+	// such as stopping once every campaign has finished.
+	actorSystem    = "system"
+	actorAnonymous = "anonymous"
+)
+
+// Origin identifies who requested an operation and through which channel.
+type Origin struct {
+	Actor     string
+	Source    string
+	RequestID string
+}
+
+// AuditEntry records a state-changing operation with the state it changed.
+type AuditEntry struct {
+	Timestamp time.Time   `json:"timestamp" firestore:"timestamp"`
+	Action    string      `json:"action" firestore:"action"`
+	Actor     string      `json:"actor" firestore:"actor"`
+	Source    string      `json:"source" firestore:"source"`
+	RequestID string      `json:"request_id" firestore:"requestId"`
+	Target    string      `json:"target,omitempty" firestore:"target,omitempty"`
+	Before    interface{} `json:"before" firestore:"before"`
+	After     interface{} `json:"after" firestore:"after"`
+	Outcome   string      `json:"outcome" firestore:"outcome"`
+	Error     string      `json:"error,omitempty" firestore:"error,omitempty"`
+}
+
+// AuditQuery filters the audit log. The Status of the history query filters
+// on the outcome.
+type AuditQuery struct {
+	HistoryQuery
+	Actor  string
+	Action string
+	Source string
+}
+
+// audit stores an entry for the operation. Failing to store it is logged but
+// does not fail the operation, which has already happened, so the entry is
+// stored even when ctx has been cancelled.
+func (s *Service) audit(ctx context.Context, origin Origin, action, target string, before, after interface{}, opErr error) {
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		Action:    action,
+		Actor:     origin.Actor,
+		Source:    origin.Source,
+		RequestID: origin.RequestID,
+		Target:    target,
+		Before:    before,
+		After:     after,
+		Outcome:   AuditSuccess,
+	}
+	if opErr != nil {
+		entry.Outcome = AuditFailed
+		entry.Error = opErr.Error()
+	}
+	ctx, cancel := s.storeContext(context.WithoutCancel(ctx))
+	defer cancel()
+	if _, _, err := s.firestoreClient.Collection("audit").Add(ctx, entry); err != nil {
+		fmt.Printf(`{"message": "Failed to record audit entry", "action": "%s", "request_id": "%s", "error": "%v", "severity": "error"}`+"\n", action, origin.RequestID, err)
+	}
+}
+
+// auditVersion records a configuration write with the fields it changed.
+func (s *Service) auditVersion(ctx context.Context, origin Origin, action, document string, version *firestores.Version, opErr error) {
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+	if version != nil {
+		for field, change := range version.Diff {
+			before[field] = change.Before
+			after[field] = change.After
+		}
+	}
+	target := ""
+	if document != "" {
+		target = "configuration/" + document
+	}
+	s.audit(ctx, origin, action, target, before, after, opErr)
+}
+
+// ListAudit returns audit entries, newest first.
+func (s *Service) ListAudit(ctx context.Context, q AuditQuery) (*firestores.Page, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	collection := s.firestoreClient.Collection("audit")
+	return firestores.ListPage(ctx, collection, auditQuery(collection, q), q.Cursor, q.Limit)
+}
+
+// ExportAudit passes every matching audit entry to write, newest first.
+func (s *Service) ExportAudit(ctx context.Context, q AuditQuery, write func(entry map[string]interface{}) error) error {
+	iter := auditQuery(s.firestoreClient.Collection("audit"), q).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve audit entries: %v", err)
+		}
+		entry := doc.Data()
+		entry["documentId"] = doc.Ref.ID
+		if err := write(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func auditQuery(collection *firestore.CollectionRef, q AuditQuery) firestore.Query {
+	query := historyQuery(collection, q.HistoryQuery)
+	if q.Status != "" {
+		query = query.Where("outcome", "==", q.Status)
+	}
+	if q.Actor != "" {
+		query = query.Where("actor", "==", q.Actor)
+	}
+	if q.Action != "" {
+		query = query.Where("action", "==", q.Action)
+	}
+	if q.Source != "" {
+		query = query.Where("source", "==", q.Source)
+	}
+	return query.OrderBy("timestamp", firestore.Desc)
+}
+
+// tokenValidator verifies a Google-signed ID token for an audience.
+type tokenValidator func(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+
+// requestOrigin derives the origin of an HTTP request from its verified
+// identity. Only requests authenticated as the tasks service account come from
+// Cloud Tasks, whatever their headers say: from the scheduler when each task
+// is a poll, and from the watchdog when the tasks are the heartbeat of the
+// poll loop. Their actor is the service account.
+func (s *Service) requestOrigin(r *http.Request, source string) Origin {
+	origin := Origin{Actor: s.requestActor(r), Source: source, RequestID: requestID(r)}
+	if s.config.TasksServiceAccount != "" && origin.Actor == s.config.TasksServiceAccount {
+		origin.Source = SourceScheduler
+		if s.config.PollerMode == PollerModeLoop {
+			origin.Source = SourceWatchdog
+		}
+		if taskName := r.Header.Get("X-CloudTasks-TaskName"); taskName != "" {
+			origin.RequestID = taskName
+		}
+	}
+	return origin
+}
+
+// requestActor returns the authenticated principal of a request: the user in
+// a verified Identity-Aware Proxy assertion, or the email or subject of a
+// verified bearer ID token. Anything that cannot be verified is anonymous.
+func (s *Service) requestActor(r *http.Request) string {
+	ctx := r.Context()
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	if assertion := r.Header.Get("X-Goog-IAP-JWT-Assertion"); assertion != "" && s.config.IAPAudience != "" {
+		payload, err := s.validateToken(ctx, assertion, s.config.IAPAudience)
+		if err == nil {
+			if actor := tokenActor(payload); actor != "" {
+				return actor
+			}
+		} else {
+			fmt.Printf(`{"message": "Rejected IAP assertion", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		payload, err := s.validateToken(ctx, token, s.config.AuthAudience)
+		if err == nil {
+			if actor := tokenActor(payload); actor != "" {
+				return actor
+			}
+		} else {
+			fmt.Printf(`{"message": "Rejected bearer token", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+	}
+	return actorAnonymous
+}
+
+// tokenActor names the principal of a verified token by its email, or its
+// subject when it has none.
+func tokenActor(payload *idtoken.Payload) string {
+	if email, _ := payload.Claims["email"].(string); email != "" {
+		return email
+	}
+	return payload.Subject
+}
+
+// requestID returns the trace ID Cloud Run assigns to the request, falling back
+// to X-Request-ID or a random ID.
+func requestID(r *http.Request) string {
+	if trace := r.Header.Get("X-Cloud-Trace-Context"); trace != "" {
+		return strings.SplitN(trace, "/", 2)[0]
+	}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+	"google.golang.org/api/idtoken"
+)
+
+func TestRequestOrigin(t *testing.T) {
+	s := &Service{
+		config: &config.Config{
+			TasksServiceAccount: "tasks@project.iam.gserviceaccount.com",
+			AuthAudience:        "https://service.example.com",
+			IAPAudience:         "/projects/1/global/backendServices/2",
+		},
+		// Tokens are "<audience>|<email>", and only those are valid
+		validateToken: func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+			valid := map[string]*idtoken.Payload{
+				"https://service.example.com|user":         {Subject: "1", Claims: map[string]interface{}{"email": "user@example.com"}},
+				"https://service.example.com|tasks":        {Subject: "2", Claims: map[string]interface{}{"email": "tasks@project.iam.gserviceaccount.com"}},
+				"https://service.example.com|noemail":      {Subject: "3", Claims: map[string]interface{}{}},
+				"/projects/1/global/backendServices/2|iap": {Subject: "4", Claims: map[string]interface{}{"email": "iap@example.com"}},
+			}
+			if payload, ok := valid[audience+"|"+token]; ok {
+				return payload, nil
+			}
+			return nil, errors.New("invalid token")
+		},
+	}
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantActor  string
+		wantSource string
+		wantID     string
+	}{
+		{
+			name:       "no credentials",
+			headers:    map[string]string{"X-Request-ID": "req"},
+			wantActor:  actorAnonymous,
+			wantSource: SourceHTTP,
+			wantID:     "req",
+		},
+		{
+			name:       "verified user",
+			headers:    map[string]string{"Authorization": "Bearer user", "X-Request-ID": "req"},
+			wantActor:  "user@example.com",
+			wantSource: SourceHTTP,
+			wantID:     "req",
+		},
+		{
+			name:       "token without email",
+			headers:    map[string]string{"Authorization": "Bearer noemail"},
+			wantActor:  "3",
+			wantSource: SourceHTTP,
+		},
+		{
+			name:       "unverified token",
+			headers:    map[string]string{"Authorization": "Bearer forged"},
+			wantActor:  actorAnonymous,
+			wantSource: SourceHTTP,
+		},
+		{
+			name:       "token for the IAP audience",
+			headers:    map[string]string{"Authorization": "Bearer iap"},
+			wantActor:  actorAnonymous,
+			wantSource: SourceHTTP,
+		},
+		{
+			name:       "verified IAP assertion",
+			headers:    map[string]string{"X-Goog-IAP-JWT-Assertion": "iap"},
+			wantActor:  "iap@example.com",
+			wantSource: SourceHTTP,
+		},
+		{
+			name:       "spoofed IAP email",
+			headers:    map[string]string{"X-Goog-Authenticated-User-Email": "accounts.google.com:admin@example.com"},
+			wantActor:  actorAnonymous,
+			wantSource: SourceHTTP,
+		},
+		{
+			name:       "verified task",
+			headers:    map[string]string{"Authorization": "Bearer tasks", "X-CloudTasks-TaskName": "task-1"},
+			wantActor:  "tasks@project.iam.gserviceaccount.com",
+			wantSource: SourceScheduler,
+			wantID:     "task-1",
+		},
+		{
+			name:       "spoofed task name",
+			headers:    map[string]string{"Authorization": "Bearer user", "X-CloudTasks-TaskName": "task-1", "X-Request-ID": "req"},
+			wantActor:  "user@example.com",
+			wantSource: SourceHTTP,
+			wantID:     "req",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/start", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			origin := s.requestOrigin(r, SourceHTTP)
+			assert.Equal(t, tt.wantActor, origin.Actor)
+			assert.Equal(t, tt.wantSource, origin.Source)
+			if tt.wantID != "" {
+				assert.Equal(t, tt.wantID, origin.RequestID)
+			}
+		})
+	}
+}
+
+func TestRequestOriginOfHeartbeat(t *testing.T) {
+	s := &Service{
+		config: &config.Config{
+			TasksServiceAccount: "tasks@project.iam.gserviceaccount.com",
+			AuthAudience:        "https://service.example.com",
+			PollerMode:          PollerModeLoop,
+		},
+		validateToken: func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+			return &idtoken.Payload{Claims: map[string]interface{}{"email": token}}, nil
+		},
+	}
+	r := httptest.NewRequest("POST", "/claim", nil)
+	r.Header.Set("Authorization", "Bearer tasks@project.iam.gserviceaccount.com")
+	r.Header.Set("X-CloudTasks-TaskName", "heartbeat-1")
+	assert.Equal(t, Origin{Actor: "tasks@project.iam.gserviceaccount.com", Source: SourceWatchdog, RequestID: "heartbeat-1"}, s.requestOrigin(r, SourceHTTP))
+
+	r.Header.Set("Authorization", "Bearer user@example.com")
+	assert.Equal(t, SourceHTTP, s.requestOrigin(r, SourceHTTP).Source)
+}
+
+func TestRequestOriginWithoutIAP(t *testing.T) {
+	s := &Service{
+		config: &config.Config{AuthAudience: "https://service.example.com"},
+		validateToken: func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+			return &idtoken.Payload{Claims: map[string]interface{}{"email": "iap@example.com"}}, nil
+		},
+	}
+	r := httptest.NewRequest("POST", "/start", nil)
+	r.Header.Set("X-Goog-IAP-JWT-Assertion", "iap")
+	assert.Equal(t, actorAnonymous, s.requestOrigin(r, SourceHTTP).Actor)
+}
@@ -0,0 +1,198 @@
+package shiftclaiming
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// claimBudget tracks the shifts claimed so far so that later candidates can be
+// rejected when they overlap one of them, exceed the weekly hours cap or do
+// not fit any active campaign.
+//
+// check and reserve are for a single caller. Concurrent claims go through
+// hold and settle instead.
+type claimBudget struct {
+	maxWeeklyHours float64
+	weeklyHours    map[string]float64
+	campaigns      []*Campaign
+	claimed        []Shift
+
+	mu       sync.Mutex
+	settled  *sync.Cond
+	inFlight int
+}
+
+func newClaimBudget(maxWeeklyHours float64, weeklyHours map[string]float64, campaigns []*Campaign) *claimBudget {
+	if weeklyHours == nil {
+		weeklyHours = map[string]float64{}
+	}
+	b := &claimBudget{
+		maxWeeklyHours: maxWeeklyHours,
+		weeklyHours:    weeklyHours,
+		campaigns:      campaigns,
+	}
+	b.settled = sync.NewCond(&b.mu)
+	return b
+}
+
+// check returns the reason the shift cannot be claimed, or an empty string when
+// it fits the remaining budget.
+func (b *claimBudget) check(shift Shift) string {
+	if _, err := time.Parse("2006-01-02T15:04:05", shift.Date); err != nil {
+		return fmt.Sprintf("invalid shift date %q", shift.Date)
+	}
+	if shift.Hours <= 0 {
+		// A shift of unknown length would pass any hours limit
+		return fmt.Sprintf("invalid shift hours %.2f", shift.Hours)
+	}
+	for _, claimed := range b.claimed {
+		if shiftsOverlap(claimed, shift) {
+			return fmt.Sprintf("conflicts with shift %d", claimed.SchId)
+		}
+	}
+	if b.maxWeeklyHours > 0 {
+		week := shiftWeek(shift)
+		if b.weeklyHours[week]+shift.Hours > b.maxWeeklyHours {
+			return fmt.Sprintf("exceeds weekly hours for %s (%.2f of %.2f used)", week, b.weeklyHours[week], b.maxWeeklyHours)
+		}
+	}
+	if len(b.campaigns) > 0 && b.campaignFor(shift) == nil {
+		return "no active campaign has budget left for this shift"
+	}
+	return ""
+}
+
+// reserve records a claimed shift against the budget and returns the ID of the
+// campaign it was counted against, if any.
+func (b *claimBudget) reserve(shift Shift) string {
+	b.claimed = append(b.claimed, shift)
+	b.weeklyHours[shiftWeek(shift)] += shift.Hours
+	campaign := b.campaignFor(shift)
+	if campaign == nil {
+		return ""
+	}
+	campaign.ClaimedShifts++
+	campaign.ClaimedHours += shift.Hours
+	return campaign.ID
+}
+
+// hold reserves the shift for a claim about to be sent, returning the campaign
+// it was counted against, or the reason it cannot be claimed. While other
+// claims are in flight a shift that does not fit waits for them to settle,
+// since a failed claim gives its share of the budget back.
+func (b *claimBudget) hold(shift Shift) (campaign string, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if reason = b.check(shift); reason == "" {
+			b.inFlight++
+			return b.reserve(shift), ""
+		}
+		if b.inFlight == 0 {
+			return "", reason
+		}
+		b.settled.Wait()
+	}
+}
+
+// settle completes a claim of a held shift, releasing the hold when the shift
+// was not claimed.
+func (b *claimBudget) settle(shift Shift, campaign string, claimed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight--
+	if !claimed {
+		b.release(shift, campaign)
+	}
+	b.settled.Broadcast()
+}
+
+// release undoes the reservation of a shift.
+func (b *claimBudget) release(shift Shift, campaign string) {
+	for i, claimed := range b.claimed {
+		if claimed.SchId == shift.SchId && claimed.Id == shift.Id {
+			b.claimed = append(b.claimed[:i], b.claimed[i+1:]...)
+			break
+		}
+	}
+	b.weeklyHours[shiftWeek(shift)] -= shift.Hours
+	for _, c := range b.campaigns {
+		if c.ID == campaign {
+			c.ClaimedShifts--
+			c.ClaimedHours -= shift.Hours
+		}
+	}
+}
+
+func (b *claimBudget) campaignFor(shift Shift) *Campaign {
+	for _, campaign := range b.campaigns {
+		if campaign.fits(shift) {
+			return campaign
+		}
+	}
+	return nil
+}
+
+// shiftWeek returns the ISO week of the shift date, e.g. "2024-W15".
+func shiftWeek(shift Shift) string {
+	shiftDate, err := time.Parse("2006-01-02T15:04:05", shift.Date)
+	if err != nil {
+		return ""
+	}
+	return dateWeek(shiftDate)
+}
+
+// dateWeek returns the ISO week of a date, e.g. "2024-W15".
+func dateWeek(date time.Time) string {
+	year, week := date.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// shiftsOverlap reports whether two shifts share any working time. Shifts whose
+// times cannot be parsed are never treated as conflicting.
+func shiftsOverlap(a, b Shift) bool {
+	aStart, aEnd, ok := shiftInterval(a)
+	if !ok {
+		return false
+	}
+	bStart, bEnd, ok := shiftInterval(b)
+	if !ok {
+		return false
+	}
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// shiftInterval resolves the Start and End fields against the shift date. The
+// portal reports them either as full timestamps or as times of day.
+func shiftInterval(shift Shift) (time.Time, time.Time, bool) {
+	shiftDate, err := time.Parse("2006-01-02T15:04:05", shift.Date)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start, ok := parseShiftTime(shiftDate, shift.Start)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	end, ok := parseShiftTime(shiftDate, shift.End)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	if !end.After(start) {
+		// Overnight shift
+		end = end.Add(24 * time.Hour)
+	}
+	return start, end, true
+}
+
+func parseShiftTime(shiftDate time.Time, value string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02T15:04:05", value); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"15:04", "15:04:05", "3:04 PM", "3:04PM", "3:04 pm", "3:04pm"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return time.Date(shiftDate.Year(), shiftDate.Month(), shiftDate.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+}
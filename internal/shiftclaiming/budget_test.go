@@ -0,0 +1,159 @@
+package shiftclaiming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func budgetShift(schID int, date, start, end string, hours float64) Shift {
+	return Shift{Id: schID, SchId: schID, Date: date + "T00:00:00", Start: start, End: end, Hours: hours}
+}
+
+func TestClaimBudgetCheck(t *testing.T) {
+	monday := budgetShift(1, "2024-05-06", "07:00", "15:00", 8)
+	tests := []struct {
+		name        string
+		maxWeekly   float64
+		weeklyHours map[string]float64
+		campaigns   []*Campaign
+		claimed     []Shift
+		shift       Shift
+		want        string
+	}{
+		{"no limits", 0, nil, nil, nil, monday, ""},
+		{"overlapping shift", 0, nil, nil, []Shift{budgetShift(2, "2024-05-06", "14:00", "22:00", 8)}, monday, "conflicts with shift 2"},
+		{"back to back shifts", 0, nil, nil, []Shift{budgetShift(2, "2024-05-06", "15:00", "23:00", 8)}, monday, ""},
+		{"overnight shift overlaps next morning", 0, nil, nil, []Shift{budgetShift(2, "2024-05-05", "23:00", "07:30", 8)}, monday, "conflicts with shift 2"},
+		{"within weekly hours", 40, map[string]float64{"2024-W19": 32}, nil, nil, monday, ""},
+		{"over weekly hours", 40, map[string]float64{"2024-W19": 36}, nil, nil, monday, "exceeds weekly hours for 2024-W19 (36.00 of 40.00 used)"},
+		{"other week's hours do not count", 40, map[string]float64{"2024-W18": 40}, nil, nil, monday, ""},
+		{"campaign with room", 0, nil, []*Campaign{{ID: "c", TargetShifts: 2}}, nil, monday, ""},
+		{"campaign met", 0, nil, []*Campaign{{ID: "c", TargetShifts: 1, ClaimedShifts: 1}}, nil, monday, "no active campaign has budget left for this shift"},
+		{"null hours", 40, nil, nil, nil, budgetShift(2, "2024-05-06", "07:00", "15:00", 0), "invalid shift hours 0.00"},
+		{"invalid date", 0, nil, nil, nil, Shift{Id: 2, SchId: 2, Date: "May 6", Hours: 8}, `invalid shift date "May 6"`},
+		{"shift outside campaign window", 0, nil, []*Campaign{{ID: "c", TargetHours: 40, WindowStart: "2024-05-07"}}, nil, monday, "no active campaign has budget left for this shift"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newClaimBudget(tt.maxWeekly, tt.weeklyHours, tt.campaigns)
+			budget.claimed = tt.claimed
+			assert.Equal(t, tt.want, budget.check(tt.shift))
+		})
+	}
+}
+
+func TestClaimBudgetHoldSettle(t *testing.T) {
+	first := budgetShift(1, "2024-05-06", "07:00", "15:00", 8)
+	second := budgetShift(2, "2024-05-07", "07:00", "15:00", 8)
+	type step struct {
+		hold         bool
+		shift        Shift
+		claimed      bool
+		wantCampaign string
+		wantReason   string
+	}
+	tests := []struct {
+		name       string
+		maxWeekly  float64
+		campaigns  []*Campaign
+		steps      []step
+		wantHours  float64
+		wantShifts int
+	}{
+		{
+			name:      "claimed hold keeps its share",
+			maxWeekly: 8,
+			campaigns: []*Campaign{{ID: "c", TargetShifts: 2}},
+			steps: []step{
+				{hold: true, shift: first, wantCampaign: "c"},
+				{shift: first, claimed: true},
+				{hold: true, shift: second, wantReason: "exceeds weekly hours for 2024-W19 (8.00 of 8.00 used)"},
+			},
+			wantHours:  8,
+			wantShifts: 1,
+		},
+		{
+			name:      "failed hold gives its share back",
+			maxWeekly: 8,
+			campaigns: []*Campaign{{ID: "c", TargetShifts: 1}},
+			steps: []step{
+				{hold: true, shift: first, wantCampaign: "c"},
+				{shift: first, claimed: false},
+				{hold: true, shift: second, wantCampaign: "c"},
+				{shift: second, claimed: true},
+			},
+			wantHours:  8,
+			wantShifts: 1,
+		},
+		{
+			name:      "campaign target reached",
+			campaigns: []*Campaign{{ID: "c", TargetShifts: 1}},
+			steps: []step{
+				{hold: true, shift: first, wantCampaign: "c"},
+				{shift: first, claimed: true},
+				{hold: true, shift: second, wantReason: "no active campaign has budget left for this shift"},
+			},
+			wantHours:  8,
+			wantShifts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newClaimBudget(tt.maxWeekly, nil, tt.campaigns)
+			campaigns := map[int]string{}
+			for i, step := range tt.steps {
+				if !step.hold {
+					budget.settle(step.shift, campaigns[step.shift.SchId], step.claimed)
+					continue
+				}
+				campaign, reason := budget.hold(step.shift)
+				assert.Equal(t, step.wantCampaign, campaign, "step %d", i)
+				assert.Equal(t, step.wantReason, reason, "step %d", i)
+				campaigns[step.shift.SchId] = campaign
+			}
+			assert.Equal(t, 0, budget.inFlight)
+			assert.Equal(t, tt.wantHours, budget.weeklyHours["2024-W19"])
+			if len(tt.campaigns) > 0 {
+				assert.Equal(t, tt.wantShifts, tt.campaigns[0].ClaimedShifts)
+				assert.Equal(t, tt.wantHours, tt.campaigns[0].ClaimedHours)
+			}
+		})
+	}
+}
+
+func TestClaimBudgetHoldWaitsForInFlightClaims(t *testing.T) {
+	first := budgetShift(1, "2024-05-06", "07:00", "15:00", 8)
+	second := budgetShift(2, "2024-05-07", "07:00", "15:00", 8)
+
+	for _, claimed := range []bool{true, false} {
+		budget := newClaimBudget(8, nil, nil)
+		_, reason := budget.hold(first)
+		require.Empty(t, reason)
+
+		held := make(chan string)
+		go func() {
+			_, reason := budget.hold(second)
+			held <- reason
+		}()
+		select {
+		case <-held:
+			t.Fatal("hold returned while a claim was in flight")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		budget.settle(first, "", claimed)
+		select {
+		case reason := <-held:
+			if claimed {
+				assert.Equal(t, "exceeds weekly hours for 2024-W19 (8.00 of 8.00 used)", reason)
+			} else {
+				assert.Empty(t, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("hold did not return once the claim settled")
+		}
+	}
+}
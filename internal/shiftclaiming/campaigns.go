@@ -0,0 +1,383 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CampaignActive    = "active"
+	CampaignCompleted = "completed"
+	CampaignExpired   = "expired"
+	CampaignCancelled = "cancelled"
+)
+
+var (
+	ErrInvalidCampaign   = errors.New("invalid campaign")
+	ErrCampaignNotFound  = errors.New("campaign not found")
+	ErrCampaignNotActive = errors.New("campaign is not active")
+)
+
+// Campaign limits claiming to a number of shifts or hours within a date
+// window. While any campaign is active, shifts are only claimed against a
+// campaign's remaining budget, and claiming stops once none is left active.
+type Campaign struct {
+	ID            string     `json:"id" firestore:"-"`
+	Name          string     `json:"name,omitempty" firestore:"name"`
+	TargetShifts  int        `json:"target_shifts,omitempty" firestore:"targetShifts"`
+	TargetHours   float64    `json:"target_hours,omitempty" firestore:"targetHours"`
+	WindowStart   string     `json:"window_start,omitempty" firestore:"windowStart"`
+	WindowEnd     string     `json:"window_end,omitempty" firestore:"windowEnd"`
+	Deadline      *time.Time `json:"deadline,omitempty" firestore:"deadline,omitempty"`
+	ClaimedShifts int        `json:"claimed_shifts" firestore:"claimedShifts"`
+	ClaimedHours  float64    `json:"claimed_hours" firestore:"claimedHours"`
+	Status        string     `json:"status" firestore:"status"`
+	CreatedAt     time.Time  `json:"created_at" firestore:"createdAt"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty" firestore:"finishedAt,omitempty"`
+	Progress      float64    `json:"progress" firestore:"-"`
+}
+
+// progress returns the fraction of the target reached, using whichever target
+// is closest to being met.
+func (c *Campaign) progress() float64 {
+	progress := 0.0
+	if c.TargetShifts > 0 {
+		progress = float64(c.ClaimedShifts) / float64(c.TargetShifts)
+	}
+	if c.TargetHours > 0 {
+		if hoursProgress := c.ClaimedHours / c.TargetHours; hoursProgress > progress {
+			progress = hoursProgress
+		}
+	}
+	if progress > 1 {
+		progress = 1
+	}
+	return progress
+}
+
+func (c *Campaign) targetMet() bool {
+	return (c.TargetShifts > 0 && c.ClaimedShifts >= c.TargetShifts) ||
+		(c.TargetHours > 0 && c.ClaimedHours >= c.TargetHours)
+}
+
+// finishStatus returns the status an active campaign closes with at now, or
+// an empty string while it stays active.
+func (c *Campaign) finishStatus(now time.Time) string {
+	if c.targetMet() {
+		return CampaignCompleted
+	}
+	if c.Deadline != nil && now.After(*c.Deadline) {
+		return CampaignExpired
+	}
+	return ""
+}
+
+// refund returns the updates giving back a claim of the given hours that did
+// not take. A campaign the claim completed is reopened unless its deadline has
+// passed.
+func (c *Campaign) refund(hours float64, now time.Time) []firestore.Update {
+	updates := []firestore.Update{
+		{Path: "claimedShifts", Value: firestore.Increment(-1)},
+		{Path: "claimedHours", Value: firestore.Increment(-hours)},
+	}
+	refunded := *c
+	refunded.ClaimedShifts--
+	refunded.ClaimedHours -= hours
+	if c.Status == CampaignCompleted && !refunded.targetMet() && (c.Deadline == nil || now.Before(*c.Deadline)) {
+		updates = append(updates,
+			firestore.Update{Path: "status", Value: CampaignActive},
+			firestore.Update{Path: "finishedAt", Value: firestore.Delete},
+		)
+	}
+	return updates
+}
+
+// fits reports whether the shift falls in the campaign window and fits its
+// remaining budget.
+func (c *Campaign) fits(shift Shift) bool {
+	day := strings.SplitN(shift.Date, "T", 2)[0]
+	if c.WindowStart != "" && day < c.WindowStart {
+		return false
+	}
+	if c.WindowEnd != "" && day > c.WindowEnd {
+		return false
+	}
+	if c.TargetShifts > 0 && c.ClaimedShifts+1 > c.TargetShifts {
+		return false
+	}
+	if c.TargetHours > 0 && c.ClaimedHours+shift.Hours > c.TargetHours {
+		return false
+	}
+	return true
+}
+
+func (c *Campaign) validate() error {
+	if c.TargetShifts <= 0 && c.TargetHours <= 0 {
+		return fmt.Errorf("%w: target_shifts or target_hours is required", ErrInvalidCampaign)
+	}
+	if c.TargetShifts < 0 || c.TargetHours < 0 {
+		return fmt.Errorf("%w: targets must not be negative", ErrInvalidCampaign)
+	}
+	for _, day := range []string{c.WindowStart, c.WindowEnd} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return fmt.Errorf("%w: window dates must use the YYYY-MM-DD format", ErrInvalidCampaign)
+		}
+	}
+	if c.WindowStart != "" && c.WindowEnd != "" && c.WindowEnd < c.WindowStart {
+		return fmt.Errorf("%w: window_end is before window_start", ErrInvalidCampaign)
+	}
+	if c.Deadline != nil && c.Deadline.Before(time.Now()) {
+		return fmt.Errorf("%w: deadline is in the past", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+// CreateCampaign validates and stores a new active campaign.
+func (s *Service) CreateCampaign(ctx context.Context, campaign Campaign, origin Origin) (created *Campaign, err error) {
+	defer func() {
+		var after interface{}
+		target := ""
+		if created != nil {
+			after = created
+			target = "campaigns/" + created.ID
+		}
+		s.audit(ctx, origin, AuditCreateCampaign, target, nil, after, err)
+	}()
+	if err := campaign.validate(); err != nil {
+		return nil, err
+	}
+	campaign.ClaimedShifts = 0
+	campaign.ClaimedHours = 0
+	campaign.Status = CampaignActive
+	campaign.CreatedAt = time.Now()
+	campaign.FinishedAt = nil
+
+	storeCtx, cancel := s.storeContext(ctx)
+	defer cancel()
+	doc := s.firestoreClient.Collection("campaigns").NewDoc()
+	if _, err := doc.Set(storeCtx, campaign); err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %v", err)
+	}
+	campaign.ID = doc.ID
+	fmt.Printf(`{"message": "Campaign created", "campaign_id": "%s", "severity": "info"}`+"\n", campaign.ID)
+	return &campaign, nil
+}
+
+// ListCampaigns returns every campaign, newest first.
+func (s *Service) ListCampaigns(ctx context.Context) ([]*Campaign, error) {
+	return s.queryCampaigns(ctx, s.firestoreClient.Collection("campaigns").OrderBy("createdAt", firestore.Desc))
+}
+
+// CancelCampaign marks an active campaign as cancelled. Claiming stops when it
+// was the last active campaign, as when the last one finishes.
+func (s *Service) CancelCampaign(ctx context.Context, id string, origin Origin) (err error) {
+	doc := s.firestoreClient.Collection("campaigns").Doc(id)
+	var before, after interface{}
+	defer func() { s.audit(ctx, origin, AuditCancelCampaign, "campaigns/"+id, before, after, err) }()
+	now := time.Now()
+	var campaign Campaign
+	storeCtx, cancel := s.storeContext(ctx)
+	err = s.firestoreClient.RunTransaction(storeCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(doc)
+		if status.Code(err) == codes.NotFound {
+			return ErrCampaignNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&campaign); err != nil {
+			return err
+		}
+		if campaign.Status != CampaignActive {
+			return fmt.Errorf("%w: it is %s", ErrCampaignNotActive, campaign.Status)
+		}
+		return tx.Update(doc, []firestore.Update{
+			{Path: "status", Value: CampaignCancelled},
+			{Path: "finishedAt", Value: now},
+		})
+	})
+	cancel()
+	if errors.Is(err, ErrCampaignNotFound) || errors.Is(err, ErrCampaignNotActive) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cancel campaign: %v", err)
+	}
+	before = map[string]interface{}{"status": CampaignActive}
+	after = map[string]interface{}{"status": CampaignCancelled}
+	campaign.ID = id
+	campaign.Status = CampaignCancelled
+	campaign.FinishedAt = &now
+
+	remaining, err := s.activeCampaigns(ctx)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return nil
+	}
+	storeCtx, cancel = s.storeContext(ctx)
+	configDocSnap, err := s.firestoreClient.Collection("configuration").Doc("config").Get(storeCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve configuration: %v", err)
+	}
+	if enabled, _ := configDocSnap.Data()["startStopFlag"].(bool); !enabled {
+		return nil
+	}
+	return s.stopForCampaigns(ctx, []*Campaign{&campaign}, origin)
+}
+
+func (s *Service) activeCampaigns(ctx context.Context) ([]*Campaign, error) {
+	return s.queryCampaigns(ctx, s.firestoreClient.Collection("campaigns").Where("status", "==", CampaignActive))
+}
+
+func (s *Service) queryCampaigns(ctx context.Context, query firestore.Query) ([]*Campaign, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	campaigns := []*Campaign{}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve campaigns: %v", err)
+		}
+		var campaign Campaign
+		if err := doc.DataTo(&campaign); err != nil {
+			return nil, fmt.Errorf("failed to parse campaign %s: %v", doc.Ref.ID, err)
+		}
+		campaign.ID = doc.Ref.ID
+		campaign.Progress = campaign.progress()
+		campaigns = append(campaigns, &campaign)
+	}
+	return campaigns, nil
+}
+
+// campaignProgress returns the writes adding successful claims to the
+// campaigns they were reserved against.
+func (s *Service) campaignProgress(claimingResults []ClaimingResult) []claimWrite {
+	var writes []claimWrite
+	for _, result := range claimingResults {
+		if result.ClaimingStatus != "success" || result.Campaign == "" {
+			continue
+		}
+		writes = append(writes, claimWrite{
+			doc: s.firestoreClient.Collection("campaigns").Doc(result.Campaign),
+			data: map[string]interface{}{
+				"claimedShifts": firestore.Increment(1),
+				"claimedHours":  firestore.Increment(result.Hours),
+			},
+			opts: []firestore.SetOption{firestore.MergeAll},
+		})
+	}
+	return writes
+}
+
+// finishCampaigns closes campaigns whose target is met or whose deadline has
+// passed. When the last active campaign closes, claiming is stopped and a
+// summary is sent. It reports whether claiming was stopped.
+//
+// Each campaign is read again and closed in a transaction, so that one
+// cancelled or reopened since the query is not closed over it.
+func (s *Service) finishCampaigns(ctx context.Context, now time.Time, origin Origin) (bool, error) {
+	campaigns, err := s.activeCampaigns(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(campaigns) == 0 {
+		return false, nil
+	}
+
+	var finished []*Campaign
+	active := 0
+	for _, queried := range campaigns {
+		doc := s.firestoreClient.Collection("campaigns").Doc(queried.ID)
+		var campaign Campaign
+		var closed bool
+		storeCtx, cancel := s.storeContext(ctx)
+		err := s.firestoreClient.RunTransaction(storeCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+			campaign, closed = Campaign{}, false
+			snap, err := tx.Get(doc)
+			if err != nil {
+				return err
+			}
+			if err := snap.DataTo(&campaign); err != nil {
+				return err
+			}
+			if campaign.Status != CampaignActive {
+				return nil
+			}
+			status := campaign.finishStatus(now)
+			if status == "" {
+				return nil
+			}
+			campaign.Status = status
+			campaign.FinishedAt = &now
+			closed = true
+			return tx.Update(doc, []firestore.Update{
+				{Path: "status", Value: status},
+				{Path: "finishedAt", Value: now},
+			})
+		})
+		cancel()
+		if err != nil {
+			return false, fmt.Errorf("failed to finish campaign %s: %v", queried.ID, err)
+		}
+		campaign.ID = queried.ID
+		campaign.Progress = campaign.progress()
+		if closed {
+			finished = append(finished, &campaign)
+		} else if campaign.Status == CampaignActive {
+			active++
+		}
+	}
+	// A campaign cancelled meanwhile stopped claiming itself if it was the
+	// last one, see CancelCampaign
+	if active > 0 || len(finished) == 0 {
+		return false, nil
+	}
+	if err := s.stopForCampaigns(ctx, finished, origin); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// stopForCampaigns stops claiming once the last active campaign has closed,
+// and sends a summary of the campaigns that closed last.
+func (s *Service) stopForCampaigns(ctx context.Context, finished []*Campaign, origin Origin) error {
+	var lines []string
+	for _, campaign := range finished {
+		lines = append(lines, fmt.Sprintf("%s %s: %d shifts, %.2f hours claimed", campaignLabel(campaign), campaign.Status, campaign.ClaimedShifts, campaign.ClaimedHours))
+	}
+	fmt.Println(`{"message": "All campaigns finished, stopping claiming", "severity": "notice"}`)
+	if err := s.StopClaiming(ctx, Origin{Actor: actorSystem, Source: origin.Source, RequestID: origin.RequestID}); err != nil {
+		return err
+	}
+	// Stopping claiming cancels the poll loop this may be running in
+	if err := s.notifyClient.Send(context.WithoutCancel(ctx), "Claiming stopped", strings.Join(lines, "\n"), map[string]interface{}{"campaigns": finished}); err != nil {
+		fmt.Printf(`{"message": "Failed to send campaign summary", "error": "%v", "severity": "error"}`+"\n", err)
+	}
+	return nil
+}
+
+func campaignLabel(campaign *Campaign) string {
+	if campaign.Name != "" {
+		return fmt.Sprintf("Campaign %q", campaign.Name)
+	}
+	return fmt.Sprintf("Campaign %s", campaign.ID)
+}
@@ -0,0 +1,214 @@
+package shiftclaiming
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCampaignProgress(t *testing.T) {
+	tests := []struct {
+		name          string
+		campaign      Campaign
+		wantProgress  float64
+		wantTargetMet bool
+	}{
+		{"nothing claimed", Campaign{TargetShifts: 4}, 0, false},
+		{"shifts target", Campaign{TargetShifts: 4, ClaimedShifts: 1}, 0.25, false},
+		{"hours target", Campaign{TargetHours: 16, ClaimedHours: 12}, 0.75, false},
+		{"closest target counts", Campaign{TargetShifts: 4, ClaimedShifts: 1, TargetHours: 16, ClaimedHours: 8}, 0.5, false},
+		{"shifts target met", Campaign{TargetShifts: 2, ClaimedShifts: 2, TargetHours: 40, ClaimedHours: 16}, 1, true},
+		{"hours target met", Campaign{TargetShifts: 10, ClaimedShifts: 2, TargetHours: 16, ClaimedHours: 16}, 1, true},
+		{"overshoot is capped", Campaign{TargetHours: 16, ClaimedHours: 24}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.wantProgress, tt.campaign.progress(), 1e-9)
+			assert.Equal(t, tt.wantTargetMet, tt.campaign.targetMet())
+		})
+	}
+}
+
+func TestCampaignFits(t *testing.T) {
+	shift := Shift{Date: "2024-05-08T00:00:00", Hours: 8}
+	tests := []struct {
+		name     string
+		campaign Campaign
+		want     bool
+	}{
+		{"no window", Campaign{TargetShifts: 1}, true},
+		{"inside window", Campaign{TargetShifts: 1, WindowStart: "2024-05-06", WindowEnd: "2024-05-12"}, true},
+		{"window bounds are inclusive", Campaign{TargetShifts: 1, WindowStart: "2024-05-08", WindowEnd: "2024-05-08"}, true},
+		{"before window", Campaign{TargetShifts: 1, WindowStart: "2024-05-09"}, false},
+		{"after window", Campaign{TargetShifts: 1, WindowEnd: "2024-05-07"}, false},
+		{"shifts left", Campaign{TargetShifts: 2, ClaimedShifts: 1}, true},
+		{"no shifts left", Campaign{TargetShifts: 2, ClaimedShifts: 2}, false},
+		{"hours left", Campaign{TargetHours: 16, ClaimedHours: 8}, true},
+		{"too few hours left", Campaign{TargetHours: 16, ClaimedHours: 10}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.campaign.fits(shift))
+		})
+	}
+}
+
+func TestCampaignValidate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		campaign Campaign
+		wantErr  string
+	}{
+		{"shifts target", Campaign{TargetShifts: 2}, ""},
+		{"hours target with window and deadline", Campaign{TargetHours: 16, WindowStart: "2024-05-06", WindowEnd: "2024-05-12", Deadline: &future}, ""},
+		{"no target", Campaign{}, "target_shifts or target_hours is required"},
+		{"negative target", Campaign{TargetShifts: 2, TargetHours: -1}, "must not be negative"},
+		{"bad window date", Campaign{TargetShifts: 2, WindowStart: "05/06/2024"}, "YYYY-MM-DD"},
+		{"window ends before it starts", Campaign{TargetShifts: 2, WindowStart: "2024-05-12", WindowEnd: "2024-05-06"}, "window_end is before window_start"},
+		{"deadline passed", Campaign{TargetShifts: 2, Deadline: &past}, "deadline is in the past"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.campaign.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidCampaign)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCampaignFinishStatus(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name     string
+		campaign Campaign
+		want     string
+	}{
+		{"running", Campaign{TargetShifts: 2, ClaimedShifts: 1, Deadline: &future}, ""},
+		{"no deadline", Campaign{TargetShifts: 2, ClaimedShifts: 1}, ""},
+		{"target met", Campaign{TargetShifts: 2, ClaimedShifts: 2, Deadline: &future}, CampaignCompleted},
+		{"deadline passed", Campaign{TargetShifts: 2, ClaimedShifts: 1, Deadline: &past}, CampaignExpired},
+		{"met past its deadline", Campaign{TargetShifts: 2, ClaimedShifts: 2, Deadline: &past}, CampaignCompleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.campaign.finishStatus(now))
+		})
+	}
+}
+
+// The fake store returns every campaign to the query for active ones, as if
+// the others had changed since it ran, and does not apply writes.
+func TestFinishCampaigns(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	running := map[string]interface{}{"status": CampaignActive, "targetShifts": 2, "claimedShifts": 1}
+	tests := []struct {
+		name        string
+		campaigns   map[string]map[string]interface{}
+		wantWritten []string
+	}{
+		{"target met", map[string]map[string]interface{}{
+			"met":     {"status": CampaignActive, "targetShifts": 2, "claimedShifts": 2},
+			"running": running,
+		}, []string{"campaigns/met"}},
+		{"deadline passed", map[string]map[string]interface{}{
+			"expired": {"status": CampaignActive, "targetShifts": 2, "claimedShifts": 1, "deadline": past},
+			"running": running,
+		}, []string{"campaigns/expired"}},
+		{"cancelled since the query", map[string]map[string]interface{}{
+			"cancelled": {"status": CampaignCancelled, "targetShifts": 2, "claimedShifts": 2},
+			"running":   running,
+		}, nil},
+		{"only cancelled since the query", map[string]map[string]interface{}{
+			"cancelled": {"status": CampaignCancelled, "targetShifts": 2, "claimedShifts": 2},
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, store := newPollService(t, nil, 0)
+			for id, fields := range tt.campaigns {
+				store.seed("campaigns/"+id, fields)
+			}
+
+			stopped, err := s.finishCampaigns(context.Background(), time.Now(), Origin{Source: SourcePoller})
+			require.NoError(t, err)
+			assert.False(t, stopped)
+			assert.Equal(t, tt.wantWritten, store.writtenDocs())
+		})
+	}
+}
+
+func TestCancelCampaign(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		wantErr     error
+		wantWritten bool
+	}{
+		{"active", CampaignActive, nil, true},
+		{"already completed", CampaignCompleted, ErrCampaignNotActive, false},
+		{"already cancelled", CampaignCancelled, ErrCampaignNotActive, false},
+		{"unknown", "", ErrCampaignNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, store := newPollService(t, nil, 0)
+			if tt.status != "" {
+				store.seed("campaigns/c1", map[string]interface{}{"status": tt.status, "targetShifts": 2})
+			}
+			// Claiming goes on for the other campaign
+			store.seed("campaigns/c2", map[string]interface{}{"status": CampaignActive, "targetShifts": 2})
+
+			err := s.CancelCampaign(context.Background(), "c1", Origin{Actor: "user@example.com", Source: SourceHTTP})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantWritten, slices.Contains(store.writtenDocs(), "campaigns/c1"))
+		})
+	}
+}
+
+func TestCampaignRefund(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name       string
+		campaign   Campaign
+		wantReopen bool
+	}{
+		{"active", Campaign{Status: CampaignActive, TargetShifts: 2, ClaimedShifts: 1}, false},
+		{"completed by the claim", Campaign{Status: CampaignCompleted, TargetShifts: 2, ClaimedShifts: 2}, true},
+		{"completed before its deadline", Campaign{Status: CampaignCompleted, TargetHours: 16, ClaimedHours: 16, Deadline: &future}, true},
+		{"completed past its deadline", Campaign{Status: CampaignCompleted, TargetShifts: 2, ClaimedShifts: 2, Deadline: &past}, false},
+		{"still met without the claim", Campaign{Status: CampaignCompleted, TargetShifts: 2, ClaimedShifts: 3}, false},
+		{"cancelled", Campaign{Status: CampaignCancelled, TargetShifts: 2, ClaimedShifts: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := tt.campaign.refund(8, now)
+			want := []firestore.Update{
+				{Path: "claimedShifts", Value: firestore.Increment(-1)},
+				{Path: "claimedHours", Value: firestore.Increment(-8.0)},
+			}
+			if tt.wantReopen {
+				want = append(want,
+					firestore.Update{Path: "status", Value: CampaignActive},
+					firestore.Update{Path: "finishedAt", Value: firestore.Delete},
+				)
+			}
+			assert.Equal(t, want, updates)
+		})
+	}
+}
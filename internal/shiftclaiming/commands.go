@@ -0,0 +1,50 @@
+package shiftclaiming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+// PushMessage is the envelope Pub/Sub push subscriptions deliver.
+type PushMessage struct {
+	Message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// command returns the command carried by the message, given either as the
+// plain message data ("start") or as JSON ({"command": "start"}).
+func (m *PushMessage) command() string {
+	data := strings.TrimSpace(string(m.Message.Data))
+	var payload struct {
+		Command string `json:"command"`
+	}
+	if json.Unmarshal([]byte(data), &payload) == nil && payload.Command != "" {
+		data = payload.Command
+	}
+	if data == "" {
+		data = m.Message.Attributes["command"]
+	}
+	return strings.ToLower(strings.TrimSpace(data))
+}
+
+// RunCommand starts or stops claiming on behalf of a command channel.
+func (s *Service) RunCommand(ctx context.Context, command string, origin Origin) error {
+	switch command {
+	case "start":
+		return s.StartClaiming(ctx, origin, false)
+	case "stop":
+		return s.StopClaiming(ctx, origin)
+	}
+	err := fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	s.audit(ctx, origin, AuditPubSubCommand, "", nil, map[string]interface{}{"command": command}, err)
+	return err
+}
@@ -0,0 +1,151 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+)
+
+var (
+	ErrUnknownConfigDocument = errors.New("unknown configuration document")
+	ErrInvalidAuthConfig     = errors.New("invalid auth configuration")
+)
+
+// configDocuments are the versioned documents of the configuration collection.
+var configDocuments = []string{"config", "auth", "shiftconfig"}
+
+// redactedAuthFields are the secret fields of the auth document, which the
+// version store keeps out of the history.
+var redactedAuthFields = []string{"cookie", "x_api_token"}
+
+// writeConfig updates fields of a configuration document through the version
+// store. Fields that are not given are left untouched.
+// It returns nil when nothing changed.
+func (s *Service) writeConfig(ctx context.Context, document, author string, fields map[string]interface{}) (*firestores.Version, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	doc := s.firestoreClient.Collection("configuration").Doc(document)
+	version, err := s.configVersions.Merge(ctx, doc, author, fields)
+	if err != nil {
+		return nil, err
+	}
+	s.publishConfigVersion(version)
+	return version, nil
+}
+
+// UpdateAuthConfig replaces the portal credentials given, leaving the others
+// as they are.
+func (s *Service) UpdateAuthConfig(ctx context.Context, authConfig map[string]interface{}, origin Origin) (err error) {
+	var version *firestores.Version
+	defer func() { s.auditVersion(ctx, origin, AuditUpdateAuthConfig, "auth", version, err) }()
+	if len(authConfig) == 0 {
+		return fmt.Errorf("%w: no fields given", ErrInvalidAuthConfig)
+	}
+	for field, value := range authConfig {
+		if field != "cookie" && field != "x_api_token" && field != "user_id" {
+			return fmt.Errorf("%w: unknown field '%s'", ErrInvalidAuthConfig, field)
+		}
+		if value, ok := value.(string); !ok || value == "" {
+			return fmt.Errorf("%w: '%s' must be a non-empty string", ErrInvalidAuthConfig, field)
+		}
+	}
+	if version, err = s.writeConfig(ctx, "auth", origin.Actor, authConfig); err != nil {
+		return fmt.Errorf("failed to update auth configuration: %v", err)
+	}
+	fmt.Println(`{"message": "Auth configuration updated", "severity": "notice"}`)
+	return nil
+}
+
+// ConfigHistory returns configuration versions newest first, optionally only
+// those of one document.
+func (s *Service) ConfigHistory(ctx context.Context, document string, q HistoryQuery) (*firestores.VersionPage, error) {
+	if q.Status != "" || !q.From.IsZero() || !q.To.IsZero() {
+		return nil, fmt.Errorf("%w: configuration history only supports cursor and limit", ErrInvalidQuery)
+	}
+	if document != "" {
+		if !containsFold(configDocuments, document) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownConfigDocument, document)
+		}
+		document = "configuration/" + document
+	}
+	var after int64
+	if q.Cursor != "" {
+		var err error
+		if after, err = strconv.ParseInt(q.Cursor, 10, 64); err != nil || after <= 0 {
+			return nil, fmt.Errorf("%w: invalid cursor %q", ErrInvalidQuery, q.Cursor)
+		}
+	}
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	page, err := s.configVersions.History(ctx, document, after, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve configuration history: %v", err)
+	}
+	return page, nil
+}
+
+// RollbackConfig restores the document of a version to its state after that
+// version. Restoring the start/stop flag also runs StartClaiming or
+// StopClaiming, so that the task queue matches it. Credentials are not kept in
+// the history, so rolling back the auth document leaves them as they are.
+func (s *Service) RollbackConfig(ctx context.Context, version int64, origin Origin) (*firestores.Version, error) {
+	written, err := s.configVersions.Rollback(ctx, version, origin.Actor)
+	document := ""
+	if written != nil {
+		document = strings.TrimPrefix(written.Document, "configuration/")
+	}
+	s.auditVersion(ctx, origin, AuditRollbackConfig, document, written, err)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf(`{"message": "Configuration rolled back", "version": %d, "author": "%s", "severity": "notice"}`+"\n", version, origin.Actor)
+	s.publishConfigVersion(written)
+	if written == nil {
+		return nil, nil
+	}
+
+	if change, ok := written.Diff["startStopFlag"]; ok {
+		if enabled, _ := change.After.(bool); enabled {
+			dryRun, _ := written.Data["dryRun"].(bool)
+			err = s.StartClaiming(ctx, origin, dryRun)
+		} else {
+			err = s.StopClaiming(ctx, origin)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return written, nil
+}
+
+func (s *Service) publishConfigVersion(version *firestores.Version) {
+	if version == nil {
+		return
+	}
+	fields := make([]string, 0, len(version.Diff))
+	for field := range version.Diff {
+		fields = append(fields, field)
+	}
+	s.events.Publish(EventConfigChanged, map[string]interface{}{
+		"version":  version.Version,
+		"document": version.Document,
+		"author":   version.Author,
+		"fields":   fields,
+	})
+}
+
+// deleteMissing marks the given fields for deletion when they are absent from
+// the update, for writes that replace a fixed set of fields.
+func deleteMissing(fields map[string]interface{}, names map[string]bool) map[string]interface{} {
+	for name := range names {
+		if _, ok := fields[name]; !ok {
+			fields[name] = firestore.Delete
+		}
+	}
+	return fields
+}
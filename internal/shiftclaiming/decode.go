@@ -0,0 +1,212 @@
+package shiftclaiming
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxDiagnosticBody is the most of a response body kept in logs and errors.
+const maxDiagnosticBody = 4 << 10
+
+// SwapboardDecodeError is returned when the swapboard response cannot be
+// decoded at all. It keeps the raw body for diagnostics.
+type SwapboardDecodeError struct {
+	Body string
+	Err  error
+}
+
+func (e *SwapboardDecodeError) Error() string {
+	return fmt.Sprintf("failed to parse shift listings: %v", e.Err)
+}
+
+func (e *SwapboardDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RecordError is a swapboard record that was skipped because it could not be
+// decoded.
+type RecordError struct {
+	Index int    `json:"index"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// shiftFields are the swapboard fields decoded into a Shift, by name. Each
+// returns the Shift field it is decoded into.
+var shiftFields = map[string]func(*Shift) interface{}{
+	"Id":         func(s *Shift) interface{} { return &s.Id },
+	"SchId":      func(s *Shift) interface{} { return &s.SchId },
+	"LocId":      func(s *Shift) interface{} { return &s.LocId },
+	"StnName":    func(s *Shift) interface{} { return &s.StnName },
+	"Date":       func(s *Shift) interface{} { return &s.Date },
+	"Hours":      func(s *Shift) interface{} { return &s.Hours },
+	"ShiftGroup": func(s *Shift) interface{} { return &s.ShiftGroup },
+	"Start":      func(s *Shift) interface{} { return &s.Start },
+	"End":        func(s *Shift) interface{} { return &s.End },
+}
+
+// requiredShiftFields are needed to claim a shift or to decide whether to, so
+// a record without them is skipped rather than matched with zero values.
+var requiredShiftFields = []string{"Id", "SchId", "Date", "Hours", "ShiftGroup"}
+
+// reportedDrift holds the schema drifts already logged, so that each is only
+// reported once per instance rather than on every poll.
+var reportedDrift sync.Map
+
+// decodeSwapboard decodes the records of a swapboard response. Nulls decode to
+// zero values and numbers given as strings are accepted. Records that still
+// cannot be decoded are skipped and returned as record errors, and fields the
+// swapboard added or dropped are reported once as schema drift.
+func decodeSwapboard(body []byte) ([]Shift, []RecordError, error) {
+	var records []json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, nil, &SwapboardDecodeError{Body: truncate(string(body), maxDiagnosticBody), Err: err}
+	}
+
+	shifts := make([]Shift, 0, len(records))
+	var recordErrors []RecordError
+	unknown := map[string]bool{}
+	seen := map[string]bool{}
+	for i, record := range records {
+		shift, err := decodeShift(record, unknown, seen)
+		if err != nil {
+			err.Index = i
+			recordErrors = append(recordErrors, *err)
+			continue
+		}
+		shifts = append(shifts, shift)
+	}
+	if len(records) > 0 && len(shifts) == 0 {
+		return nil, recordErrors, &SwapboardDecodeError{
+			Body: truncate(string(body), maxDiagnosticBody),
+			Err:  fmt.Errorf("none of the %d records could be decoded, first error: %s", len(records), recordErrors[0].Error),
+		}
+	}
+
+	var missing []string
+	if len(records) > 0 {
+		for name := range shiftFields {
+			if !seen[name] {
+				missing = append(missing, name)
+			}
+		}
+	}
+	reportDrift(sortedKeys(unknown), missing)
+	return shifts, recordErrors, nil
+}
+
+// decodeShift decodes one record, noting the field names it has.
+func decodeShift(record json.RawMessage, unknown, seen map[string]bool) (Shift, *RecordError) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return Shift{}, &RecordError{Error: fmt.Sprintf("record is not an object: %v", err)}
+	}
+	var shift Shift
+	for name, value := range fields {
+		field, ok := shiftFields[name]
+		if !ok {
+			unknown[name] = true
+			continue
+		}
+		seen[name] = true
+		if err := decodeField(value, field(&shift)); err != nil {
+			return Shift{}, &RecordError{Field: name, Error: err.Error()}
+		}
+	}
+	for _, name := range requiredShiftFields {
+		if value, ok := fields[name]; !ok || string(value) == "null" {
+			return Shift{}, &RecordError{Field: name, Error: "missing required field"}
+		}
+	}
+	return shift, nil
+}
+
+// decodeField decodes a JSON value into an int, float64 or string target,
+// leaving it at its zero value for null.
+func decodeField(value json.RawMessage, target interface{}) error {
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return nil
+	}
+	switch target := target.(type) {
+	case *string:
+		switch v := decoded.(type) {
+		case string:
+			*target = v
+		case float64:
+			*target = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("expected a string, got %s", value)
+		}
+	case *float64:
+		n, err := numberValue(decoded, value)
+		if err != nil {
+			return err
+		}
+		*target = n
+	case *int:
+		n, err := numberValue(decoded, value)
+		if err != nil {
+			return err
+		}
+		if n != float64(int(n)) {
+			return fmt.Errorf("expected an integer, got %s", value)
+		}
+		*target = int(n)
+	}
+	return nil
+}
+
+// numberValue accepts a JSON number or a string holding one.
+func numberValue(decoded interface{}, value json.RawMessage) (float64, error) {
+	switch v := decoded.(type) {
+	case float64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected a number, got %s", value)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %s", value)
+}
+
+// reportDrift logs fields the swapboard added or dropped, once for each
+// combination of them.
+func reportDrift(unknown, missing []string) {
+	if len(unknown) == 0 && len(missing) == 0 {
+		return
+	}
+	sort.Strings(missing)
+	key := strings.Join(unknown, ",") + "|" + strings.Join(missing, ",")
+	if _, reported := reportedDrift.LoadOrStore(key, true); reported {
+		return
+	}
+	unknownJSON, _ := json.Marshal(unknown)
+	missingJSON, _ := json.Marshal(missing)
+	fmt.Printf(`{"message": "Swapboard schema drift", "unknown_fields": %s, "missing_fields": %s, "severity": "warning"}`+"\n", unknownJSON, missingJSON)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,90 @@
+package shiftclaiming
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSwapboard(t *testing.T) {
+	full := Shift{Id: 7, SchId: 1234, LocId: 3, StnName: "North", Date: "2024-05-06T00:00:00", Hours: 7.5, ShiftGroup: "A", Start: "07:00", End: "14:30"}
+	tests := []struct {
+		name            string
+		body            string
+		want            []Shift
+		wantRecordError []RecordError
+		wantErr         string
+	}{
+		{
+			name: "every field",
+			body: `[{"Id": 7, "SchId": 1234, "LocId": 3, "StnName": "North", "Date": "2024-05-06T00:00:00", "Hours": 7.5, "ShiftGroup": "A", "Start": "07:00", "End": "14:30"}]`,
+			want: []Shift{full},
+		},
+		{
+			name: "numbers as strings",
+			body: `[{"Id": "7", "SchId": " 1234 ", "LocId": 3, "StnName": "North", "Date": "2024-05-06T00:00:00", "Hours": "7.5", "ShiftGroup": "A", "Start": "07:00", "End": "14:30"}]`,
+			want: []Shift{full},
+		},
+		{
+			name: "numeric string field",
+			body: `[{"Id": 7, "SchId": 1234, "Date": "2024-05-06T00:00:00", "Hours": 8, "ShiftGroup": 2}]`,
+			want: []Shift{{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "2"}},
+		},
+		{
+			name: "nulls and unknown fields",
+			body: `[{"Id": 7, "SchId": 1234, "Date": "2024-05-06T00:00:00", "Hours": 8, "ShiftGroup": "A", "StnName": null, "Start": null, "Posted": "yesterday"}]`,
+			want: []Shift{{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}},
+		},
+		{
+			name:            "null group and hours skipped",
+			body:            `[{"Id": 7, "SchId": 1234, "Date": "2024-05-06T00:00:00", "Hours": 8, "ShiftGroup": "A"}, {"Id": 8, "SchId": 5678, "Date": "2024-05-06T00:00:00", "Hours": 8, "ShiftGroup": null}, {"Id": 9, "SchId": 9012, "Date": "2024-05-06T00:00:00", "Hours": null, "ShiftGroup": "A"}, {"Id": 10, "SchId": 3456, "Hours": 8, "ShiftGroup": "A"}]`,
+			want:            []Shift{{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}},
+			wantRecordError: []RecordError{{Index: 1, Field: "ShiftGroup", Error: "missing required field"}, {Index: 2, Field: "Hours", Error: "missing required field"}, {Index: 3, Field: "Date", Error: "missing required field"}},
+		},
+		{
+			name: "empty board",
+			body: `[]`,
+			want: []Shift{},
+		},
+		{
+			name:            "bad record skipped",
+			body:            `[{"Id": 7, "SchId": 1234, "Date": "2024-05-06T00:00:00", "Hours": 8, "ShiftGroup": "A"}, {"Id": 8, "SchId": "soon"}, {"Id": 9}, "shift", {"Id": 10, "SchId": 1.5}]`,
+			want:            []Shift{{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}},
+			wantRecordError: []RecordError{{Index: 1, Field: "SchId", Error: `expected a number, got "soon"`}, {Index: 2, Field: "SchId", Error: "missing required field"}, {Index: 3, Error: "record is not an object"}, {Index: 4, Field: "SchId", Error: "expected an integer, got 1.5"}},
+		},
+		{
+			name:    "null required field",
+			body:    `[{"Id": 7, "SchId": null}]`,
+			wantErr: "none of the 1 records could be decoded, first error: missing required field",
+		},
+		{
+			name:    "not a list",
+			body:    `{"error": "maintenance"}`,
+			wantErr: "cannot unmarshal object",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shifts, recordErrors, err := decodeSwapboard([]byte(tt.body))
+			if tt.wantErr != "" {
+				var decodeErr *SwapboardDecodeError
+				require.True(t, errors.As(err, &decodeErr))
+				assert.Contains(t, decodeErr.Err.Error(), tt.wantErr)
+				assert.Equal(t, tt.body, decodeErr.Body)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, shifts)
+			// Errors from encoding/json are only matched by their start
+			require.Len(t, recordErrors, len(tt.wantRecordError))
+			for i, want := range tt.wantRecordError {
+				assert.Equal(t, want.Index, recordErrors[i].Index)
+				assert.Equal(t, want.Field, recordErrors[i].Field)
+				assert.True(t, strings.HasPrefix(recordErrors[i].Error, want.Error), recordErrors[i].Error)
+			}
+		})
+	}
+}
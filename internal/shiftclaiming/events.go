@@ -0,0 +1,16 @@
+package shiftclaiming
+
+// Event types published on the service's event bus and streamed by
+// HandleEventStream.
+const (
+	EventClaimingStarted = "claiming_started"
+	EventClaimingStopped = "claiming_stopped"
+	EventPoll            = "poll"
+	EventShiftsSeen      = "shifts_seen"
+	EventShiftAdded      = "shift_added"
+	EventShiftRemoved    = "shift_removed"
+	EventClaim           = "claim"
+	EventClaimVerified   = "claim_verified"
+	EventCooldown        = "cooldown"
+	EventConfigChanged   = "config_changed"
+)
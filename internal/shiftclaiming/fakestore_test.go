@@ -0,0 +1,180 @@
+package shiftclaiming
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const fakeStoreRoot = "projects/bench/databases/(default)/documents/"
+
+// fakeStore is an in-process Firestore that serves seeded documents and
+// acknowledges writes without applying them, noting the documents written,
+// answering every call after a fixed latency. Queries return every seeded
+// document of their collection, whatever their filters.
+type fakeStore struct {
+	firestorepb.UnimplementedFirestoreServer
+	latency time.Duration
+
+	mu      sync.Mutex
+	docs    map[string]*firestorepb.Document
+	written []string
+	updates []*firestorepb.Document
+}
+
+// newFakeStore starts a fake store and returns a client connected to it.
+func newFakeStore(t testing.TB, latency time.Duration) (*fakeStore, *firestore.Client) {
+	t.Helper()
+	store := &fakeStore{latency: latency, docs: map[string]*firestorepb.Document{}}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(server, store)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	client, err := firestore.NewClient(context.Background(), "bench", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return store, client
+}
+
+// seed stores a document at path, e.g. "configuration/config".
+func (f *fakeStore) seed(path string, fields map[string]interface{}) {
+	doc := &firestorepb.Document{
+		Name:       fakeStoreRoot + path,
+		Fields:     map[string]*firestorepb.Value{},
+		CreateTime: timestamppb.Now(),
+		UpdateTime: timestamppb.Now(),
+	}
+	for name, value := range fields {
+		doc.Fields[name] = fakeValue(value)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.docs[doc.Name] = doc
+}
+
+// fakeValue converts a seeded field value. Values of other types are null.
+func fakeValue(value interface{}) *firestorepb.Value {
+	switch value := value.(type) {
+	case string:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_StringValue{StringValue: value}}
+	case bool:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_BooleanValue{BooleanValue: value}}
+	case float64:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: value}}
+	case int:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: int64(value)}}
+	case time.Time:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_TimestampValue{TimestampValue: timestamppb.New(value)}}
+	case map[string]interface{}:
+		fields := map[string]*firestorepb.Value{}
+		for name, value := range value {
+			fields[name] = fakeValue(value)
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_MapValue{MapValue: &firestorepb.MapValue{Fields: fields}}}
+	}
+	return &firestorepb.Value{ValueType: &firestorepb.Value_NullValue{}}
+}
+
+// writtenDocs returns the paths of the documents written so far, e.g.
+// "state/board".
+func (f *fakeStore) writtenDocs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.written...)
+}
+
+// writtenDoc returns the fields of the last write to a document whose path
+// starts with prefix, or nil.
+func (f *fakeStore) writtenDoc(prefix string) map[string]*firestorepb.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.updates) - 1; i >= 0; i-- {
+		if strings.HasPrefix(strings.TrimPrefix(f.updates[i].Name, fakeStoreRoot), prefix) {
+			return f.updates[i].Fields
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) BatchGetDocuments(req *firestorepb.BatchGetDocumentsRequest, stream firestorepb.Firestore_BatchGetDocumentsServer) error {
+	time.Sleep(f.latency)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range req.Documents {
+		resp := &firestorepb.BatchGetDocumentsResponse{ReadTime: timestamppb.Now()}
+		if doc, ok := f.docs[name]; ok {
+			resp.Result = &firestorepb.BatchGetDocumentsResponse_Found{Found: doc}
+		} else {
+			resp.Result = &firestorepb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) RunQuery(req *firestorepb.RunQueryRequest, stream firestorepb.Firestore_RunQueryServer) error {
+	time.Sleep(f.latency)
+	query := req.GetStructuredQuery()
+	if query == nil || len(query.From) == 0 {
+		return nil
+	}
+	prefix := req.Parent + "/" + query.From[0].CollectionId + "/"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, doc := range f.docs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(strings.TrimPrefix(name, prefix), "/") {
+			continue
+		}
+		if err := stream.Send(&firestorepb.RunQueryResponse{Document: doc, ReadTime: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) Commit(ctx context.Context, req *firestorepb.CommitRequest) (*firestorepb.CommitResponse, error) {
+	time.Sleep(f.latency)
+	resp := &firestorepb.CommitResponse{CommitTime: timestamppb.Now()}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, write := range req.Writes {
+		if update := write.GetUpdate(); update != nil {
+			f.written = append(f.written, strings.TrimPrefix(update.Name, fakeStoreRoot))
+			f.updates = append(f.updates, update)
+		}
+		resp.WriteResults = append(resp.WriteResults, &firestorepb.WriteResult{UpdateTime: resp.CommitTime})
+	}
+	return resp, nil
+}
+
+// BeginTransaction starts a transaction that reads the seeded documents and
+// commits like any other write.
+func (f *fakeStore) BeginTransaction(ctx context.Context, req *firestorepb.BeginTransactionRequest) (*firestorepb.BeginTransactionResponse, error) {
+	time.Sleep(f.latency)
+	return &firestorepb.BeginTransactionResponse{Transaction: []byte("transaction")}, nil
+}
+
+func (f *fakeStore) Rollback(ctx context.Context, req *firestorepb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
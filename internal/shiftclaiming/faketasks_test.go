@@ -0,0 +1,68 @@
+package shiftclaiming
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeTasks is an in-process Cloud Tasks queue that notes the tasks created
+// and holds none of them.
+type fakeTasks struct {
+	taskspb.UnimplementedCloudTasksServer
+
+	mu      sync.Mutex
+	created []*taskspb.Task
+}
+
+// newFakeTasks starts a fake queue and returns a client connected to it.
+func newFakeTasks(t testing.TB) (*fakeTasks, *cloudtasks.Client) {
+	t.Helper()
+	tasks := &fakeTasks{}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	taskspb.RegisterCloudTasksServer(server, tasks)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	client, err := cloudtasks.NewClient(context.Background(), option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return tasks, client
+}
+
+// createdTasks returns the tasks created so far.
+func (f *fakeTasks) createdTasks() []*taskspb.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*taskspb.Task(nil), f.created...)
+}
+
+func (f *fakeTasks) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, req.Task)
+	return req.Task, nil
+}
+
+func (f *fakeTasks) ListTasks(ctx context.Context, req *taskspb.ListTasksRequest) (*taskspb.ListTasksResponse, error) {
+	return &taskspb.ListTasksResponse{}, nil
+}
+
+func (f *fakeTasks) DeleteTask(ctx context.Context, req *taskspb.DeleteTaskRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
@@ -1,24 +1,42 @@
 package shiftclaiming
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/yesaswi/shift-claiming-automation/internal/cloudtasks"
+	"github.com/yesaswi/shift-claiming-automation/internal/events"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
 	customerrors "github.com/yesaswi/shift-claiming-automation/pkg/errors"
 )
 
 func (s *Service) HandleStartCommand(w http.ResponseWriter, r *http.Request) {
-	err := s.StartClaiming()
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	err := s.StartClaiming(r.Context(), s.requestOrigin(r, SourceHTTP), dryRun)
 	if err != nil {
 		httpErr := customerrors.LogAndReturnError(err, "Failed to start claiming", "ERROR", http.StatusInternalServerError)
 		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
+	if dryRun {
+		w.Write([]byte("Shift claiming started successfully in dry-run mode"))
+		return
+	}
 	w.Write([]byte("Shift claiming started successfully"))
 }
 
 func (s *Service) HandleStopCommand(w http.ResponseWriter, r *http.Request) {
-	err := s.StopClaiming()
+	err := s.StopClaiming(r.Context(), s.requestOrigin(r, SourceHTTP))
 	if err != nil {
 		httpErr := customerrors.LogAndReturnError(err, "Failed to stop claiming", "ERROR", http.StatusInternalServerError)
 		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
@@ -29,7 +47,17 @@ func (s *Service) HandleStopCommand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Service) HandleClaimCommand(w http.ResponseWriter, r *http.Request) {
-	err := s.ClaimShift()
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		s.HandlePlan(w, r)
+		return
+	}
+	ctx := r.Context()
+	var payload cloudtasks.TaskPayload
+	if json.NewDecoder(r.Body).Decode(&payload) == nil && len(payload.TraceContext) > 0 {
+		// Continue the trace of the poll that scheduled this task
+		ctx = tracing.Extract(ctx, payload.TraceContext)
+	}
+	err := s.ClaimShift(ctx, s.requestOrigin(r, SourceHTTP))
 	if err != nil {
 		httpErr := customerrors.LogAndReturnError(err, "Failed to claim shift", "ERROR", http.StatusInternalServerError)
 		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
@@ -39,7 +67,547 @@ func (s *Service) HandleClaimCommand(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Triggered shift claiming"))
 }
 
+func (s *Service) HandlePlan(w http.ResponseWriter, r *http.Request) {
+	plan, err := s.PlanClaims(r.Context())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to plan shift claims", "ERROR", planStatusCode(err))
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// claimLinkPage asks the user to confirm a linked claim. The form posts the
+// link's parameters in the body, so they stay out of the URL of the claim.
+var claimLinkPage = template.Must(template.New("claim-link").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>Claim shift {{.SchID}}</title>
+  <link rel="stylesheet" href="/style.css">
+</head>
+<body>
+  <main>
+    <h1>Claim shift {{.SchID}}?</h1>
+    <p>This link expires at {{.Expires}}.</p>
+    <form method="post" action="/claim/link">
+      <input type="hidden" name="schid" value="{{.SchID}}">
+      <input type="hidden" name="exp" value="{{.Exp}}">
+      <input type="hidden" name="n" value="{{.Nonce}}">
+      <input type="hidden" name="sig" value="{{.Sig}}">
+      <button type="submit">Claim shift</button>
+    </form>
+  </main>
+</body>
+</html>
+`))
+
+// HandleClaimLinkPage shows the confirmation page of a claim link. Opening a
+// link never claims, since chat previews and mail scanners open links too.
+func (s *Service) HandleClaimLinkPage(w http.ResponseWriter, r *http.Request) {
+	link, err := s.verifyClaimLink(r.URL.Query(), time.Now())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Rejected claim link", "WARNING", http.StatusForbidden)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = claimLinkPage.Execute(w, map[string]interface{}{
+		"SchID":   link.schID,
+		"Exp":     link.expires,
+		"Expires": time.Unix(link.expires, 0).UTC().Format(time.RFC1123),
+		"Nonce":   link.nonce,
+		"Sig":     link.sig,
+	})
+	if err != nil {
+		fmt.Printf(`{"message": "Failed to render claim link page", "error": "%v", "severity": "error"}`+"\n", err)
+	}
+}
+
+// HandleClaimLink claims the shift of a confirmed claim link. Each link claims
+// at most once, but one whose claim was never sent can be confirmed again.
+func (s *Service) HandleClaimLink(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Invalid claim link form", "WARNING", http.StatusBadRequest)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	link, err := s.verifyClaimLink(r.PostForm, time.Now())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Rejected claim link", "WARNING", http.StatusForbidden)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	result, err := s.ClaimLinkedShift(r.Context(), link, s.requestOrigin(r, SourceHTTP))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrInvalidClaimLink):
+			statusCode = http.StatusForbidden
+		case errors.Is(err, ErrShiftNotOnBoard):
+			statusCode = http.StatusGone
+		case errors.Is(err, ErrShiftClosed):
+			statusCode = http.StatusConflict
+		case errors.Is(err, ErrClaimingHalted):
+			statusCode = http.StatusServiceUnavailable
+		case errors.Is(err, ErrPollRunning):
+			statusCode = http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", "5")
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to claim linked shift", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	if result.ClaimingStatus != "success" {
+		writeJSON(w, http.StatusConflict, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Service) HandleCreateCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaign Campaign
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Invalid campaign", "WARNING", http.StatusBadRequest)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	created, err := s.CreateCampaign(r.Context(), campaign, s.requestOrigin(r, SourceHTTP))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidCampaign) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to create campaign", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Service) HandleListCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := s.ListCampaigns(r.Context())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to list campaigns", "ERROR", http.StatusInternalServerError)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaigns)
+}
+
+func (s *Service) HandleCancelCampaign(w http.ResponseWriter, r *http.Request) {
+	err := s.CancelCampaign(r.Context(), mux.Vars(r)["id"], s.requestOrigin(r, SourceHTTP))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrCampaignNotFound):
+			statusCode = http.StatusNotFound
+		case errors.Is(err, ErrCampaignNotActive):
+			statusCode = http.StatusConflict
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to cancel campaign", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Campaign cancelled successfully"))
+}
+
+func (s *Service) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := s.GetStatus(r.Context())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to get status", "ERROR", http.StatusInternalServerError)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Service) HandleListClaims(w http.ResponseWriter, r *http.Request) {
+	s.handleHistory(w, r, s.ListClaims)
+}
+
+func (s *Service) HandleListSeenShifts(w http.ResponseWriter, r *http.Request) {
+	s.handleHistory(w, r, s.ListSeenShifts)
+}
+
+func (s *Service) handleHistory(w http.ResponseWriter, r *http.Request, list func(context.Context, HistoryQuery) (*firestores.Page, error)) {
+	q, err := parseHistoryQuery(r)
+	if err == nil {
+		var page *firestores.Page
+		page, err = list(r.Context(), q)
+		if err == nil {
+			writeJSON(w, http.StatusOK, page)
+			return
+		}
+	}
+	statusCode := http.StatusInternalServerError
+	if errors.Is(err, ErrInvalidQuery) || errors.Is(err, firestores.ErrInvalidCursor) {
+		statusCode = http.StatusBadRequest
+	}
+	httpErr := customerrors.LogAndReturnError(err, "Failed to list history", "ERROR", statusCode)
+	http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+}
+
+// parseHistoryQuery reads the from, to, status, cursor and limit parameters.
+// Dates may be given as YYYY-MM-DD, in which case "to" includes the whole day.
+func parseHistoryQuery(r *http.Request) (HistoryQuery, error) {
+	params := r.URL.Query()
+	q := HistoryQuery{
+		Status: params.Get("status"),
+		Cursor: params.Get("cursor"),
+		Limit:  50,
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 500 {
+			return q, fmt.Errorf("%w: limit must be between 1 and 500", ErrInvalidQuery)
+		}
+		q.Limit = n
+	}
+	var err error
+	if q.From, err = parseQueryTime(params.Get("from"), false); err != nil {
+		return q, err
+	}
+	if q.To, err = parseQueryTime(params.Get("to"), true); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func parseQueryTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q is not a YYYY-MM-DD date or RFC 3339 time", ErrInvalidQuery, value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (s *Service) HandleGetShiftConfig(w http.ResponseWriter, r *http.Request) {
+	view, err := s.GetShiftConfig(r.Context())
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to get shift configuration", "ERROR", http.StatusInternalServerError)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *Service) HandleUpdateShiftConfig(w http.ResponseWriter, r *http.Request) {
+	var shiftConfig map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&shiftConfig)
+	if err == nil {
+		err = s.UpdateShiftConfig(r.Context(), shiftConfig, s.requestOrigin(r, SourceHTTP))
+	}
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to update shift configuration", "ERROR", shiftConfigStatusCode(err))
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Shift configuration updated successfully"))
+}
+
+func (s *Service) HandlePreviewShiftConfig(w http.ResponseWriter, r *http.Request) {
+	var shiftConfig map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&shiftConfig)
+	var plan *ClaimPlan
+	if err == nil {
+		plan, err = s.PreviewShiftConfig(r.Context(), shiftConfig)
+	}
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to preview shift configuration", "ERROR", shiftConfigStatusCode(err))
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// planStatusCode maps invalid input to 400 and a portal that could not be read
+// to 502. Anything else, such as the configuration failing to load, is 500.
+func planStatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidQuery), errors.Is(err, ErrInvalidShiftConfig):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrPortal):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// shiftConfigStatusCode maps malformed bodies and validation failures to 400,
+// and a portal that could not be read to 502.
+func shiftConfigStatusCode(err error) int {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return http.StatusBadRequest
+	}
+	return planStatusCode(err)
+}
+
+func (s *Service) HandleUpdateAuthConfig(w http.ResponseWriter, r *http.Request) {
+	var authConfig map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&authConfig)
+	if err == nil {
+		err = s.UpdateAuthConfig(r.Context(), authConfig, s.requestOrigin(r, SourceHTTP))
+	}
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.Is(err, ErrInvalidAuthConfig) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to update auth configuration", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Auth configuration updated successfully"))
+}
+
+func (s *Service) HandleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	var page *firestores.VersionPage
+	if err == nil {
+		page, err = s.ConfigHistory(r.Context(), r.URL.Query().Get("document"), q)
+	}
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrUnknownConfigDocument) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to list configuration history", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// HandleStats serves the daily rollups between the from and to dates,
+// defaulting to the last 30 days.
+func (s *Service) HandleStats(w http.ResponseWriter, r *http.Request) {
+	from, to := s.statsRange(r, 29)
+	rollups, err := s.GetStats(r.Context(), from, to)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidQuery) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to retrieve stats", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"from": from, "to": to, "days": rollups})
+}
+
+// HandleRollupStats recomputes the daily rollups between the from and to
+// dates, defaulting to yesterday and today. It is meant to be called by a
+// scheduler shortly after midnight.
+func (s *Service) HandleRollupStats(w http.ResponseWriter, r *http.Request) {
+	from, to := s.statsRange(r, 1)
+	rollups, err := s.RollupStats(r.Context(), from, to)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidQuery) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to compute stats", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"from": from, "to": to, "days": rollups})
+}
+
+// statsRange reads the from and to parameters, defaulting to today and the
+// given number of days before it.
+func (s *Service) statsRange(r *http.Request, days int) (string, string) {
+	now := time.Now()
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if to == "" {
+		to = s.usageDay(now)
+	}
+	if from == "" {
+		from = s.usageDay(now.AddDate(0, 0, -days))
+	}
+	return from, to
+}
+
+// HandleConfigRollback restores a configuration version. A rollback that
+// changes the start/stop flag re-runs Start or Stop, with the tasks they
+// schedule or delete.
+func (s *Service) HandleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseInt(mux.Vars(r)["version"], 10, 64)
+	if err != nil || version <= 0 {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	written, err := s.RollbackConfig(r.Context(), version, s.requestOrigin(r, SourceHTTP))
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, firestores.ErrVersionNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to roll back configuration", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	if written == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Configuration already matches this version"))
+		return
+	}
+	writeJSON(w, http.StatusOK, written)
+}
+
+// HandlePubSubPush runs start/stop commands delivered by a Pub/Sub push
+// subscription. Unknown commands are acknowledged so they are not redelivered.
+func (s *Service) HandlePubSubPush(w http.ResponseWriter, r *http.Request) {
+	var push PushMessage
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Invalid Pub/Sub message", "WARNING", http.StatusBadRequest)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	origin := s.requestOrigin(r, SourcePubSub)
+	if push.Message.MessageID != "" {
+		origin.RequestID = push.Message.MessageID
+	}
+	err := s.RunCommand(r.Context(), push.command(), origin)
+	if errors.Is(err, ErrUnknownCommand) {
+		customerrors.LogAndReturnError(err, "Ignoring Pub/Sub message", "WARNING", http.StatusNoContent)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to run Pub/Sub command", "ERROR", http.StatusInternalServerError)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListAudit lists audit entries as JSON pages, or streams every matching
+// entry as NDJSON with ?format=ndjson or an application/x-ndjson Accept header.
+// The status parameter filters on the outcome.
+func (s *Service) HandleListAudit(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	history, err := parseHistoryQuery(r)
+	if err != nil {
+		httpErr := customerrors.LogAndReturnError(err, "Failed to list audit entries", "ERROR", http.StatusBadRequest)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	q := AuditQuery{
+		HistoryQuery: history,
+		Actor:        params.Get("actor"),
+		Action:       params.Get("action"),
+		Source:       params.Get("source"),
+	}
+
+	if params.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		encoder := json.NewEncoder(w)
+		if err := s.ExportAudit(r.Context(), q, func(entry map[string]interface{}) error { return encoder.Encode(entry) }); err != nil {
+			// Headers are already sent, so the error can only be logged
+			customerrors.LogAndReturnError(err, "Failed to export audit entries", "ERROR", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page, err := s.ListAudit(r.Context(), q)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, firestores.ErrInvalidCursor) {
+			statusCode = http.StatusBadRequest
+		}
+		httpErr := customerrors.LogAndReturnError(err, "Failed to list audit entries", "ERROR", statusCode)
+		http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// HandleEventStream streams bus events as Server-Sent Events. Clients resume
+// with the Last-Event-ID header and filter with ?types=poll,claim. Streams end
+// when the client disconnects or CloseStreams is called.
+func (s *Service) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	sub, backlog := s.events.Subscribe(lastID, types)
+	defer s.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range backlog {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
 func (s *Service) HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
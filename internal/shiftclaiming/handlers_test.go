@@ -0,0 +1,123 @@
+package shiftclaiming
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/events"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPlanStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"invalid query", fmt.Errorf("%w: bad limit", ErrInvalidQuery), http.StatusBadRequest},
+		{"invalid shift config", fmt.Errorf("%w: bad rules", ErrInvalidShiftConfig), http.StatusBadRequest},
+		{"portal", fmt.Errorf("%w: failed to fetch available shifts: EOF", ErrPortal), http.StatusBadGateway},
+		{"configuration load", errors.New("failed to retrieve claiming configuration: unavailable"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, planStatusCode(tt.err))
+		})
+	}
+}
+
+func TestHandleEventStreamEndsOnCloseStreams(t *testing.T) {
+	s := &Service{events: events.NewBus(10)}
+	s.events.Publish(EventPoll, nil)
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.HandleEventStream(recorder, httptest.NewRequest(http.MethodGet, "/events/stream", nil))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("stream ended before CloseStreams")
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.CloseStreams()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream did not end after CloseStreams")
+	}
+	assert.Contains(t, recorder.Body.String(), "id: 1\nevent: poll\n")
+}
+
+func TestListHandlersRejectUnknownCursor(t *testing.T) {
+	s, _, store := newPollService(t, nil, 0)
+	store.seed("claims/known", map[string]interface{}{"timestamp": time.Now(), "shiftId": "1234"})
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		want    int
+	}{
+		{"claims", s.HandleListClaims, "/claims?cursor=missing", http.StatusBadRequest},
+		{"seen shifts", s.HandleListSeenShifts, "/shifts/seen?cursor=missing", http.StatusBadRequest},
+		{"audit", s.HandleListAudit, "/audit?cursor=missing", http.StatusBadRequest},
+		{"known cursor", s.HandleListClaims, "/claims?cursor=known", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			tt.handler(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.want, recorder.Code, recorder.Body.String())
+		})
+	}
+}
+
+func TestHandleClaimCommandContinuesTaskTrace(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.ExporterNone, "test")
+	require.NoError(t, err)
+	spans := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+
+	s, _, _ := newPollService(t, nil, 0)
+	tasks, tasksClient := newFakeTasks(t)
+	s.cloudTasksClient = tasksClient
+	s.config.TasksTimeout = 10 * time.Second
+
+	// The poll that queues the task
+	ctx, parent := tracing.Tracer().Start(context.Background(), "poll")
+	require.NoError(t, s.ScheduleClaimTask(ctx, time.Now()))
+	parent.End()
+	created := tasks.createdTasks()
+	require.Len(t, created, 1)
+
+	// Cloud Tasks delivers the body with headers of its own
+	req := httptest.NewRequest(http.MethodPost, "/claim", bytes.NewReader(created[0].GetHttpRequest().GetBody()))
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	s.HandleClaimCommand(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var polls []sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == "poll" && span.SpanContext().SpanID() != parent.SpanContext().SpanID() {
+			polls = append(polls, span)
+		}
+	}
+	require.Len(t, polls, 1)
+	assert.Equal(t, parent.SpanContext().TraceID(), polls[0].SpanContext().TraceID(), "the poll continues the trace that queued it")
+	assert.True(t, polls[0].Parent().IsRemote())
+}
@@ -0,0 +1,224 @@
+package shiftclaiming
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// Reasons for the interval until the next poll, as reported in the status.
+const (
+	IntervalDefault        = "default"
+	IntervalBusyWindow     = "busy_window"
+	IntervalQuietHours     = "quiet_hours"
+	IntervalPortalWait     = "portal_wait"
+	IntervalPortalCooldown = "portal_cooldown"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	busyPollInterval    = 3 * time.Second
+	quietPollInterval   = 30 * time.Second
+	// defaultPortalMinInterval is the refresh interval the portal enforces
+	// until one of its messages states another.
+	defaultPortalMinInterval = 3 * time.Second
+	// portalMinTTL is how long a refresh interval stated by the portal is
+	// kept. The portal states it again whenever a poll comes too soon, so an
+	// interval it has lowered is picked up once this has passed.
+	portalMinTTL = time.Hour
+	// defaultPortalCooldown is the idle time the portal asks for once the
+	// swap list is disabled, unless its message states another.
+	defaultPortalCooldown = 30 * time.Minute
+	// pollJitter is the largest fraction by which an interval is varied.
+	pollJitter = 0.2
+)
+
+const (
+	// postingHistoryDays is how far back first-seen times are read to learn
+	// when shifts are posted.
+	postingHistoryDays = 28
+	// postingProfileTTL is how long a learned posting profile is used before
+	// it is read again.
+	postingProfileTTL = time.Hour
+	// minPostingSamples is the number of shifts seen before the posting
+	// profile is trusted to change the interval.
+	minPostingSamples = 50
+	// busyHourFactor is how many times the average hourly postings an hour of
+	// the week needs to count as busy.
+	busyHourFactor = 2
+)
+
+var (
+	pleaseWaitPattern       = regexp.MustCompile(`Please wait \[(\d+)\] seconds`)
+	swapListDisabledPattern = regexp.MustCompile(`\((\d+)\) minutes idle`)
+)
+
+// pollInterval is the time until the next poll and why it was chosen.
+type pollInterval struct {
+	Interval time.Duration
+	Reason   string
+}
+
+// pollPacing keeps what the scheduler has learned about the portal between
+// polls of this instance.
+type pollPacing struct {
+	mu sync.Mutex
+	// portalMin is the refresh interval the portal last stated, at
+	// portalMinAt
+	portalMin   time.Duration
+	portalMinAt time.Time
+	profile     *postingProfile
+	loadedAt    time.Time
+}
+
+// postingProfile counts the shifts first seen in each hour of the week, in the
+// configured time zone.
+type postingProfile struct {
+	location *time.Location
+	hours    [7 * 24]int
+	total    int
+}
+
+func (p *postingProfile) hour(t time.Time) int {
+	t = t.In(p.location)
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// busy reports whether shifts are usually posted in the hour of the week t
+// falls in.
+func (p *postingProfile) busy(t time.Time) bool {
+	if p.total < minPostingSamples {
+		return false
+	}
+	average := float64(p.total) / float64(len(p.hours))
+	return float64(p.hours[p.hour(t)]) >= busyHourFactor*average
+}
+
+// quiet reports whether no shift has been posted in the hour of the week t
+// falls in nor in the next one, once enough shifts have been seen to tell.
+func (p *postingProfile) quiet(t time.Time) bool {
+	if p.total < minPostingSamples {
+		return false
+	}
+	hour := p.hour(t)
+	return p.hours[hour] == 0 && p.hours[(hour+1)%len(p.hours)] == 0
+}
+
+// portalMinInterval returns the refresh interval the portal enforces: the
+// one it stated within portalMinTTL, or the default.
+func (s *Service) portalMinInterval(now time.Time) time.Duration {
+	s.pacing.mu.Lock()
+	defer s.pacing.mu.Unlock()
+	if s.pacing.portalMin == 0 || now.Sub(s.pacing.portalMinAt) >= portalMinTTL {
+		return defaultPortalMinInterval
+	}
+	return s.pacing.portalMin
+}
+
+// nextInterval chooses the interval until the next poll after a successful
+// one: shorter in the hours shifts are usually posted, longer in the hours
+// they never are, and never below the portal's refresh interval.
+func (s *Service) nextInterval(ctx context.Context, now time.Time) pollInterval {
+	interval := pollInterval{Interval: defaultPollInterval, Reason: IntervalDefault}
+	if profile := s.postingProfile(ctx, now); profile != nil {
+		switch {
+		case profile.busy(now):
+			interval = pollInterval{Interval: busyPollInterval, Reason: IntervalBusyWindow}
+		case profile.quiet(now):
+			interval = pollInterval{Interval: quietPollInterval, Reason: IntervalQuietHours}
+		}
+	}
+	jitter := time.Duration((rand.Float64()*2 - 1) * pollJitter * float64(interval.Interval))
+	interval.Interval += jitter
+	if minimum := s.portalMinInterval(now); interval.Interval < minimum {
+		interval.Interval = minimum
+	}
+	return interval
+}
+
+// hintedInterval returns the interval a portal message asks for, with jitter
+// added so that the next poll does not arrive the instant the wait ends. A
+// refresh interval stated by the portal is kept as the minimum for the polls
+// of the next portalMinTTL.
+func (s *Service) hintedInterval(message string) pollInterval {
+	interval := pollInterval{Interval: defaultPortalMinInterval, Reason: IntervalPortalWait}
+	if match := swapListDisabledPattern.FindStringSubmatch(message); match != nil {
+		minutes, _ := strconv.Atoi(match[1])
+		interval = pollInterval{Interval: time.Duration(minutes) * time.Minute, Reason: IntervalPortalCooldown}
+	} else if match := pleaseWaitPattern.FindStringSubmatch(message); match != nil {
+		seconds, _ := strconv.Atoi(match[1])
+		interval.Interval = time.Duration(seconds) * time.Second
+		s.pacing.mu.Lock()
+		s.pacing.portalMin = interval.Interval
+		s.pacing.portalMinAt = time.Now()
+		s.pacing.mu.Unlock()
+	} else if strings.HasPrefix(message, "Swap list disabled") {
+		interval = pollInterval{Interval: defaultPortalCooldown, Reason: IntervalPortalCooldown}
+	}
+	interval.Interval += time.Duration(rand.Float64() * pollJitter * float64(interval.Interval))
+	return interval
+}
+
+// postingProfile returns the posting profile learned from the first-seen
+// times of the shifts seen recently, reading them again once the profile is
+// older than postingProfileTTL. When they cannot be read the last profile,
+// possibly nil, is kept until the next attempt.
+func (s *Service) postingProfile(ctx context.Context, now time.Time) *postingProfile {
+	s.pacing.mu.Lock()
+	last := s.pacing.profile
+	if !s.pacing.loadedAt.IsZero() && now.Sub(s.pacing.loadedAt) < postingProfileTTL {
+		s.pacing.mu.Unlock()
+		return last
+	}
+	// The history is read outside of the lock, and polls meanwhile keep the
+	// last profile rather than read it too
+	s.pacing.loadedAt = now
+	s.pacing.mu.Unlock()
+
+	profile, err := s.loadPostingProfile(ctx, now)
+	if err != nil {
+		fmt.Printf(`{"message": "Failed to learn posting history", "error": "%v", "severity": "warning"}`+"\n", err)
+		return last
+	}
+	s.pacing.mu.Lock()
+	s.pacing.profile = profile
+	s.pacing.mu.Unlock()
+	return profile
+}
+
+func (s *Service) loadPostingProfile(ctx context.Context, now time.Time) (*postingProfile, error) {
+	location, err := time.LoadLocation(s.config.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	profile := &postingProfile{location: location}
+
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	iter := s.firestoreClient.Collection("available_shifts").
+		Where("firstSeenAt", ">=", now.AddDate(0, 0, -postingHistoryDays)).
+		Select("firstSeenAt").
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if firstSeenAt, ok := doc.Data()["firstSeenAt"].(time.Time); ok {
+			profile.hours[profile.hour(firstSeenAt)]++
+			profile.total++
+		}
+	}
+	return profile, nil
+}
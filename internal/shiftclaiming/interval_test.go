@@ -0,0 +1,121 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+)
+
+// testProfile returns a posting profile where 60 shifts were posted on
+// Mondays at 07:00 UTC and one in every other weekday hour from 10:00 on.
+func testProfile() *postingProfile {
+	profile := &postingProfile{location: time.UTC}
+	monday := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	profile.hours[profile.hour(monday.Add(7*time.Hour))] = 60
+	profile.total = 60
+	for day := 0; day < 5; day++ {
+		for hour := 10; hour < 24; hour++ {
+			profile.hours[profile.hour(monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour))]++
+			profile.total++
+		}
+	}
+	return profile
+}
+
+func TestPostingProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   *postingProfile
+		at        time.Time
+		wantBusy  bool
+		wantQuiet bool
+	}{
+		{"busy hour", testProfile(), time.Date(2024, 5, 13, 7, 30, 0, 0, time.UTC), true, false},
+		{"hour before busy one", testProfile(), time.Date(2024, 5, 13, 6, 30, 0, 0, time.UTC), false, false},
+		{"quiet night", testProfile(), time.Date(2024, 5, 14, 2, 0, 0, 0, time.UTC), false, true},
+		{"some postings", testProfile(), time.Date(2024, 5, 14, 14, 0, 0, 0, time.UTC), false, false},
+		{"too few samples", &postingProfile{location: time.UTC, total: minPostingSamples - 1}, time.Date(2024, 5, 14, 2, 0, 0, 0, time.UTC), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantBusy, tt.profile.busy(tt.at))
+			assert.Equal(t, tt.wantQuiet, tt.profile.quiet(tt.at))
+		})
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantReason string
+		wantBase   time.Duration
+	}{
+		{"busy hour", time.Date(2024, 5, 13, 7, 30, 0, 0, time.UTC), IntervalBusyWindow, busyPollInterval},
+		{"quiet hours", time.Date(2024, 5, 14, 2, 0, 0, 0, time.UTC), IntervalQuietHours, quietPollInterval},
+		{"default", time.Date(2024, 5, 14, 14, 0, 0, 0, time.UTC), IntervalDefault, defaultPollInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{config: &config.Config{TimeZone: "UTC"}}
+			s.pacing.profile = testProfile()
+			s.pacing.loadedAt = tt.at
+			interval := s.nextInterval(context.Background(), tt.at)
+			assert.Equal(t, tt.wantReason, interval.Reason)
+			minimum := max(time.Duration(float64(tt.wantBase)*(1-pollJitter)), defaultPortalMinInterval)
+			assert.GreaterOrEqual(t, interval.Interval, minimum)
+			assert.LessOrEqual(t, interval.Interval, time.Duration(float64(tt.wantBase)*(1+pollJitter)))
+		})
+	}
+
+	t.Run("never below the portal's interval", func(t *testing.T) {
+		at := time.Date(2024, 5, 13, 7, 30, 0, 0, time.UTC)
+		s := &Service{config: &config.Config{TimeZone: "UTC"}}
+		s.pacing.profile = testProfile()
+		s.pacing.loadedAt = at
+		s.pacing.portalMin = 10 * time.Second
+		s.pacing.portalMinAt = at.Add(-time.Minute)
+		assert.Equal(t, 10*time.Second, s.nextInterval(context.Background(), at).Interval)
+	})
+}
+
+func TestHintedInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		message       string
+		wantReason    string
+		wantBase      time.Duration
+		wantPortalMin time.Duration
+	}{
+		{"please wait", "Please wait [12] seconds before refreshing", IntervalPortalWait, 12 * time.Second, 12 * time.Second},
+		{"stated cooldown", "Swap list disabled. (20) minutes idle required", IntervalPortalCooldown, 20 * time.Minute, defaultPortalMinInterval},
+		{"unstated cooldown", "Swap list disabled.", IntervalPortalCooldown, defaultPortalCooldown, defaultPortalMinInterval},
+		{"unknown message", "Too many requests", IntervalPortalWait, defaultPortalMinInterval, defaultPortalMinInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			interval := s.hintedInterval(tt.message)
+			assert.Equal(t, tt.wantReason, interval.Reason)
+			assert.GreaterOrEqual(t, interval.Interval, tt.wantBase)
+			assert.LessOrEqual(t, interval.Interval, time.Duration(float64(tt.wantBase)*(1+pollJitter)))
+			assert.Equal(t, tt.wantPortalMin, s.portalMinInterval(time.Now()))
+		})
+	}
+}
+
+func TestPortalMinIntervalExpires(t *testing.T) {
+	s := &Service{}
+	s.hintedInterval("Please wait [12] seconds before refreshing")
+	now := time.Now()
+	assert.Equal(t, 12*time.Second, s.portalMinInterval(now))
+	assert.Equal(t, 12*time.Second, s.portalMinInterval(now.Add(portalMinTTL-time.Minute)))
+	assert.Equal(t, defaultPortalMinInterval, s.portalMinInterval(now.Add(portalMinTTL)))
+
+	// A lower interval stated later replaces the higher one
+	s.hintedInterval("Please wait [5] seconds before refreshing")
+	assert.Equal(t, 5*time.Second, s.portalMinInterval(time.Now()))
+}
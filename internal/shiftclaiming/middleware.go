@@ -1,15 +1,36 @@
 package shiftclaiming
 
 import (
+	"context"
+	"errors"
 	"net/http"
+
+	customerrors "github.com/yesaswi/shift-claiming-automation/pkg/errors"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement authentication middleware logic
-		// Verify the authentication token or credentials
-		// If authentication fails, return an appropriate error response
-		// If authentication succeeds, call the next handler
-		next.ServeHTTP(w, r)
-	})
+var errAuthRequired = errors.New("an IAP assertion or a bearer ID token is required")
+
+// actorKey is the context key of the actor a request was authenticated as.
+type actorKey struct{}
+
+// RequireAuth rejects with 401 the requests of callers without a verified
+// identity, an IAP assertion or a bearer ID token. It guards the routes that
+// change state or expose the configuration and the audit trail, which the
+// service would otherwise serve to anyone who can reach it.
+//
+// When the service is only reachable through IAP or an IAM-restricted
+// ingress, which authenticate every caller already, TRUST_INGRESS turns the
+// check off, and callers without a token are recorded as anonymous.
+func (s *Service) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor := s.requestActor(r)
+		if actor == actorAnonymous && !s.config.TrustIngress {
+			httpErr := customerrors.LogAndReturnError(errAuthRequired, "Rejected unauthenticated request", "WARNING", http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, httpErr.Error(), httpErr.(customerrors.HTTPError).StatusCode)
+			return
+		}
+		// The handler records the same actor without verifying the token again
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actor)))
+	}
 }
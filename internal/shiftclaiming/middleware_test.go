@@ -0,0 +1,60 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+	"google.golang.org/api/idtoken"
+)
+
+func TestRequireAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		trustIngress bool
+		token        string
+		wantStatus   int
+		wantActor    string
+	}{
+		{"verified token", false, "user", http.StatusOK, "user@example.com"},
+		{"no token", false, "", http.StatusUnauthorized, ""},
+		{"invalid token", false, "forged", http.StatusUnauthorized, ""},
+		{"no token behind trusted ingress", true, "", http.StatusOK, actorAnonymous},
+		{"verified token behind trusted ingress", true, "user", http.StatusOK, "user@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validations := 0
+			s := &Service{
+				config: &config.Config{AuthAudience: "https://service.example.com", TrustIngress: tt.trustIngress},
+				validateToken: func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+					validations++
+					if token == "user" {
+						return &idtoken.Payload{Claims: map[string]interface{}{"email": "user@example.com"}}, nil
+					}
+					return nil, errors.New("invalid token")
+				},
+			}
+			actor := ""
+			handler := s.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+				actor = s.requestOrigin(r, SourceHTTP).Actor
+			})
+			r := httptest.NewRequest("POST", "/start", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantActor, actor)
+			if tt.token != "" {
+				assert.Equal(t, 1, validations, "the token is verified once")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package shiftclaiming
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// Claim outcomes, parsed from the portal's response to a claim.
+const (
+	ClaimClaimed        = "claimed"
+	ClaimAlreadyTaken   = "already_taken"
+	ClaimNotEligible    = "not_eligible"
+	ClaimConflict       = "conflict"
+	ClaimRateLimited    = "rate_limited"
+	ClaimSessionExpired = "session_expired"
+	ClaimUnknown        = "unknown"
+)
+
+const (
+	// maxClaimResponse is the most of a claim response that is read.
+	maxClaimResponse = 64 << 10
+	// maxClaimSnippet is the most of a claim response kept with the claim.
+	maxClaimSnippet = 512
+	// closedShiftsDays is how long a shift that failed permanently is not
+	// claimed again.
+	closedShiftsDays = 14
+)
+
+// claimPhrases map phrases of the portal's claim responses to outcomes. They
+// are matched in order, case-insensitively.
+var claimPhrases = []struct {
+	phrase  string
+	outcome string
+}{
+	{"session timeout", ClaimSessionExpired},
+	{"sign in again", ClaimSessionExpired},
+	{"please wait", ClaimRateLimited},
+	{"swap list disabled", ClaimRateLimited},
+	{"shift not found", ClaimAlreadyTaken},
+	{"already claimed", ClaimAlreadyTaken},
+	{"no longer available", ClaimAlreadyTaken},
+	{"not eligible", ClaimNotEligible},
+	{"not qualified", ClaimNotEligible},
+	{"not allowed", ClaimNotEligible},
+	{"conflict", ClaimConflict},
+	{"overlap", ClaimConflict},
+	{"already scheduled", ClaimConflict},
+}
+
+// classifyClaim returns the outcome of a claim from the status code and body
+// of the portal's response.
+func classifyClaim(statusCode int, body string) string {
+	lower := strings.ToLower(body)
+	for _, p := range claimPhrases {
+		if strings.Contains(lower, p.phrase) {
+			return p.outcome
+		}
+	}
+	switch statusCode {
+	case http.StatusOK:
+		return ClaimClaimed
+	case http.StatusUnauthorized:
+		return ClaimSessionExpired
+	case http.StatusTooManyRequests:
+		return ClaimRateLimited
+	case http.StatusNotFound, http.StatusGone:
+		return ClaimAlreadyTaken
+	case http.StatusForbidden:
+		return ClaimNotEligible
+	case http.StatusConflict:
+		return ClaimConflict
+	}
+	return ClaimUnknown
+}
+
+// permanentOutcome reports whether a claim with the outcome can never succeed,
+// so that the shift is not claimed again.
+func permanentOutcome(outcome string) bool {
+	return outcome == ClaimAlreadyTaken || outcome == ClaimNotEligible || outcome == ClaimConflict
+}
+
+// haltingOutcome reports whether a claim with the outcome means no further
+// claims can succeed for now.
+func haltingOutcome(outcome string) bool {
+	return outcome == ClaimRateLimited || outcome == ClaimSessionExpired
+}
+
+// haltingClaim returns the first claim whose outcome halts claiming, or nil.
+// An expired session takes precedence since it needs the user.
+func haltingClaim(claimingResults []ClaimingResult) *ClaimingResult {
+	var halting *ClaimingResult
+	for i := range claimingResults {
+		switch claimingResults[i].Outcome {
+		case ClaimSessionExpired:
+			return &claimingResults[i]
+		case ClaimRateLimited:
+			if halting == nil {
+				halting = &claimingResults[i]
+			}
+		}
+	}
+	return halting
+}
+
+// closedShifts are the postings whose claims failed permanently, by
+// shiftKey, as known to this instance. They are loaded from the store on
+// first use. A posting is closed rather than its shift, since a shift that is
+// posted again may well be claimable.
+type closedShifts struct {
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]closedShift
+}
+
+// closedShift is a posting closed at a time, with the outcome that closed it.
+type closedShift struct {
+	outcome  string
+	closedAt time.Time
+}
+
+// closedShiftSet returns the outcomes of the postings that are not to be
+// claimed again, by shiftKey, dropping those closed more than
+// closedShiftsDays ago. When they cannot be loaded every shift is claimed,
+// and loading is tried again on the next poll.
+func (s *Service) closedShiftSet(ctx context.Context) map[string]string {
+	s.closed.mu.Lock()
+	defer s.closed.mu.Unlock()
+	if !s.closed.loaded {
+		entries, err := s.loadClosedShifts(ctx)
+		if err != nil {
+			fmt.Printf(`{"message": "Failed to load closed shifts", "error": "%v", "severity": "warning"}`+"\n", err)
+			return nil
+		}
+		s.closed.entries = entries
+		s.closed.loaded = true
+	}
+	return s.closed.current(time.Now())
+}
+
+// current drops the entries that have expired by now and returns the
+// outcomes of the rest. It must be called with the lock held.
+func (c *closedShifts) current(now time.Time) map[string]string {
+	expiry := now.AddDate(0, 0, -closedShiftsDays)
+	set := make(map[string]string, len(c.entries))
+	for key, entry := range c.entries {
+		if entry.closedAt.Before(expiry) {
+			delete(c.entries, key)
+			continue
+		}
+		set[key] = entry.outcome
+	}
+	return set
+}
+
+// loadClosedShifts reads the postings closed within closedShiftsDays. Their
+// documents are named by shiftKey.
+func (s *Service) loadClosedShifts(ctx context.Context) (map[string]closedShift, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	entries := map[string]closedShift{}
+	iter := s.firestoreClient.Collection("closed_shifts").
+		Where("timestamp", ">=", time.Now().AddDate(0, 0, -closedShiftsDays)).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := closedShift{}
+		entry.outcome, _ = doc.Data()["outcome"].(string)
+		entry.closedAt, _ = doc.Data()["timestamp"].(time.Time)
+		entries[doc.Ref.ID] = entry
+	}
+}
+
+// closeShift records that the posting of a permanently failed claim is not to
+// be claimed again.
+func (s *Service) closeShift(result ClaimingResult) {
+	if result.PostingKey == "" {
+		return
+	}
+	s.closed.mu.Lock()
+	if s.closed.entries == nil {
+		s.closed.entries = map[string]closedShift{}
+	}
+	s.closed.entries[result.PostingKey] = closedShift{outcome: result.Outcome, closedAt: result.Timestamp}
+	s.closed.mu.Unlock()
+	if err := s.writes.Set(s.firestoreClient.Collection("closed_shifts").Doc(result.PostingKey), map[string]interface{}{
+		"timestamp": result.Timestamp,
+		"shiftId":   result.ShiftID,
+		"outcome":   result.Outcome,
+		"response":  result.Response,
+	}); err != nil {
+		fmt.Printf(`{"message": "Failed to record closed posting", "posting_key": "%s", "error": "%v", "severity": "warning"}`+"\n", result.PostingKey, err)
+	}
+}
@@ -0,0 +1,84 @@
+package shiftclaiming
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/portal"
+)
+
+func TestClassifyClaim(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       string
+	}{
+		{"success", http.StatusOK, "", ClaimClaimed},
+		{"success with message", http.StatusOK, "Shift claimed", ClaimClaimed},
+		{"phrase over status", http.StatusOK, "This shift is no longer available", ClaimAlreadyTaken},
+		{"phrase case-insensitive", http.StatusBadRequest, "You are NOT ELIGIBLE for this shift", ClaimNotEligible},
+		{"first phrase wins", http.StatusBadRequest, "Session Timeout. Please wait and sign in again.", ClaimSessionExpired},
+		{"please wait", http.StatusBadRequest, "Please wait [10] seconds", ClaimRateLimited},
+		{"overlap", http.StatusBadRequest, "Shift would overlap another", ClaimConflict},
+		{"unauthorized", http.StatusUnauthorized, "", ClaimSessionExpired},
+		{"too many requests", http.StatusTooManyRequests, "", ClaimRateLimited},
+		{"gone", http.StatusGone, "", ClaimAlreadyTaken},
+		{"forbidden", http.StatusForbidden, "", ClaimNotEligible},
+		{"conflict status", http.StatusConflict, "", ClaimConflict},
+		{"server error", http.StatusInternalServerError, "oops", ClaimUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, classifyClaim(tt.statusCode, tt.body))
+		})
+	}
+}
+
+func TestClosedShiftsExpire(t *testing.T) {
+	now := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	closed := closedShifts{entries: map[string]closedShift{
+		"1234-7": {outcome: ClaimAlreadyTaken, closedAt: now.Add(-time.Hour)},
+		"1234-8": {outcome: ClaimConflict, closedAt: now.AddDate(0, 0, -closedShiftsDays).Add(time.Minute)},
+		"5678-9": {outcome: ClaimNotEligible, closedAt: now.AddDate(0, 0, -closedShiftsDays).Add(-time.Minute)},
+	}}
+	assert.Equal(t, map[string]string{"1234-7": ClaimAlreadyTaken, "1234-8": ClaimConflict}, closed.current(now))
+	assert.NotContains(t, closed.entries, "5678-9")
+}
+
+func TestClaimShiftsSkipsClosedPostings(t *testing.T) {
+	var claimed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claimed = append(claimed, r.URL.Query().Get("schid")+"-"+r.URL.Query().Get("id"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	baseURL := portal.BaseURL
+	portal.BaseURL = server.URL
+	defer func() { portal.BaseURL = baseURL }()
+	client, err := portal.NewClient(portal.Options{MaxConns: 1})
+	require.NoError(t, err)
+	defer client.Close()
+
+	settings := &claimSettings{
+		shiftStartDate:   "2024-05-01",
+		shiftGroup:       "A",
+		strategy:         boardStrategy{},
+		claimParallelism: 1,
+		closedShifts:     map[string]string{"1234-7": ClaimAlreadyTaken},
+	}
+	shifts := []ScoredShift{
+		{Shift: Shift{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}},
+		// The same shift posted again
+		{Shift: Shift{Id: 8, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}},
+	}
+	results := claimShifts(context.Background(), client, shifts, newClaimBudget(0, nil, nil), settings)
+	require.Len(t, results, 1)
+	assert.Equal(t, "1234-8", results[0].PostingKey)
+	assert.Equal(t, []string{"1234-8"}, claimed)
+}
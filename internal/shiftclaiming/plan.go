@@ -0,0 +1,83 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPortal is returned when a plan cannot be made because the portal could
+// not be read.
+var ErrPortal = errors.New("portal request failed")
+
+// PlanActionSkip marks a shift matched by a claim rule that no longer fits the
+// weekly hours cap, conflicts with a shift claimed before it or is a posting
+// whose claim failed permanently before.
+const PlanActionSkip = "skip"
+
+// PlannedShift is a shift on the swapboard together with what a poll would do
+// with it.
+type PlannedShift struct {
+	Shift
+	Score  float64 `json:"score"`
+	Action string  `json:"action"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// ClaimPlan describes which shifts a poll would claim, in claiming order, and
+// why the remaining ones would be notified about, ignored or skipped.
+type ClaimPlan struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Strategy  string         `json:"strategy"`
+	Shifts    []PlannedShift `json:"shifts"`
+}
+
+// PlanClaims fetches the live swapboard and runs the same filters, caps and
+// conflict checks as ClaimShift without claiming anything or scheduling tasks.
+func (s *Service) PlanClaims(ctx context.Context) (*ClaimPlan, error) {
+	fmt.Println(`{"message": "Planning shift claims...", "severity": "info"}`)
+
+	settings, err := s.loadClaimSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	budget, err := s.newClaimBudget(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	availableShifts, err := fetchAvailableShifts(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch available shifts: %v", ErrPortal, err)
+	}
+	return planClaims(rankShifts(availableShifts, settings.strategy), budget, settings), nil
+}
+
+// planClaims assumes every claim succeeds, so the budget is consumed the same
+// way a fully successful poll would consume it.
+func planClaims(shifts []ScoredShift, budget *claimBudget, settings *claimSettings) *ClaimPlan {
+	plan := &ClaimPlan{
+		Timestamp: time.Now(),
+		Strategy:  settings.strategy.Name(),
+		Shifts:    []PlannedShift{},
+	}
+	for _, shift := range shifts {
+		action, reason := evaluateShift(shift.Shift, settings)
+		if action == RuleActionClaim {
+			if closedReason := settings.closedReason(shift.Shift); closedReason != "" {
+				action, reason = PlanActionSkip, closedReason
+			} else if budgetReason := budget.check(shift.Shift); budgetReason != "" {
+				action, reason = PlanActionSkip, budgetReason
+			} else {
+				budget.reserve(shift.Shift)
+			}
+		}
+		plan.Shifts = append(plan.Shifts, PlannedShift{
+			Shift:  shift.Shift,
+			Score:  shift.Score,
+			Action: action,
+			Reason: reason,
+		})
+	}
+	return plan
+}
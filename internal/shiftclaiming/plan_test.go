@@ -0,0 +1,90 @@
+package shiftclaiming
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanClaims(t *testing.T) {
+	settings := &claimSettings{
+		shiftStartDate: "2024-05-01",
+		shiftGroup:     "A",
+		strategy:       boardStrategy{},
+		closedShifts:   map[string]string{"1234-7": ClaimAlreadyTaken},
+	}
+	shifts := []ScoredShift{
+		{Shift: Shift{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A", Start: "07:00", End: "15:00"}},
+		// The same shift posted again
+		{Shift: Shift{Id: 8, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A", Start: "07:00", End: "15:00"}},
+		{Shift: Shift{Id: 9, SchId: 5678, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A", Start: "14:00", End: "22:00"}},
+		{Shift: Shift{Id: 10, SchId: 9012, Date: "2024-05-07T00:00:00", Hours: 8, ShiftGroup: "B", Start: "07:00", End: "15:00"}},
+	}
+	plan := planClaims(shifts, newClaimBudget(0, nil, nil), settings)
+
+	type planned struct{ action, reason string }
+	var got []planned
+	for _, shift := range plan.Shifts {
+		got = append(got, planned{shift.Action, shift.Reason})
+	}
+	assert.Equal(t, []planned{
+		{PlanActionSkip, "previous claim failed as already_taken"},
+		{RuleActionClaim, "shift group A in A"},
+		{PlanActionSkip, "conflicts with shift 1234"},
+		{RuleActionIgnore, "shift group B not in A"},
+	}, got)
+}
+
+func TestStartClaimingDryRun(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		s, _, store := newPollService(t, nil, 0)
+		tasks, tasksClient := newFakeTasks(t)
+		s.cloudTasksClient = tasksClient
+		s.config.TasksTimeout = 10 * time.Second
+		store.seed("configuration/config", map[string]interface{}{"startStopFlag": false, "dryRun": !dryRun})
+
+		url := "/start"
+		if dryRun {
+			url += "?dry_run=true"
+		}
+		rec := httptest.NewRecorder()
+		s.HandleStartCommand(rec, httptest.NewRequest(http.MethodPost, url, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		config := store.writtenDoc("configuration/config")
+		require.NotNil(t, config)
+		assert.Equal(t, dryRun, config["dryRun"].GetBooleanValue())
+		assert.True(t, config["startStopFlag"].GetBooleanValue())
+		assert.Len(t, tasks.createdTasks(), 1, "the first poll is queued either way")
+
+		// The audit trail records the mode claiming was started in
+		audit := store.writtenDoc("audit/")
+		require.NotNil(t, audit)
+		assert.Equal(t, AuditStart, audit["action"].GetStringValue())
+		after := audit["after"].GetMapValue().GetFields()
+		assert.Equal(t, dryRun, after["dryRun"].GetBooleanValue())
+	}
+}
+
+func TestRunPollDryRun(t *testing.T) {
+	s, fake, store := newPollService(t, benchBoard(3, time.Now().AddDate(0, 0, 1).Truncate(24*time.Hour)), 0)
+	store.seed("configuration/config", map[string]interface{}{"startStopFlag": true, "dryRun": true})
+	ctx := context.Background()
+
+	held, err := s.leases.Acquire(ctx, pollerLease, s.instanceID, s.config.LeaseTTL)
+	require.NoError(t, err)
+	_, err = s.runPoll(withPollLease(ctx, held), Origin{Source: SourceScheduler})
+	require.NoError(t, err)
+	require.NoError(t, s.writes.Flush(ctx))
+
+	assert.Negative(t, fake.firstClaim(), "no claim is sent in dry-run mode")
+	for _, doc := range store.writtenDocs() {
+		assert.False(t, strings.HasPrefix(doc, "claims/"), "no claim is recorded in dry-run mode: %s", doc)
+	}
+}
@@ -0,0 +1,165 @@
+package shiftclaiming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/notify"
+	"github.com/yesaswi/shift-claiming-automation/internal/portal"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+)
+
+// benchPortal serves a fixed swapboard and schedule and accepts every claim,
+// noting when the board was last sent and when the first claim after it
+// arrived.
+type benchPortal struct {
+	board    []byte
+	schedule []byte
+
+	mu           sync.Mutex
+	boardSentAt  time.Time
+	firstClaimAt time.Time
+}
+
+func (p *benchPortal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case portal.SwapboardPath:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(p.board)
+		p.mu.Lock()
+		p.boardSentAt = time.Now()
+		p.firstClaimAt = time.Time{}
+		p.mu.Unlock()
+	case portal.ClaimPath:
+		p.mu.Lock()
+		if p.firstClaimAt.IsZero() {
+			p.firstClaimAt = time.Now()
+		}
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case portal.SchedulePath:
+		if p.schedule == nil {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(p.schedule)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// firstClaim returns the time from the last board sent to the first claim
+// after it, or -1 when no claim came.
+func (p *benchPortal) firstClaim() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.firstClaimAt.IsZero() {
+		return -1
+	}
+	return p.firstClaimAt.Sub(p.boardSentAt)
+}
+
+func benchBoard(shifts int, date time.Time) []Shift {
+	board := make([]Shift, shifts)
+	for i := range board {
+		board[i] = Shift{
+			Id:         1000 + i,
+			SchId:      50000 + i,
+			LocId:      1,
+			StnName:    "Bench",
+			Date:       date.AddDate(0, 0, i%7).Format("2006-01-02T15:04:05"),
+			Hours:      8,
+			ShiftGroup: "A",
+			Start:      "07:00",
+			End:        "15:00",
+		}
+	}
+	return board
+}
+
+// BenchmarkPollFirstClaim runs polls against a fake portal and a fake store
+// and reports the time from the portal sending the swapboard to it receiving
+// the first claim. The store latency should not show in it.
+func BenchmarkPollFirstClaim(b *testing.B) {
+	for _, storeLatency := range []time.Duration{0, 10 * time.Millisecond} {
+		b.Run(fmt.Sprintf("store=%v", storeLatency), func(b *testing.B) {
+			benchmarkPollFirstClaim(b, storeLatency)
+		})
+	}
+}
+
+// newPollService returns a service polling the given board on a fake portal,
+// with a fake store that answers after storeLatency.
+func newPollService(t testing.TB, board []Shift, storeLatency time.Duration) (*Service, *benchPortal, *fakeStore) {
+	t.Helper()
+	boardJSON, err := json.Marshal(board)
+	require.NoError(t, err)
+	fake := &benchPortal{board: boardJSON}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	baseURL := portal.BaseURL
+	portal.BaseURL = server.URL
+	t.Cleanup(func() { portal.BaseURL = baseURL })
+
+	store, firestoreClient := newFakeStore(t, storeLatency)
+	store.seed("configuration/config", map[string]interface{}{"startStopFlag": true})
+	store.seed("configuration/shiftconfig", map[string]interface{}{
+		"shift_start_date": time.Now().Format("2006-01-02"),
+		"shift_range":      "7",
+		"shift_group":      "A",
+		"max_weekly_hours": 400.0,
+	})
+	store.seed("configuration/auth", map[string]interface{}{"cookie": "cookie", "x_api_token": "token", "user_id": "42"})
+
+	cfg := &config.Config{
+		TimeZone:         "UTC",
+		ClaimParallelism: 4,
+		LeaseStore:       LeaseStoreMemory,
+		LeaseTTL:         time.Minute,
+		StoreTimeout:     10 * time.Second,
+	}
+	portalClient, err := portal.NewClient(portal.Options{MaxConns: cfg.ClaimParallelism + 1})
+	require.NoError(t, err)
+	t.Cleanup(portalClient.Close)
+	s := NewService(cfg, firestoreClient, nil, notify.NewClient(""), portalClient)
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s, fake, store
+}
+
+func benchmarkPollFirstClaim(b *testing.B, storeLatency time.Duration) {
+	s, fake, _ := newPollService(b, benchBoard(20, time.Now().AddDate(0, 0, 1).Truncate(24*time.Hour)), storeLatency)
+	cfg := s.config
+
+	ctx := context.Background()
+	var samples []time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		held, err := s.leases.Acquire(ctx, pollerLease, s.instanceID, cfg.LeaseTTL)
+		require.NoError(b, err)
+		_, err = s.runPoll(withPollLease(ctx, held), Origin{Source: SourceScheduler})
+		require.NoError(b, err)
+		require.NoError(b, s.leases.Release(ctx, held))
+		if latency := fake.firstClaim(); latency >= 0 {
+			samples = append(samples, latency)
+		}
+	}
+	b.StopTimer()
+	require.NotEmpty(b, samples, "no claims were sent")
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	b.ReportMetric(float64(total.Nanoseconds())/float64(len(samples)), "ns/first-claim")
+	b.ReportMetric(float64(samples[len(samples)*99/100].Nanoseconds()), "p99-ns/first-claim")
+}
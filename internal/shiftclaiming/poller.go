@@ -0,0 +1,305 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Poller modes. In the tasks mode every poll is a Cloud Tasks task that queues
+// the next one. In the loop mode an instance holding the poller lease polls in
+// a goroutine, and Cloud Tasks only carries a coarse heartbeat that revives
+// the loop when the lease holder has gone. The loop mode needs an instance
+// that is kept running, such as with min-instances=1.
+const (
+	PollerModeTasks = "tasks"
+	PollerModeLoop  = "loop"
+)
+
+// Lease stores. The memory store only excludes polls within one instance.
+const (
+	LeaseStoreFirestore = "firestore"
+	LeaseStoreMemory    = "memory"
+)
+
+// loopRetryDelay is how long the loop waits after a failed poll.
+const loopRetryDelay = 5 * time.Second
+
+// pollerLease is the lease a poll must hold before it reads the swapboard.
+const pollerLease = "poller"
+
+// pollLoop is the poll loop running in this instance, if any. Its handle is
+// kept until the loop has exited, so that a loop that is still stopping is
+// not mistaken for none.
+type pollLoop struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	// drain is closed to stop the loop once its current poll is done
+	drain chan struct{}
+	done  chan struct{}
+	// closed is set once the instance shuts down, after which no loop is
+	// started
+	closed bool
+}
+
+// newInstanceID names this instance as a lease holder.
+func newInstanceID() string {
+	name := os.Getenv("K_REVISION")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return fmt.Sprintf("%s-%s", name, newRequestID()[:8])
+}
+
+// pollLeaseKey is the context key of the lease a poll runs under.
+type pollLeaseKey struct{}
+
+// withPollLease returns a context carrying the lease the poll runs under, so
+// that the poll's writes are fenced with it.
+func withPollLease(ctx context.Context, held *lease.Lease) context.Context {
+	return context.WithValue(ctx, pollLeaseKey{}, held)
+}
+
+func pollLease(ctx context.Context) *lease.Lease {
+	held, _ := ctx.Value(pollLeaseKey{}).(*lease.Lease)
+	return held
+}
+
+// write queues a write of a poll's results, fenced with the lease the poll
+// runs under if any.
+func (s *Service) write(ctx context.Context, doc *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) {
+	if err := s.writes.SetFenced(pollLease(ctx), doc, data, opts...); err != nil {
+		fmt.Printf(`{"message": "Failed to queue write", "doc": "%s", "error": "%v", "severity": "warning"}`+"\n", doc.Path, err)
+	}
+}
+
+// startLoop queues the heartbeat that keeps the loop alive and starts the
+// loop in this instance.
+func (s *Service) startLoop(ctx context.Context, origin Origin) error {
+	if err := s.ScheduleClaimTask(ctx, time.Now().Add(s.config.HeartbeatInterval)); err != nil {
+		return fmt.Errorf("failed to schedule heartbeat: %v", err)
+	}
+	_, err := s.reviveLoop(ctx, origin)
+	return err
+}
+
+// heartbeat handles a claim task in loop mode. While claiming is enabled it
+// queues the next heartbeat and starts the loop in this instance if no
+// instance holds the lease.
+func (s *Service) heartbeat(ctx context.Context, origin Origin) error {
+	storeCtx, cancel := s.storeContext(ctx)
+	configDocSnap, err := s.firestoreClient.Collection("configuration").Doc("config").Get(storeCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve configuration: %v", err)
+	}
+	if enabled, _ := configDocSnap.Data()["startStopFlag"].(bool); !enabled {
+		fmt.Println(`{"message": "Claiming is disabled, heartbeat stopped", "severity": "info"}`)
+		return nil
+	}
+	if err := s.ScheduleClaimTask(ctx, time.Now().Add(s.config.HeartbeatInterval)); err != nil {
+		return fmt.Errorf("failed to schedule heartbeat: %v", err)
+	}
+
+	// A loop that stopped on an expired session stays stopped until
+	// claiming is started again with new credentials
+	storeCtx, cancel = s.storeContext(ctx)
+	stateDocSnap, err := s.firestoreClient.Collection("state").Doc("poller").Get(storeCtx)
+	cancel()
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to retrieve poller state: %v", err)
+	}
+	if valid, ok := stateDocSnap.Data()["credentialsValid"].(bool); ok && !valid {
+		fmt.Println(`{"message": "Session expired, poll loop not revived", "severity": "warning"}`)
+		return nil
+	}
+
+	revived, err := s.reviveLoop(ctx, origin)
+	if err != nil {
+		return err
+	}
+	if revived {
+		fmt.Printf(`{"message": "Poll loop revived by heartbeat", "instance": "%s", "severity": "notice"}`+"\n", s.instanceID)
+	}
+	return nil
+}
+
+// reviveLoop starts the loop in this instance unless it is already running,
+// the instance is shutting down or another instance holds the lease. A loop
+// of this instance that was cancelled is waited for first, so that claiming
+// started right after it was stopped is not lost. It reports whether the loop
+// was started.
+func (s *Service) reviveLoop(ctx context.Context, origin Origin) (bool, error) {
+	s.loop.mu.Lock()
+	defer s.loop.mu.Unlock()
+	for s.loop.cancel != nil && !s.loop.closed {
+		if s.loop.ctx.Err() == nil {
+			return false, nil
+		}
+		done := s.loop.done
+		s.loop.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			s.loop.mu.Lock()
+			return false, ctx.Err()
+		}
+		s.loop.mu.Lock()
+	}
+	if s.loop.closed {
+		return false, nil
+	}
+	held, err := s.leases.Acquire(ctx, pollerLease, s.instanceID, s.config.LeaseTTL)
+	if errors.Is(err, lease.ErrHeld) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire poller lease: %v", err)
+	}
+	loopCtx, cancel := context.WithCancel(withPollLease(context.Background(), held))
+	s.loop.ctx = loopCtx
+	s.loop.cancel = cancel
+	s.loop.drain = make(chan struct{})
+	s.loop.done = make(chan struct{})
+	loopOrigin := Origin{Actor: actorSystem, Source: SourcePoller, RequestID: s.instanceID}
+	if origin.Actor != actorAnonymous {
+		loopOrigin.Actor = origin.Actor
+	}
+	go s.runLoop(loopCtx, cancel, held, loopOrigin, s.loop.drain, s.loop.done)
+	return true, nil
+}
+
+// stopLoop cancels the loop running in this instance without waiting for it,
+// since it may be called from within the loop.
+func (s *Service) stopLoop() chan struct{} {
+	s.loop.mu.Lock()
+	defer s.loop.mu.Unlock()
+	if s.loop.cancel == nil {
+		return nil
+	}
+	s.loop.cancel()
+	return s.loop.done
+}
+
+// drainLoop asks the loop running in this instance to stop once its current
+// poll is done, so that claims already sent are recorded, and keeps the loop
+// from being started again.
+func (s *Service) drainLoop() chan struct{} {
+	s.loop.mu.Lock()
+	defer s.loop.mu.Unlock()
+	s.loop.closed = true
+	if s.loop.cancel == nil {
+		return nil
+	}
+	select {
+	case <-s.loop.drain:
+	default:
+		close(s.loop.drain)
+	}
+	return s.loop.done
+}
+
+// runLoop polls until polling stops, the lease is lost or the loop is
+// cancelled or drained, renewing the lease a few times per TTL.
+func (s *Service) runLoop(ctx context.Context, cancel context.CancelFunc, held *lease.Lease, origin Origin, drain, done chan struct{}) {
+	fmt.Printf(`{"message": "Poll loop started", "instance": "%s", "severity": "notice"}`+"\n", s.instanceID)
+	defer func() {
+		cancel()
+		releaseCtx, cancelRelease := s.storeContext(context.Background())
+		// Writes still buffered are fenced with the lease, so they go first
+		if err := s.writes.Flush(releaseCtx); err != nil {
+			fmt.Printf(`{"message": "Failed to flush buffered writes", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+		if err := s.leases.Release(releaseCtx, held); err != nil {
+			fmt.Printf(`{"message": "Failed to release poller lease", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+		cancelRelease()
+		fmt.Printf(`{"message": "Poll loop stopped", "instance": "%s", "severity": "notice"}`+"\n", s.instanceID)
+		s.loop.mu.Lock()
+		s.loop.ctx = nil
+		s.loop.cancel = nil
+		s.loop.mu.Unlock()
+		close(done)
+	}()
+	lost := s.leases.Watch(ctx, held)
+	go func() {
+		<-lost
+		if ctx.Err() == nil {
+			fmt.Println(`{"message": "Poller lease lost, stopping poll loop", "severity": "notice"}`)
+			cancel()
+		}
+	}()
+
+	// The lease is renewed while polls run too, since a slow poll must not
+	// outlive it
+	go s.renewLease(ctx, held, cancel)
+	for {
+		s.loopPolls.Lock()
+		next, err := s.runPoll(ctx, origin)
+		s.loopPolls.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf(`{"message": "Poll failed", "error": "%v", "severity": "error"}`+"\n", err)
+			next = time.Now().Add(loopRetryDelay)
+		}
+		if next.IsZero() {
+			return
+		}
+		select {
+		case <-drain:
+			return
+		default:
+		}
+
+		wait := time.NewTimer(time.Until(next))
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				wait.Stop()
+				return
+			case <-drain:
+				wait.Stop()
+				return
+			case <-wait.C:
+				break waiting
+			}
+		}
+	}
+}
+
+// renewLease renews the lease a few times per TTL until ctx is done, calling
+// lost once the lease has been revoked or granted to another holder.
+func (s *Service) renewLease(ctx context.Context, held *lease.Lease, lost func()) {
+	renew := time.NewTicker(s.config.LeaseTTL / 3)
+	defer renew.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-renew.C:
+			renewCtx, cancel := s.storeContext(ctx)
+			_, err := s.leases.Renew(renewCtx, held, s.config.LeaseTTL)
+			cancel()
+			if errors.Is(err, lease.ErrLost) {
+				lost()
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				// The lease lasts until its TTL runs out
+				fmt.Printf(`{"message": "Failed to renew poller lease", "error": "%v", "severity": "warning"}`+"\n", err)
+			}
+		}
+	}
+}
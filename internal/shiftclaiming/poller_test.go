@@ -0,0 +1,42 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviveLoopWaitsForStoppingLoop(t *testing.T) {
+	// Store calls are slow enough for the loop to be mid-poll when stopped
+	s, _, _ := newPollService(t, nil, 20*time.Millisecond)
+	ctx := context.Background()
+	origin := Origin{Actor: actorSystem, Source: SourceScheduler}
+
+	revived, err := s.reviveLoop(ctx, origin)
+	require.NoError(t, err)
+	require.True(t, revived)
+	revived, err = s.reviveLoop(ctx, origin)
+	require.NoError(t, err)
+	assert.False(t, revived, "a running loop is not revived")
+
+	time.Sleep(30 * time.Millisecond)
+	stopped := s.stopLoop()
+	revived, err = s.reviveLoop(ctx, origin)
+	require.NoError(t, err)
+	assert.True(t, revived, "a loop started while the old one stops runs once it has")
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("the new loop started before the old one exited")
+	}
+
+	done := s.drainLoop()
+	require.NotNil(t, done)
+	<-done
+	revived, err = s.reviveLoop(ctx, origin)
+	require.NoError(t, err)
+	assert.False(t, revived, "no loop is started once the instance shuts down")
+}
@@ -0,0 +1,181 @@
+package shiftclaiming
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	RuleActionClaim  = "claim"
+	RuleActionNotify = "notify"
+	RuleActionIgnore = "ignore"
+)
+
+// FilterRule matches shifts by station, shift group and day of the week. Empty
+// criteria match every shift. The first matching rule decides the action.
+type FilterRule struct {
+	Name     string         `json:"name,omitempty"`
+	Action   string         `json:"action"`
+	Stations []string       `json:"stations,omitempty"`
+	Groups   []string       `json:"groups,omitempty"`
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+}
+
+func (r FilterRule) matches(shift Shift, shiftDate time.Time) bool {
+	if len(r.Stations) > 0 && !containsFold(r.Stations, shift.StnName) {
+		return false
+	}
+	if len(r.Groups) > 0 && !containsFold(r.Groups, shift.ShiftGroup) {
+		return false
+	}
+	if len(r.Weekdays) > 0 {
+		found := false
+		for _, weekday := range r.Weekdays {
+			if weekday == shiftDate.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// describe names the rule for plan reasons and notifications.
+func (r FilterRule) describe() string {
+	if r.Name != "" {
+		return fmt.Sprintf("rule %q", r.Name)
+	}
+	return fmt.Sprintf("%s rule", r.Action)
+}
+
+// evaluateShift applies the start date filter and the filter rules, returning
+// the action to take and the reason for it. The legacy 'shift_group' setting
+// acts as a final claim rule after the configured rules.
+func evaluateShift(shift Shift, settings *claimSettings) (string, string) {
+	shiftDate, err := time.Parse("2006-01-02T15:04:05", shift.Date)
+	if err != nil {
+		return RuleActionIgnore, fmt.Sprintf("invalid shift date %q", shift.Date)
+	}
+	compareDate, err := time.Parse("2006-01-02", settings.shiftStartDate)
+	if err != nil {
+		return RuleActionIgnore, fmt.Sprintf("invalid shift start date %q", settings.shiftStartDate)
+	}
+	if shiftDate.Before(compareDate) {
+		return RuleActionIgnore, fmt.Sprintf("before shift start date %s", settings.shiftStartDate)
+	}
+	for _, rule := range settings.rules {
+		if rule.matches(shift, shiftDate) {
+			return rule.Action, fmt.Sprintf("matched %s", rule.describe())
+		}
+	}
+	if shift.ShiftGroup == "" {
+		// Every group setting contains the empty string
+		return RuleActionIgnore, "shift has no shift group"
+	}
+	if containsFold(shiftGroups(settings.shiftGroup), shift.ShiftGroup) {
+		return RuleActionClaim, fmt.Sprintf("shift group %s in %s", shift.ShiftGroup, settings.shiftGroup)
+	}
+	return RuleActionIgnore, fmt.Sprintf("shift group %s not in %s", shift.ShiftGroup, settings.shiftGroup)
+}
+
+// shiftGroups splits the comma-separated 'shift_group' setting into the
+// groups it names, e.g. "A, C1" into A and C1.
+func shiftGroups(setting string) []string {
+	var groups []string
+	for _, group := range strings.Split(setting, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// rulesFromConfig parses the 'rules' list of the shift configuration.
+func rulesFromConfig(value interface{}) ([]FilterRule, error) {
+	if value == nil {
+		return nil, nil
+	}
+	rawRules, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of rules")
+	}
+	var rules []FilterRule
+	for i, rawRule := range rawRules {
+		ruleData, ok := rawRule.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("rule %d is not a map", i)
+		}
+		rule := FilterRule{}
+		rule.Name, _ = ruleData["name"].(string)
+		rule.Action, _ = ruleData["action"].(string)
+		switch rule.Action {
+		case RuleActionClaim, RuleActionNotify, RuleActionIgnore:
+		default:
+			return nil, fmt.Errorf("rule %d has unknown action %q", i, rule.Action)
+		}
+		var err error
+		if rule.Stations, err = stringsFromConfig(ruleData["stations"]); err != nil {
+			return nil, fmt.Errorf("rule %d has invalid stations: %v", i, err)
+		}
+		if rule.Groups, err = stringsFromConfig(ruleData["groups"]); err != nil {
+			return nil, fmt.Errorf("rule %d has invalid groups: %v", i, err)
+		}
+		weekdays, err := stringsFromConfig(ruleData["weekdays"])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d has invalid weekdays: %v", i, err)
+		}
+		for _, name := range weekdays {
+			weekday, ok := parseWeekday(name)
+			if !ok {
+				return nil, fmt.Errorf("rule %d has unknown weekday %q", i, name)
+			}
+			rule.Weekdays = append(rule.Weekdays, weekday)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func stringsFromConfig(value interface{}) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	rawValues, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of strings")
+	}
+	var values []string
+	for _, rawValue := range rawValues {
+		s, ok := rawValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings")
+		}
+		values = append(values, strings.TrimSpace(s))
+	}
+	return values, nil
+}
+
+// parseWeekday accepts full and three letter weekday names.
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		full := strings.ToLower(weekday.String())
+		if name == full || name == full[:3] {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
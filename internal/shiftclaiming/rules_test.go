@@ -0,0 +1,109 @@
+package shiftclaiming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateShift(t *testing.T) {
+	settings := &claimSettings{
+		shiftStartDate: "2024-05-06",
+		shiftGroup:     "A,B",
+		rules: []FilterRule{
+			{Name: "never north", Action: RuleActionIgnore, Stations: []string{"North"}},
+			{Name: "weekend nights", Action: RuleActionNotify, Groups: []string{"C1"}, Weekdays: []time.Weekday{time.Saturday, time.Sunday}},
+			{Action: RuleActionClaim, Groups: []string{"c2"}},
+		},
+	}
+	tests := []struct {
+		name       string
+		shift      Shift
+		wantAction string
+		wantReason string
+	}{
+		{"invalid date", Shift{Date: "May 6"}, RuleActionIgnore, `invalid shift date "May 6"`},
+		{"before start date", Shift{Date: "2024-05-05T00:00:00", ShiftGroup: "A"}, RuleActionIgnore, "before shift start date 2024-05-06"},
+		{"first rule wins", Shift{Date: "2024-05-11T00:00:00", StnName: "north", ShiftGroup: "C1"}, RuleActionIgnore, `matched rule "never north"`},
+		{"weekday matches", Shift{Date: "2024-05-11T00:00:00", StnName: "South", ShiftGroup: "C1"}, RuleActionNotify, `matched rule "weekend nights"`},
+		{"weekday does not match", Shift{Date: "2024-05-08T00:00:00", StnName: "South", ShiftGroup: "C1"}, RuleActionIgnore, "shift group C1 not in A,B"},
+		{"unnamed rule, case-insensitive", Shift{Date: "2024-05-08T00:00:00", ShiftGroup: "C2"}, RuleActionClaim, "matched claim rule"},
+		{"legacy shift group", Shift{Date: "2024-05-06T00:00:00", ShiftGroup: "B"}, RuleActionClaim, "shift group B in A,B"},
+		{"null shift group", Shift{Date: "2024-05-06T00:00:00"}, RuleActionIgnore, "shift has no shift group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, reason := evaluateShift(tt.shift, settings)
+			assert.Equal(t, tt.wantAction, action)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestEvaluateShiftGroupList(t *testing.T) {
+	settings := &claimSettings{shiftStartDate: "2024-05-06", shiftGroup: "C1, b"}
+	tests := []struct {
+		group      string
+		wantAction string
+	}{
+		{"C1", RuleActionClaim},
+		{"c1", RuleActionClaim},
+		{"B", RuleActionClaim},
+		// Part of a listed group, but not one of them
+		{"C", RuleActionIgnore},
+		{"1", RuleActionIgnore},
+		{"C2", RuleActionIgnore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			action, _ := evaluateShift(Shift{Date: "2024-05-06T00:00:00", ShiftGroup: tt.group}, settings)
+			assert.Equal(t, tt.wantAction, action)
+		})
+	}
+}
+
+func TestRulesFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    []FilterRule
+		wantErr string
+	}{
+		{name: "absent", value: nil, want: nil},
+		{
+			name: "full rule",
+			value: []interface{}{map[string]interface{}{
+				"name":     "weekends",
+				"action":   "notify",
+				"stations": []interface{}{" North "},
+				"groups":   []interface{}{"C1"},
+				"weekdays": []interface{}{"sat", "Sunday"},
+			}},
+			want: []FilterRule{{
+				Name:     "weekends",
+				Action:   RuleActionNotify,
+				Stations: []string{"North"},
+				Groups:   []string{"C1"},
+				Weekdays: []time.Weekday{time.Saturday, time.Sunday},
+			}},
+		},
+		{name: "not a list", value: "claim", wantErr: "expected a list of rules"},
+		{name: "not a map", value: []interface{}{"claim"}, wantErr: "rule 0 is not a map"},
+		{name: "unknown action", value: []interface{}{map[string]interface{}{"action": "grab"}}, wantErr: `rule 0 has unknown action "grab"`},
+		{name: "unknown weekday", value: []interface{}{map[string]interface{}{"action": "claim", "weekdays": []interface{}{"someday"}}}, wantErr: `rule 0 has unknown weekday "someday"`},
+		{name: "invalid stations", value: []interface{}{map[string]interface{}{"action": "claim", "stations": []interface{}{1}}}, wantErr: "rule 0 has invalid stations: expected a list of strings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := rulesFromConfig(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rules)
+		})
+	}
+}
@@ -3,154 +3,359 @@ package shiftclaiming
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"cloud.google.com/go/firestore"
 	cloudtaskss "github.com/yesaswi/shift-claiming-automation/internal/cloudtasks"
+	"github.com/yesaswi/shift-claiming-automation/internal/events"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/notify"
+	"github.com/yesaswi/shift-claiming-automation/internal/portal"
+	"github.com/yesaswi/shift-claiming-automation/internal/tracing"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/iterator"
 )
 
 type Service struct {
+	config           *config.Config
 	firestoreClient  *firestore.Client
 	cloudTasksClient *cloudtasks.Client
+	notifyClient     *notify.Client
+	events           *events.Bus
+	configVersions   *firestores.VersionStore
+	// writes takes the bookkeeping of a poll off the claim path
+	writes *firestores.WriteBehind
+	// portalClient is shared by all portal requests so that connections to
+	// the portal are kept alive between polls
+	portalClient *portal.Client
+	// closed are the shifts not to be claimed again
+	closed closedShifts
+	// pacing is what the scheduler has learned about the portal
+	pacing pollPacing
+	// verifications check successful claims against the user's schedule
+	verifications *claimVerifications
+	// validateToken verifies the ID tokens requests are authenticated with
+	validateToken tokenValidator
+	// leases ensures a single poll runs at a time
+	leases lease.Locker
+	// instanceID names this instance as the holder of the poller lease
+	instanceID string
+	loop       pollLoop
+	// loopPolls is held by the poll loop while it polls, so that claims made
+	// under the loop's lease wait for the poll to finish
+	loopPolls sync.Mutex
 }
 
-func NewService(firestoreClient *firestore.Client, cloudTasksClient *cloudtasks.Client) *Service {
+func NewService(cfg *config.Config, firestoreClient *firestore.Client, cloudTasksClient *cloudtasks.Client, notifyClient *notify.Client, portalClient *portal.Client) *Service {
+	var leases lease.Locker = firestores.NewLocker(firestoreClient, "leases")
+	if cfg.LeaseStore == LeaseStoreMemory {
+		leases = lease.NewMemory()
+	}
 	return &Service{
+		config:           cfg,
 		firestoreClient:  firestoreClient,
 		cloudTasksClient: cloudTasksClient,
+		notifyClient:     notifyClient,
+		events:           events.NewBus(1000),
+		configVersions:   firestores.NewVersionStore(firestoreClient, "config_versions").Secret("configuration/auth", redactedAuthFields...),
+		writes:           firestores.NewWriteBehind(firestoreClient, leases, 1000, time.Second),
+		leases:           leases,
+		portalClient:     portalClient,
+		verifications:    newClaimVerifications(),
+		validateToken:    idtoken.Validate,
+		instanceID:       newInstanceID(),
 	}
 }
 
-func (s *Service) StartClaiming() error {
-	fmt.Println(`{"message": "Starting shift claiming...", "severity": "info"}`)
-	// Update the start/stop flag in Firestore
-	configDoc := s.firestoreClient.Collection("configuration").Doc("config")
-	_, err := configDoc.Set(context.Background(), map[string]interface{}{
+// storeContext bounds a store operation by the configured timeout.
+func (s *Service) storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.config.StoreTimeout)
+}
+
+// Close waits for the poll loop running in this instance to finish its
+// current poll, so that claims in flight complete and are recorded, and then
+// commits the writes still buffered by the service. The loop is cancelled if
+// ctx ends first. Claims still being verified are left pending for a later
+// poll to verify.
+func (s *Service) Close(ctx context.Context) error {
+	if done := s.drainLoop(); done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			s.stopLoop()
+			s.verifications.stop()
+			return ctx.Err()
+		}
+	}
+	s.verifications.stop()
+	return s.writes.Close(ctx)
+}
+
+// CloseStreams ends the open event streams. Server shutdown does not cancel
+// their requests, so it would otherwise wait on them until its deadline.
+func (s *Service) CloseStreams() {
+	s.events.Close()
+}
+
+// StartClaiming enables claiming. In dry-run mode polls plan their claims and
+// log the plan without claiming anything.
+func (s *Service) StartClaiming(ctx context.Context, origin Origin, dryRun bool) (err error) {
+	fmt.Printf(`{"message": "Starting shift claiming...", "dry_run": %t, "severity": "info"}`+"\n", dryRun)
+	// Update the start/stop and dry-run flags in Firestore
+	version, err := s.writeConfig(ctx, "config", origin.Actor, map[string]interface{}{
 		"startStopFlag": true,
+		"dryRun":        dryRun,
 	})
+	defer func() { s.auditVersion(ctx, origin, AuditStart, "config", version, err) }()
 	if err != nil {
 		return fmt.Errorf("failed to update start/stop flag: %v", err)
 	}
 
-	// Schedule the initial claim task
-	err = s.ScheduleClaimTask(time.Now())
+	now := time.Now()
+	if s.config.PollerMode == PollerModeLoop {
+		// The loop polls straight away and waits for the active windows itself
+		s.recordPoll(ctx, pollRecord{Result: PollStarted, NextPollAt: now})
+		if err = s.startLoop(ctx, origin); err != nil {
+			return err
+		}
+		s.events.Publish(EventClaimingStarted, map[string]interface{}{"next_poll_at": now})
+		return nil
+	}
+
+	// Schedule the initial claim task, at the start of the next active window
+	// when outside of one
+	schedule, err := s.loadActiveSchedule(ctx)
+	if err != nil {
+		return err
+	}
+	next, err := s.scheduleNextPoll(ctx, schedule, now)
 	if err != nil {
 		return fmt.Errorf("failed to schedule initial claim task: %v", err)
 	}
+	s.recordPoll(ctx, pollRecord{Result: PollStarted, NextPollAt: next, Paused: next.After(now)})
+	s.events.Publish(EventClaimingStarted, map[string]interface{}{"next_poll_at": next})
 
 	return nil
 }
 
-func (s *Service) StopClaiming() error {
+func (s *Service) StopClaiming(ctx context.Context, origin Origin) (err error) {
 	fmt.Println(`{"message": "Stopping shift claiming...", "severity": "info"}`)
 	// Update the start/stop flag in Firestore
-	configDoc := s.firestoreClient.Collection("configuration").Doc("config")
-	_, err := configDoc.Set(context.Background(), map[string]interface{}{
+	version, err := s.writeConfig(ctx, "config", origin.Actor, map[string]interface{}{
 		"startStopFlag": false,
 	})
+	defer func() { s.auditVersion(ctx, origin, AuditStop, "config", version, err) }()
 	if err != nil {
 		return fmt.Errorf("failed to update start/stop flag: %v", err)
 	}
 
 	// Delete all pending tasks from the queue and stop claiming
-	err = cloudtaskss.DeleteAllTasks(s.cloudTasksClient, "autoclaimer-42", "us-east4", "barbequeue")
+	tasksCtx, cancel := context.WithTimeout(ctx, s.config.TasksTimeout)
+	defer cancel()
+	err = cloudtaskss.DeleteAllTasks(tasksCtx, s.cloudTasksClient, "autoclaimer-42", "us-east4", "barbequeue")
 	if err != nil {
 		return fmt.Errorf("failed to delete pending tasks: %v", err)
 	}
+	// Revoking the lease stops the poll loop of whichever instance runs it.
+	// It goes first since ctx may be the loop's own.
+	storeCtx, cancel := s.storeContext(ctx)
+	defer cancel()
+	if err = s.leases.Revoke(storeCtx, pollerLease); err != nil {
+		return fmt.Errorf("failed to revoke poller lease: %v", err)
+	}
+	s.stopLoop()
+	metrics.SetPollerState(PollerStopped, pollerStates)
+	s.events.Publish(EventClaimingStopped, nil)
 
 	return nil
 }
 
-func (s *Service) ScheduleClaimTask(scheduleTime time.Time) error {
+func (s *Service) ScheduleClaimTask(ctx context.Context, scheduleTime time.Time) error {
 	// Schedule a new task to trigger the /claim endpoint
 	fmt.Printf(`{"message": "Scheduling claim task...", "schedule_time": "%s", "severity": "info"}`+"\n", scheduleTime)
-	_, err := cloudtaskss.CreateTask(s.cloudTasksClient, "autoclaimer-42", "us-east4", "barbequeue", "https://autoclaimer-h5km45tdpq-uk.a.run.app/claim", scheduleTime)
+	ctx, cancel := context.WithTimeout(ctx, s.config.TasksTimeout)
+	defer cancel()
+	_, err := cloudtaskss.CreateTask(ctx, s.cloudTasksClient, "autoclaimer-42", "us-east4", "barbequeue", s.config.ServiceURL+"/claim", s.config.TasksServiceAccount, s.config.AuthAudience, scheduleTime)
 	if err != nil {
 		return fmt.Errorf("failed to schedule claim task: %v", err)
 	}
+	if err := s.writes.Set(s.firestoreClient.Collection("usage_counters").Doc(s.usageDay(time.Now())), map[string]interface{}{
+		"tasksCreated": firestore.Increment(1),
+	}, firestore.MergeAll); err != nil {
+		fmt.Printf(`{"message": "Failed to count task", "error": "%v", "severity": "warning"}`+"\n", err)
+	}
 	return nil
 }
 
-func (s *Service) ClaimShift() error {
+// loadActiveSchedule reads the active windows from the configuration document.
+func (s *Service) loadActiveSchedule(ctx context.Context) (*activeSchedule, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	configDocSnap, err := s.firestoreClient.Collection("configuration").Doc("config").Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve configuration: %v", err)
+	}
+	return newActiveSchedule(configDocSnap.Data(), s.config.TimeZone)
+}
+
+// scheduleNextPoll creates the claim task for the next poll after the given
+// time. It returns the time of that poll, or the zero time when no window is
+// coming up.
+func (s *Service) scheduleNextPoll(ctx context.Context, schedule *activeSchedule, at time.Time) (time.Time, error) {
+	next := s.nextPoll(schedule, at)
+	if next.IsZero() {
+		return next, nil
+	}
+	return next, s.ScheduleClaimTask(ctx, next)
+}
+
+// nextPoll returns when the next poll is due: at the given time, or at the
+// start of the next active window if that time falls outside of every window.
+// It returns the zero time when no window is coming up.
+func (s *Service) nextPoll(schedule *activeSchedule, at time.Time) time.Time {
+	next := schedule.next(at)
+	if next.IsZero() {
+		fmt.Println(`{"message": "No upcoming active window, polling stays paused", "severity": "warning"}`)
+		return next
+	}
+	if next.After(at) {
+		fmt.Printf(`{"message": "Polling paused until next active window", "paused_until": "%s", "severity": "info"}`+"\n", next)
+		// Shifts are posted as a window opens, so the first poll should not
+		// pay for new connections. Idle instances keep the planned warm-up.
+		if s.config.PrewarmLead > 0 {
+			s.portalClient.WarmAt(next.Add(-s.config.PrewarmLead))
+		}
+	}
+	return next
+}
+
+// ClaimShift handles a claim task. In the default mode the task is a poll of
+// the swapboard, which queues the task for the next poll once it has given up
+// the lease, so that the next task cannot find the lease still held. In loop
+// mode it is a heartbeat that revives the poll loop when no instance holds
+// the lease.
+func (s *Service) ClaimShift(ctx context.Context, origin Origin) error {
+	if s.config.PollerMode == PollerModeLoop {
+		return s.heartbeat(ctx, origin)
+	}
+
+	// Concurrent deliveries of claim tasks must not poll the portal twice
+	held, err := s.leases.Acquire(ctx, pollerLease, s.instanceID+"/"+origin.RequestID, s.config.LeaseTTL)
+	if errors.Is(err, lease.ErrHeld) {
+		fmt.Printf(`{"message": "Another poll is running, skipping", "error": "%v", "severity": "warning"}`+"\n", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire poller lease: %v", err)
+	}
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	go s.renewLease(renewCtx, held, func() {
+		fmt.Println(`{"message": "Poller lease lost during poll", "severity": "notice"}`)
+	})
+	next, err := s.runPoll(withPollLease(ctx, held), origin)
+	stopRenewing()
+
+	// The lease is given up and the next task queued even when the request
+	// was cancelled
+	ctx = context.WithoutCancel(ctx)
+	releaseCtx, cancel := s.storeContext(ctx)
+	defer cancel()
+	if err := s.leases.Release(releaseCtx, held); err != nil {
+		fmt.Printf(`{"message": "Failed to release poller lease", "error": "%v", "severity": "warning"}`+"\n", err)
+	}
+	if !next.IsZero() {
+		if err := s.ScheduleClaimTask(ctx, next); err != nil {
+			return fmt.Errorf("failed to schedule next claim task: %v", err)
+		}
+	}
+	return err
+}
+
+// runPoll runs one poll of the swapboard and returns when the next poll is
+// due, or the zero time when polling should stop. The poll is traced as a
+// span under ctx, which continues the trace of the poll that scheduled it.
+func (s *Service) runPoll(ctx context.Context, origin Origin) (next time.Time, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "poll", trace.WithAttributes(
+		attribute.String("origin.source", origin.Source),
+		attribute.String("origin.request_id", origin.RequestID),
+	))
+	defer func() { tracing.End(span, err) }()
+	// Commit the poll's buffered writes before the request returns, as Cloud
+	// Run may throttle the instance once it has
+	defer func() {
+		if err := s.writes.Flush(ctx); err != nil {
+			fmt.Printf(`{"message": "Failed to flush buffered writes", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+	}()
 	fmt.Println(`{"message": "Claiming shift...", "severity": "info"}`)
 
 	// Check if claiming is enabled
 	configDoc := s.firestoreClient.Collection("configuration").Doc("config")
-	configDocSnap, err := configDoc.Get(context.Background())
+	storeCtx, cancel := s.storeContext(ctx)
+	configDocSnap, err := configDoc.Get(storeCtx)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve configuration: %v", err)
+		return next, fmt.Errorf("failed to retrieve configuration: %v", err)
 	}
 	configData := configDocSnap.Data()
 	startStopFlag, ok := configData["startStopFlag"].(bool)
 	if !ok {
-		return fmt.Errorf("missing or invalid 'startStopFlag' in configuration")
+		return next, fmt.Errorf("missing or invalid 'startStopFlag' in configuration")
 	}
 	if !startStopFlag {
 		fmt.Println(`{"message": "Claiming is disabled", "severity": "warning"}`)
-		return nil
+		s.recordPoll(ctx, pollRecord{Result: PollDisabled})
+		return next, nil
 	}
 
-	// Retrieve the claiming configuration from Firestore
-	authConfigDoc := s.firestoreClient.Collection("configuration").Doc("auth")
-	authConfigDocSnap, err := authConfigDoc.Get(context.Background())
+	// Stop once every campaign has met its target or passed its deadline
+	stopped, err := s.finishCampaigns(ctx, time.Now(), origin)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve claiming configuration: %v", err)
+		return next, fmt.Errorf("failed to check campaigns: %v", err)
 	}
-	var authConfig map[string]interface{}
-	err = authConfigDocSnap.DataTo(&authConfig)
-	if err != nil {
-		return fmt.Errorf("failed to parse auth configuration: %v", err)
+	if stopped {
+		return next, nil
 	}
 
-	// Retrieve the claiming configuration from Firestore
-	shiftConfigDoc := s.firestoreClient.Collection("configuration").Doc("shiftconfig")
-	shiftConfigDocSnap, err := shiftConfigDoc.Get(context.Background())
+	// Outside of the active windows, the next poll is at the next window start
+	schedule, err := newActiveSchedule(configData, s.config.TimeZone)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve claiming configuration: %v", err)
+		return next, err
 	}
-	var shiftConfig map[string]interface{}
-	err = shiftConfigDocSnap.DataTo(&shiftConfig)
-	if err != nil {
-		return fmt.Errorf("failed to parse claiming configuration: %v", err)
+	if !schedule.active(time.Now()) {
+		fmt.Println(`{"message": "Outside of active windows, pausing polling", "severity": "info"}`)
+		next = s.nextPoll(schedule, time.Now())
+		s.recordPoll(ctx, pollRecord{Result: PollPaused, NextPollAt: next, Paused: true})
+		return next, nil
 	}
 
-	// Extract the necessary configuration values
-	cookie, ok := authConfig["cookie"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'cookie' in claiming configuration")
-	}
-	xAPIToken, ok := authConfig["x_api_token"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'x_api_token' in claiming configuration")
-	}
-
-	shiftStartDate, ok := shiftConfig["shift_start_date"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'shift_start_date' in claiming configuration")
-	}
-	shiftRange, ok := shiftConfig["shift_range"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'shift_range' in claiming configuration")
-	}
-	// A, B, C1, C2
-	shiftGroup, ok := shiftConfig["shift_group"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'shift_group' in claiming configuration")
+	settings, err := s.loadClaimSettings(ctx)
+	if err != nil {
+		return next, err
 	}
+	// In dry-run mode the poller keeps polling but only logs what it would claim
+	dryRun, _ := configData["dryRun"].(bool)
 
-	userID, ok := authConfig["user_id"].(string)
-	if !ok {
-		return fmt.Errorf("missing or invalid 'user_id' in claiming configuration")
-	}
+	// The budget is loaded before the swapboard is read, so that no store
+	// round trip sits between the board and the first claim
+	budget, budgetErr := s.newClaimBudget(ctx, settings)
 
 	// Fetch available shifts
-	availableShifts, err := fetchAvailableShifts(cookie, xAPIToken, shiftStartDate, shiftRange)
+	availableShifts, err := fetchAvailableShifts(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "Swap list disabled") ||
 			strings.HasPrefix(err.Error(), "Please wait") ||
@@ -158,66 +363,109 @@ func (s *Service) ClaimShift() error {
 			fmt.Printf(`{"message": "Claiming is disabled", "error": "%s", "severity": "info"}`+"\n", err.Error())
 
 			if strings.HasPrefix(err.Error(), "Swap list disabled") {
-				// Schedule the next claim task after 30 minutes
-				err = s.ScheduleClaimTask(time.Now().Add(30 * time.Minute))
-				if err != nil {
-					return fmt.Errorf("failed to schedule next claim task: %v", err)
-				}
+				// Poll again once the idle time asked for has passed
+				interval := s.hintedInterval(err.Error())
+				next = s.nextPoll(schedule, time.Now().Add(interval.Interval))
+				s.recordPoll(ctx, pollRecord{Result: PollSwapListDisabled, NextPollAt: next, Cooldown: true, Interval: interval})
 			} else if strings.HasPrefix(err.Error(), "Please wait") {
-				// Schedule the next claim task after 3 seconds
-				err = s.ScheduleClaimTask(time.Now().Add(3 * time.Second))
-				if err != nil {
-					return fmt.Errorf("failed to schedule next claim task: %v", err)
-				}
+				// Poll again once the refresh interval has passed
+				interval := s.hintedInterval(err.Error())
+				next = s.nextPoll(schedule, time.Now().Add(interval.Interval))
+				s.recordPoll(ctx, pollRecord{Result: PollPleaseWait, NextPollAt: next, Interval: interval})
 			} else if strings.HasPrefix(err.Error(), "Session Timeout") {
 				// Lof the error and stop claiming
 				fmt.Printf(`{"message": "Session Timeout. Please sign in again.", "severity": "alert"}` + "\n")
+				s.recordPoll(ctx, pollRecord{Result: PollSessionTimeout, CredentialsValid: boolPtr(false)})
 			}
 
-			return nil
+			return next, nil
 		}
-		return fmt.Errorf("failed to fetch available shifts: %v", err)
+		s.recordPoll(ctx, pollRecord{Result: PollError, Message: err.Error()})
+		return next, fmt.Errorf("failed to fetch available shifts: %v", err)
 	}
 
-	// Schedule the next claim task
-	err = s.ScheduleClaimTask(time.Now().Add(5 * time.Second))
+	// Claim before anything else is done with the board, since contested
+	// shifts go within a fraction of a second
+	var rankedShifts []ScoredShift
+	var claimingResults []ClaimingResult
+	if len(availableShifts) > 0 && budgetErr == nil {
+		if dryRun {
+			rankedShifts = rankShifts(availableShifts, settings.strategy)
+			plan := planClaims(rankedShifts, budget, settings)
+			planJSON, _ := json.Marshal(plan)
+			fmt.Printf(`{"message": "Dry run, no shifts claimed", "plan": %s, "severity": "info"}`+"\n", planJSON)
+		} else {
+			rankedShifts, claimingResults = claimBoard(ctx, s.portalClient, availableShifts, budget, settings)
+		}
+	}
+
+	// Choose the next poll. Claims run into the same limits as the
+	// swapboard: a rate-limited claim cools polling down like the swapboard
+	// would, and an expired session stops it until the user signs in again.
+	poll := pollRecord{Result: PollOK, CredentialsValid: boolPtr(true), ShiftsSeen: len(availableShifts)}
+	poll.Interval = s.nextInterval(ctx, time.Now())
+	if halting := haltingClaim(claimingResults); halting != nil {
+		if halting.Outcome == ClaimSessionExpired {
+			fmt.Printf(`{"message": "Session Timeout. Please sign in again.", "severity": "alert"}` + "\n")
+			poll.Result = PollSessionTimeout
+			poll.CredentialsValid = boolPtr(false)
+			poll.Interval = pollInterval{}
+		} else {
+			poll.Interval = s.hintedInterval(halting.Response)
+			poll.Result = PollPleaseWait
+			if poll.Interval.Reason == IntervalPortalCooldown {
+				poll.Result = PollSwapListDisabled
+				poll.Cooldown = true
+			}
+		}
+	}
+	if poll.Interval.Interval > 0 {
+		pollAt := time.Now().Add(poll.Interval.Interval)
+		next = s.nextPoll(schedule, pollAt)
+		poll.NextPollAt = next
+		poll.Paused = next.After(pollAt)
+	}
+	poll.Board, err = s.trackBoard(ctx, availableShifts, time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to schedule next claim task: %v", err)
+		fmt.Printf(`{"message": "Failed to track swapboard", "error": "%v", "severity": "warning"}`+"\n", err)
+	}
+	s.recordPoll(ctx, poll)
+	s.events.Publish(EventShiftsSeen, map[string]interface{}{"count": len(availableShifts), "shifts": availableShifts})
+	if !dryRun {
+		if err := s.sweepVerifications(ctx, settings); err != nil {
+			fmt.Printf(`{"message": "Failed to verify pending claims", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
 	}
 
 	if len(availableShifts) == 0 {
 		fmt.Println(`{"message": "No available shifts to claim", "severity": "info"}`)
 		// To a new random documentID in the requests collection
-		s.firestoreClient.Collection("requests").NewDoc().Set(context.Background(), map[string]interface{}{
+		s.write(ctx, s.firestoreClient.Collection("requests").NewDoc(), map[string]interface{}{
 			"timestamp": time.Now(),
 			"message":   "No available shifts to claim",
 		})
-		return nil
+		return next, nil
 	}
-
-	for _, shift := range availableShifts {
-		s.firestoreClient.Collection("available_shifts").NewDoc().Set(context.Background(), map[string]interface{}{
-			"timestamp":  time.Now(),
-			"id":         shift.Id,
-			"schId":      shift.SchId,
-			"locId":      shift.LocId,
-			"stnName":    shift.StnName,
-			"date":       shift.Date,
-			"hours":      shift.Hours,
-			"shiftGroup": shift.ShiftGroup,
-			"start":      shift.Start,
-			"end":        shift.End,
-		})
+	if budgetErr != nil {
+		return next, budgetErr
+	}
+	if dryRun {
+		return next, nil
 	}
 
-	// Claim the shifts
-	claimingResults := claimShifts(availableShifts, cookie, xAPIToken, userID, shiftStartDate, shiftGroup)
-	for _, result := range claimingResults {
-		s.firestoreClient.Collection("claims").NewDoc().Set(context.Background(), map[string]interface{}{
-			"timestamp":      result.Timestamp,
-			"shiftId":        result.ShiftID,
-			"claimingStatus": result.ClaimingStatus,
-		})
+	s.notifyShifts(ctx, rankedShifts, settings)
+	// Campaigns are checked against the progress recorded here
+	if err := s.recordClaims(ctx, claimingResults); err != nil {
+		return next, fmt.Errorf("failed to record claims: %v", err)
+	}
+	s.verifyClaims(claimingResults, settings)
+	stopped, err = s.finishCampaigns(ctx, time.Now(), origin)
+	if err != nil {
+		return next, fmt.Errorf("failed to check campaigns: %v", err)
+	}
+	if stopped {
+		// No task is queued for the next poll, since claiming stopped
+		next = time.Time{}
 	}
 	if len(claimingResults) == 0 {
 		fmt.Println(`{"message": "No shifts claimed", "severity": "alert"}`)
@@ -225,12 +473,146 @@ func (s *Service) ClaimShift() error {
 		fmt.Printf(`{"message": "Some shifts failed to claim", "claiming_results": %v, "severity": "alert"}`+"\n", claimingResults)
 	}
 
+	return next, nil
+}
+
+// claimSettings holds the credentials and shift preferences a poll works with.
+type claimSettings struct {
+	cookie         string
+	xAPIToken      string
+	userID         string
+	shiftStartDate string
+	shiftRange     string
+	shiftGroup     string
+	strategy       Strategy
+	maxWeeklyHours float64
+	rules          []FilterRule
+	// claimParallelism is the number of claims sent at once
+	claimParallelism int
+	// closedShifts are the postings whose claims failed permanently, by
+	// shiftKey
+	closedShifts map[string]string
+}
+
+// closedReason returns why the shift's posting is not claimed again, or an
+// empty string when it can be claimed.
+func (settings *claimSettings) closedReason(shift Shift) string {
+	if outcome, ok := settings.closedShifts[shiftKey(shift)]; ok {
+		return fmt.Sprintf("previous claim failed as %s", outcome)
+	}
+	return ""
+}
+
+// loadClaimSettings reads the auth and shift configuration documents.
+func (s *Service) loadClaimSettings(ctx context.Context) (*claimSettings, error) {
+	// Retrieve the claiming configuration from Firestore
+	shiftConfig, err := s.loadShiftConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := shiftSettingsFromConfig(shiftConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadCredentials(ctx, settings); err != nil {
+		return nil, err
+	}
+	settings.claimParallelism = s.config.ClaimParallelism
+	settings.closedShifts = s.closedShiftSet(ctx)
+	return settings, nil
+}
+
+// loadCredentials reads the portal credentials from the auth configuration
+// document into the settings.
+func (s *Service) loadCredentials(ctx context.Context, settings *claimSettings) error {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	authConfigDoc := s.firestoreClient.Collection("configuration").Doc("auth")
+	authConfigDocSnap, err := authConfigDoc.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve claiming configuration: %v", err)
+	}
+	var authConfig map[string]interface{}
+	err = authConfigDocSnap.DataTo(&authConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse auth configuration: %v", err)
+	}
+
+	// Extract the necessary configuration values
+	cookie, ok := authConfig["cookie"].(string)
+	if !ok {
+		return fmt.Errorf("missing or invalid 'cookie' in claiming configuration")
+	}
+	xAPIToken, ok := authConfig["x_api_token"].(string)
+	if !ok {
+		return fmt.Errorf("missing or invalid 'x_api_token' in claiming configuration")
+	}
+	userID, ok := authConfig["user_id"].(string)
+	if !ok {
+		return fmt.Errorf("missing or invalid 'user_id' in claiming configuration")
+	}
+	settings.cookie = cookie
+	settings.xAPIToken = xAPIToken
+	settings.userID = userID
 	return nil
 }
 
-func fetchAvailableShifts(cookie, xAPIToken, shiftStartDate, shiftRange string) ([]Shift, error) {
-	shiftListingURL := "https://tmwork.net/api/shift/swapboard"
-	req, err := http.NewRequest("GET", shiftListingURL, nil)
+// loadShiftConfig reads the shift configuration document.
+func (s *Service) loadShiftConfig(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	shiftConfigDoc := s.firestoreClient.Collection("configuration").Doc("shiftconfig")
+	shiftConfigDocSnap, err := shiftConfigDoc.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve claiming configuration: %v", err)
+	}
+	var shiftConfig map[string]interface{}
+	err = shiftConfigDocSnap.DataTo(&shiftConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse claiming configuration: %v", err)
+	}
+	return shiftConfig, nil
+}
+
+// shiftSettingsFromConfig extracts the shift preferences from the shift
+// configuration document.
+func shiftSettingsFromConfig(shiftConfig map[string]interface{}) (*claimSettings, error) {
+	shiftStartDate, ok := shiftConfig["shift_start_date"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'shift_start_date' in claiming configuration")
+	}
+	shiftRange, ok := shiftConfig["shift_range"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'shift_range' in claiming configuration")
+	}
+	// A, B, C1, C2
+	shiftGroup, ok := shiftConfig["shift_group"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid 'shift_group' in claiming configuration")
+	}
+
+	strategy, err := newStrategy(shiftConfig)
+	if err != nil {
+		return nil, err
+	}
+	maxWeeklyHours, _ := toFloat(shiftConfig["max_weekly_hours"])
+	rules, err := rulesFromConfig(shiftConfig["rules"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'rules' in claiming configuration: %v", err)
+	}
+
+	return &claimSettings{
+		shiftStartDate: shiftStartDate,
+		shiftRange:     shiftRange,
+		shiftGroup:     shiftGroup,
+		strategy:       strategy,
+		maxWeeklyHours: maxWeeklyHours,
+		rules:          rules,
+	}, nil
+}
+
+func fetchAvailableShifts(ctx context.Context, client *portal.Client, cookie, xAPIToken, shiftStartDate, shiftRange string) ([]Shift, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", portal.BaseURL+portal.SwapboardPath, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -240,8 +622,7 @@ func fetchAvailableShifts(cookie, xAPIToken, shiftStartDate, shiftRange string)
 	q.Add("date", shiftStartDate)
 	q.Add("range", shiftRange)
 	req.URL.RawQuery = q.Encode()
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.Do(portal.EndpointSwapboard, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch shift listings: %v", err)
 	}
@@ -257,9 +638,9 @@ func fetchAvailableShifts(cookie, xAPIToken, shiftStartDate, shiftRange string)
 			return nil, fmt.Errorf("failed to read response body: %v", err)
 		}
 		bodyString := string(bodyBytes)
-		if strings.Contains(bodyString, "Swap list disabled.  (30) minutes idle required for reset.") {
+		if strings.Contains(bodyString, "Swap list disabled.") {
 			return nil, fmt.Errorf("%s", bodyString)
-		} else if strings.Contains(bodyString, "Please wait [3] seconds to refresh list.") {
+		} else if pleaseWaitPattern.MatchString(bodyString) {
 			return nil, fmt.Errorf("%s", bodyString)
 		} else if strings.Contains(bodyString, "Session Timeout. Please sign in again.") {
 			return nil, fmt.Errorf("%s", bodyString)
@@ -267,100 +648,291 @@ func fetchAvailableShifts(cookie, xAPIToken, shiftStartDate, shiftRange string)
 		return nil, fmt.Errorf("failed to fetch shift listings: %s", bodyString)
 	}
 
-	var responseData []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
-		return nil, fmt.Errorf("failed to parse shift listings: %v", err)
-	}
-	var availableShifts []Shift
-	for _, shiftData := range responseData {
-		shift := Shift{
-			Id:         int(shiftData["Id"].(float64)),
-			SchId:      int(shiftData["SchId"].(float64)),
-			LocId:      int(shiftData["LocId"].(float64)),
-			StnName:    shiftData["StnName"].(string),
-			Date:       shiftData["Date"].(string),
-			Hours:      shiftData["Hours"].(float64),
-			ShiftGroup: shiftData["ShiftGroup"].(string),
-			Start:      shiftData["Start"].(string),
-			End:        shiftData["End"].(string),
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shift listings: %v", err)
+	}
+	availableShifts, recordErrors, err := decodeSwapboard(body)
+	if err != nil {
+		var decodeErr *SwapboardDecodeError
+		if errors.As(err, &decodeErr) {
+			bodyJSON, _ := json.Marshal(decodeErr.Body)
+			fmt.Printf(`{"message": "Failed to decode swapboard", "error": "%v", "body": %s, "severity": "error"}`+"\n", decodeErr.Err, bodyJSON)
 		}
-		availableShifts = append(availableShifts, shift)
+		return nil, err
+	}
+	if len(recordErrors) > 0 {
+		metrics.SwapboardRecordErrorsTotal.Add(float64(len(recordErrors)))
+		recordErrorsJSON, _ := json.Marshal(recordErrors)
+		fmt.Printf(`{"message": "Skipped swapboard records that could not be decoded", "record_errors": %s, "severity": "warning"}`+"\n", recordErrorsJSON)
 	}
 	return availableShifts, nil
 }
 
-func claimShifts(shifts []Shift, cookie, xAPIToken string, userID string, shiftStartDate string, shiftGroup string) []ClaimingResult {
-	var claimingResults []ClaimingResult
-	claimingURL := "https://tmwork.net/api/shift/swap/claim"
-	req, err := http.NewRequest("PUT", claimingURL, nil)
+// recordClaims stores the outcome of every claim attempt and notes the record
+// of each in its result. Successful claims are recorded as pending
+// verification against the user's schedule.
+//
+// The records and the campaign progress are written in one transaction rather
+// than behind the claim path, since budgets and campaigns are checked against
+// them. Should it fail, they are queued to be written behind until they
+// commit, and the error is returned.
+//
+// The records are not fenced with the poller lease: the claims were sent, so
+// their outcomes are kept even when the lease was lost meanwhile, as when
+// claiming is stopped mid-poll. They cannot overwrite a later poll's results,
+// since every claim gets a new document and campaign progress is added to.
+func (s *Service) recordClaims(ctx context.Context, claimingResults []ClaimingResult) error {
+	var writes []claimWrite
+	for i := range claimingResults {
+		doc := s.firestoreClient.Collection("claims").NewDoc()
+		claimingResults[i].ClaimID = doc.ID
+		result := claimingResults[i]
+		record := map[string]interface{}{
+			"timestamp":      result.Timestamp,
+			"shiftId":        result.ShiftID,
+			"claimingStatus": result.ClaimingStatus,
+			"outcome":        result.Outcome,
+			"response":       result.Response,
+			"latencySeconds": result.LatencySeconds,
+			"strategy":       result.Strategy,
+			"score":          result.Score,
+			"hours":          result.Hours,
+			"week":           result.Week,
+			"campaign":       result.Campaign,
+		}
+		if result.Outcome == ClaimClaimed {
+			record["verification"] = VerificationPending
+		}
+		writes = append(writes, claimWrite{doc: doc, data: record})
+	}
+	writes = append(writes, s.campaignProgress(claimingResults)...)
+
+	storeCtx, cancel := s.storeContext(ctx)
+	err := s.firestoreClient.RunTransaction(storeCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+		for _, write := range writes {
+			if err := tx.Set(write.doc, write.data, write.opts...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	cancel()
 	if err != nil {
-		fmt.Printf(`{"message": "Failed to create claiming request", "error": "%v", "severity": "error"}`+"\n", err)
-		return claimingResults
+		for _, write := range writes {
+			if err := s.writes.Set(write.doc, write.data, write.opts...); err != nil {
+				fmt.Printf(`{"message": "Failed to queue claim record", "doc": "%s", "error": "%v", "severity": "error"}`+"\n", write.doc.Path, err)
+			}
+		}
 	}
-	client := &http.Client{}
-	q := req.URL.Query()
 
-	q.Add("bid", "3557")
-	req.Header.Set("Cookie", cookie)
-	req.Header.Set("X-API-Token", xAPIToken)
-	for _, shift := range shifts {
-		q.Add("id", strconv.Itoa(shift.Id))
-		shiftDate, err := time.Parse("2006-01-02T15:04:05", shift.Date)
+	for _, result := range claimingResults {
+		metrics.ClaimsTotal.WithLabelValues(result.Outcome).Inc()
+		s.events.Publish(EventClaim, result)
+		if permanentOutcome(result.Outcome) {
+			s.closeShift(result)
+		}
+	}
+	return err
+}
+
+// claimWrite is a write of a claim's record or of the progress it made.
+type claimWrite struct {
+	doc  *firestore.DocumentRef
+	data interface{}
+	opts []firestore.SetOption
+}
+
+// claimedWeeklyHours sums the hours of successful claims for every week from
+// the given one on, leaving out claims the schedule showed did not take. Weeks
+// sort by their names, and claims are filtered by status here so that the
+// query needs no composite index.
+func (s *Service) claimedWeeklyHours(ctx context.Context, fromWeek string) (map[string]float64, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	weeklyHours := map[string]float64{}
+	iter := s.firestoreClient.Collection("claims").
+		Where("week", ">=", fromWeek).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
 		if err != nil {
-			fmt.Printf(`{"message": "Failed to parse shift date", "error": "%v", "severity": "warning"}`+"\n", err)
+			return nil, err
+		}
+		if doc.Data()["claimingStatus"] != "success" || doc.Data()["verification"] == VerificationUnverified {
 			continue
 		}
-		compareDate, err := time.Parse("2006-01-02", shiftStartDate)
+		week, _ := doc.Data()["week"].(string)
+		hours, _ := toFloat(doc.Data()["hours"])
+		weeklyHours[week] += hours
+	}
+	return weeklyHours, nil
+}
+
+// newClaimBudget loads the active campaigns and, when a weekly cap is
+// configured, the hours already claimed for the weeks the swapboard can show.
+// It is called before the swapboard is read, so that no store round trip
+// sits between the board and the first claim.
+func (s *Service) newClaimBudget(ctx context.Context, settings *claimSettings) (*claimBudget, error) {
+	var weeklyHours map[string]float64
+	if settings.maxWeeklyHours > 0 {
+		// The board starts at the shift start date
+		var fromWeek string
+		if startDate, err := time.Parse("2006-01-02", settings.shiftStartDate); err == nil {
+			fromWeek = dateWeek(startDate)
+		}
+		var err error
+		weeklyHours, err = s.claimedWeeklyHours(ctx, fromWeek)
 		if err != nil {
-			fmt.Printf(`{"message": "Failed to parse shift start date", "error": "%v", "severity": "warning"}`+"\n", err)
+			return nil, fmt.Errorf("failed to retrieve claimed hours: %v", err)
+		}
+	}
+	campaigns, err := s.activeCampaigns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newClaimBudget(settings.maxWeeklyHours, weeklyHours, campaigns), nil
+}
+
+// claimBoard ranks the shifts on the board and claims those the settings
+// select, highest score first.
+func claimBoard(ctx context.Context, client *portal.Client, shifts []Shift, budget *claimBudget, settings *claimSettings) ([]ScoredShift, []ClaimingResult) {
+	rankedShifts := rankShifts(shifts, settings.strategy)
+	return rankedShifts, claimShifts(ctx, client, rankedShifts, budget, settings)
+}
+
+// claimShifts claims the shifts matched by a claim rule, skipping those that
+// no longer fit the budget. Up to claimParallelism claims are sent at once,
+// dispatched in the given order so that a shift only loses its share of the
+// budget to the shifts before it. Results are returned in the given order.
+// Shifts whose claims failed permanently before are skipped, and no further
+// claims are sent once one is rate-limited or finds the session expired.
+func claimShifts(ctx context.Context, client *portal.Client, shifts []ScoredShift, budget *claimBudget, settings *claimSettings) []ClaimingResult {
+	results := make([]*ClaimingResult, len(shifts))
+	slots := make(chan struct{}, max(settings.claimParallelism, 1))
+	var halted atomic.Bool
+	var wg sync.WaitGroup
+	for i, shift := range shifts {
+		if ctx.Err() != nil || halted.Load() {
+			break
+		}
+		if action, _ := evaluateShift(shift.Shift, settings); action != RuleActionClaim {
 			continue
 		}
-		if shiftDate.Before(compareDate) || !strings.Contains(shiftGroup, shift.ShiftGroup) {
+		if reason := settings.closedReason(shift.Shift); reason != "" {
+			fmt.Printf(`{"message": "Skipping shift", "shift_id": %d, "score": %g, "reason": "%s", "severity": "info"}`+"\n", shift.SchId, shift.Score, reason)
 			continue
 		}
-		q.Add("schid", fmt.Sprintf("%d", shift.SchId))
-		req.URL.RawQuery = q.Encode()
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf(`{"message": "Failed to claim shift", "error": "%v", "severity": "error"}`+"\n", err)
+		campaign, reason := budget.hold(shift.Shift)
+		if reason != "" {
+			fmt.Printf(`{"message": "Skipping shift", "shift_id": %d, "score": %g, "reason": "%s", "severity": "info"}`+"\n", shift.SchId, shift.Score, reason)
 			continue
 		}
-		defer func(Body io.ReadCloser) {
-			if Body != nil {
-				err := Body.Close()
-				if err != nil {
-					fmt.Printf(`{"message": "Failed to close response body", "error": "%v", "severity": "warning"}`+"\n", err)
-				}
+		slots <- struct{}{}
+		if halted.Load() {
+			<-slots
+			budget.settle(shift.Shift, campaign, false)
+			break
+		}
+		wg.Add(1)
+		go func(i int, shift ScoredShift) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := claimShift(ctx, client, shift, settings.strategy.Name(), settings)
+			claimed := err == nil && result.ClaimingStatus == "success"
+			budget.settle(shift.Shift, campaign, claimed)
+			if err != nil {
+				fmt.Printf(`{"message": "Failed to claim shift", "error": "%v", "severity": "error"}`+"\n", err)
+				return
 			}
-		}(resp.Body)
-		if resp.StatusCode != http.StatusOK {
-			if resp.Body != nil {
-				bodyBytes, err := io.ReadAll(resp.Body)
-				if err != nil {
-					fmt.Printf(`{"message": "Failed to read response body", "error": "%v", "severity": "error"}`+"\n", err)
-				} else {
-					bodyString := string(bodyBytes)
-					fmt.Printf(`{"message": "Failed to claim shift", "shift_id": %d, "status_code": %d, "response": "%s", "severity": "error"}`+"\n", shift.SchId, resp.StatusCode, bodyString)
-				}
+			if claimed {
+				result.Campaign = campaign
 			}
-			claimingResults = append(claimingResults, ClaimingResult{
-				ShiftID:        fmt.Sprintf("%d", shift.SchId),
-				ClaimingStatus: "failed",
-				Timestamp:      time.Now(),
-			})
-			continue
-		}
-		if resp.StatusCode == http.StatusOK {
-			claimingResults = append(claimingResults, ClaimingResult{
-				ShiftID:        fmt.Sprintf("%d", shift.SchId),
-				ClaimingStatus: "success",
-				Timestamp:      time.Now(),
-			})
+			if haltingOutcome(result.Outcome) {
+				halted.Store(true)
+			}
+			results[i] = &result
+		}(i, shift)
+	}
+	wg.Wait()
+
+	var claimingResults []ClaimingResult
+	for _, result := range results {
+		if result != nil {
+			claimingResults = append(claimingResults, *result)
 		}
 	}
 	return claimingResults
 }
 
+// claimShift sends the claim request for a single shift.
+func claimShift(ctx context.Context, client *portal.Client, shift ScoredShift, strategy string, settings *claimSettings) (result ClaimingResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "claimShift", trace.WithAttributes(
+		attribute.Int("shift.schid", shift.SchId),
+		attribute.Int("shift.id", shift.Id),
+		attribute.Float64("shift.score", shift.Score),
+	))
+	defer func() {
+		span.SetAttributes(attribute.String("claim.status", result.ClaimingStatus))
+		tracing.End(span, err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, "PUT", portal.BaseURL+portal.ClaimPath, nil)
+	if err != nil {
+		return ClaimingResult{}, fmt.Errorf("failed to create claiming request: %v", err)
+	}
+	q := req.URL.Query()
+	q.Add("bid", "3557")
+	q.Add("id", strconv.Itoa(shift.Id))
+	q.Add("schid", fmt.Sprintf("%d", shift.SchId))
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Cookie", settings.cookie)
+	req.Header.Set("X-API-Token", settings.xAPIToken)
+	start := time.Now()
+	resp, err := client.Do(portal.EndpointClaim, req)
+	if err != nil {
+		return ClaimingResult{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		if Body != nil {
+			err := Body.Close()
+			if err != nil {
+				fmt.Printf(`{"message": "Failed to close response body", "error": "%v", "severity": "warning"}`+"\n", err)
+			}
+		}
+	}(resp.Body)
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxClaimResponse))
+	if err != nil {
+		fmt.Printf(`{"message": "Failed to read response body", "error": "%v", "severity": "error"}`+"\n", err)
+	}
+	latency := time.Since(start)
+	body := strings.TrimSpace(string(bodyBytes))
+
+	result = ClaimingResult{
+		ShiftID:        fmt.Sprintf("%d", shift.SchId),
+		PostingKey:     shiftKey(shift.Shift),
+		ClaimingStatus: "success",
+		Outcome:        classifyClaim(resp.StatusCode, body),
+		Response:       truncate(body, maxClaimSnippet),
+		LatencySeconds: latency.Seconds(),
+		Timestamp:      time.Now(),
+		Strategy:       strategy,
+		Score:          shift.Score,
+		Hours:          shift.Hours,
+		Week:           shiftWeek(shift.Shift),
+	}
+	span.SetAttributes(attribute.String("claim.outcome", result.Outcome))
+	if result.Outcome != ClaimClaimed {
+		responseJSON, _ := json.Marshal(result.Response)
+		fmt.Printf(`{"message": "Failed to claim shift", "shift_id": %d, "status_code": %d, "outcome": "%s", "response": %s, "severity": "error"}`+"\n", shift.SchId, resp.StatusCode, result.Outcome, responseJSON)
+		result.ClaimingStatus = "failed"
+	}
+	return result, nil
+}
+
 type Shift struct {
 	Id         int     `json:"Id"`
 	SchId      int     `json:"SchId"`
@@ -374,7 +946,21 @@ type Shift struct {
 }
 
 type ClaimingResult struct {
-	ShiftID        string    `json:"shift_id"`
-	ClaimingStatus string    `json:"claiming_status"`
+	// ClaimID is the claim's record, once recorded.
+	ClaimID string `json:"claim_id,omitempty"`
+	ShiftID string `json:"shift_id"`
+	// PostingKey is the shiftKey of the posting that was claimed.
+	PostingKey     string `json:"posting_key"`
+	ClaimingStatus string `json:"claiming_status"`
+	// Outcome is what the portal's response says happened to the claim, and
+	// Response the start of that response.
+	Outcome        string    `json:"outcome"`
+	Response       string    `json:"response,omitempty"`
+	LatencySeconds float64   `json:"latency_seconds"`
 	Timestamp      time.Time `json:"timestamp"`
+	Strategy       string    `json:"strategy"`
+	Score          float64   `json:"score"`
+	Hours          float64   `json:"hours"`
+	Week           string    `json:"week"`
+	Campaign       string    `json:"campaign,omitempty"`
 }
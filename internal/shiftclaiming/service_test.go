@@ -0,0 +1,24 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordClaims(t *testing.T) {
+	s, _, store := newPollService(t, nil, 0)
+	results := []ClaimingResult{
+		{ShiftID: "1234", ClaimingStatus: "success", Outcome: ClaimClaimed, Hours: 8, Week: "2024-W19", Campaign: "c1", Timestamp: time.Now()},
+		{ShiftID: "5678", ClaimingStatus: "failure", Outcome: ClaimAlreadyTaken, Hours: 8, Week: "2024-W19", Timestamp: time.Now()},
+	}
+	require.NoError(t, s.recordClaims(context.Background(), results))
+
+	require.NotEmpty(t, results[0].ClaimID)
+	require.NotEmpty(t, results[1].ClaimID)
+	// Written with the claim records rather than behind them
+	assert.ElementsMatch(t, []string{"claims/" + results[0].ClaimID, "claims/" + results[1].ClaimID, "campaigns/c1"}, store.writtenDocs())
+}
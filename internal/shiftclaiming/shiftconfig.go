@@ -0,0 +1,243 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"google.golang.org/api/iterator"
+)
+
+var ErrInvalidShiftConfig = errors.New("invalid shift configuration")
+
+var (
+	// knownShiftRanges are the values the swapboard accepts for its range
+	// parameter.
+	knownShiftRanges = []string{"day", "week", "month"}
+	// knownShiftGroups are the groups the portal assigns; groups seen on the
+	// board are accepted as well.
+	knownShiftGroups = []string{"A", "B", "C1", "C2"}
+	knownStrategies  = []string{StrategyBoard, StrategySchID, StrategyWeighted}
+	knownRuleActions = []string{RuleActionClaim, RuleActionNotify, RuleActionIgnore}
+)
+
+// shiftConfigFields are the fields of the shift configuration document that
+// can be edited through the API.
+var shiftConfigFields = map[string]bool{
+	"shift_start_date":   true,
+	"shift_range":        true,
+	"shift_group":        true,
+	"strategy":           true,
+	"preferred_stations": true,
+	"preferred_groups":   true,
+	"hours_weight":       true,
+	"date_weight":        true,
+	"max_weekly_hours":   true,
+	"rules":              true,
+}
+
+// ShiftConfigOptions lists the values the shift configuration is validated
+// against, for building the editor form.
+type ShiftConfigOptions struct {
+	Ranges      []string `json:"ranges"`
+	Groups      []string `json:"groups"`
+	Stations    []string `json:"stations"`
+	Strategies  []string `json:"strategies"`
+	RuleActions []string `json:"rule_actions"`
+}
+
+// ShiftConfigView is the current shift configuration with the allowed values.
+type ShiftConfigView struct {
+	Config  map[string]interface{} `json:"config"`
+	Options *ShiftConfigOptions    `json:"options"`
+}
+
+// GetShiftConfig returns the shift configuration and the options for editing it.
+func (s *Service) GetShiftConfig(ctx context.Context) (*ShiftConfigView, error) {
+	shiftConfig, err := s.loadShiftConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	options, err := s.shiftConfigOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ShiftConfigView{Config: shiftConfig, Options: options}, nil
+}
+
+// UpdateShiftConfig validates and stores a new shift configuration. Editable
+// fields left out of it are removed; other fields of the document are kept.
+func (s *Service) UpdateShiftConfig(ctx context.Context, shiftConfig map[string]interface{}, origin Origin) (err error) {
+	var version *firestores.Version
+	defer func() { s.auditVersion(ctx, origin, AuditUpdateShiftConfig, "shiftconfig", version, err) }()
+	if err := s.validateShiftConfig(ctx, shiftConfig); err != nil {
+		return err
+	}
+	if version, err = s.writeConfig(ctx, "shiftconfig", origin.Actor, deleteMissing(shiftConfig, shiftConfigFields)); err != nil {
+		return fmt.Errorf("failed to update shift configuration: %v", err)
+	}
+	fmt.Println(`{"message": "Shift configuration updated", "severity": "notice"}`)
+	return nil
+}
+
+// PreviewShiftConfig plans the shifts currently on the board against a
+// proposed shift configuration without storing it.
+func (s *Service) PreviewShiftConfig(ctx context.Context, shiftConfig map[string]interface{}) (*ClaimPlan, error) {
+	if err := s.validateShiftConfig(ctx, shiftConfig); err != nil {
+		return nil, err
+	}
+	settings, err := shiftSettingsFromConfig(shiftConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShiftConfig, err)
+	}
+	if err := s.loadCredentials(ctx, settings); err != nil {
+		return nil, err
+	}
+	settings.closedShifts = s.closedShiftSet(ctx)
+	budget, err := s.newClaimBudget(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	availableShifts, err := fetchAvailableShifts(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch available shifts: %v", ErrPortal, err)
+	}
+	return planClaims(rankShifts(availableShifts, settings.strategy), budget, settings), nil
+}
+
+// validateShiftConfig checks every field and reports all problems at once.
+func (s *Service) validateShiftConfig(ctx context.Context, shiftConfig map[string]interface{}) error {
+	options, err := s.shiftConfigOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Stations can only be checked once some have been seen on the board
+	knownStation := func(station string) bool {
+		return len(options.Stations) == 0 || containsFold(options.Stations, station)
+	}
+
+	var problems []string
+	for field := range shiftConfig {
+		if !shiftConfigFields[field] {
+			problems = append(problems, fmt.Sprintf("unknown field '%s'", field))
+		}
+	}
+
+	// claimShifts compares shift dates against this exact layout
+	if date, ok := shiftConfig["shift_start_date"].(string); !ok {
+		problems = append(problems, "'shift_start_date' is required")
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		problems = append(problems, "'shift_start_date' must use the YYYY-MM-DD format")
+	}
+	if shiftRange, ok := shiftConfig["shift_range"].(string); !ok {
+		problems = append(problems, "'shift_range' is required")
+	} else if !containsFold(options.Ranges, shiftRange) {
+		problems = append(problems, fmt.Sprintf("'shift_range' must be one of %s", strings.Join(options.Ranges, ", ")))
+	}
+	if shiftGroup, ok := shiftConfig["shift_group"].(string); !ok {
+		problems = append(problems, "'shift_group' is required")
+	} else {
+		// Shifts are matched against the same list, see evaluateShift
+		for _, group := range shiftGroups(shiftGroup) {
+			if !containsFold(options.Groups, group) {
+				problems = append(problems, fmt.Sprintf("unknown shift group '%s'", group))
+			}
+		}
+	}
+
+	if _, err := newStrategy(shiftConfig); err != nil {
+		problems = append(problems, err.Error())
+	}
+	// Negative weights are allowed, to prefer shorter or later shifts
+	for _, field := range []string{"hours_weight", "date_weight"} {
+		if value, ok := shiftConfig[field]; ok {
+			if _, ok := toFloat(value); !ok {
+				problems = append(problems, fmt.Sprintf("'%s' must be a number", field))
+			}
+		}
+	}
+	if value, ok := shiftConfig["max_weekly_hours"]; ok {
+		if n, ok := toFloat(value); !ok || n < 0 {
+			problems = append(problems, "'max_weekly_hours' must be a non-negative number")
+		}
+	}
+	if stations, err := weightsFromConfig(shiftConfig["preferred_stations"]); err == nil {
+		for station := range stations {
+			if !knownStation(station) {
+				problems = append(problems, fmt.Sprintf("unknown station '%s' in 'preferred_stations'", station))
+			}
+		}
+	}
+	if groups, err := weightsFromConfig(shiftConfig["preferred_groups"]); err == nil {
+		for group := range groups {
+			if !containsFold(options.Groups, group) {
+				problems = append(problems, fmt.Sprintf("unknown shift group '%s' in 'preferred_groups'", group))
+			}
+		}
+	}
+	rules, err := rulesFromConfig(shiftConfig["rules"])
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid 'rules': %v", err))
+	}
+	for _, rule := range rules {
+		for _, station := range rule.Stations {
+			if !knownStation(station) {
+				problems = append(problems, fmt.Sprintf("unknown station '%s' in %s", station, rule.describe()))
+			}
+		}
+		for _, group := range rule.Groups {
+			if !containsFold(options.Groups, group) {
+				problems = append(problems, fmt.Sprintf("unknown shift group '%s' in %s", group, rule.describe()))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrInvalidShiftConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// shiftConfigOptions collects the allowed values, adding the stations and
+// shift groups observed in recent swapboard snapshots.
+func (s *Service) shiftConfigOptions(ctx context.Context) (*ShiftConfigOptions, error) {
+	options := &ShiftConfigOptions{
+		Ranges:      knownShiftRanges,
+		Groups:      append([]string{}, knownShiftGroups...),
+		Stations:    []string{},
+		Strategies:  knownStrategies,
+		RuleActions: knownRuleActions,
+	}
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	iter := s.firestoreClient.Collection("available_shifts").
+		OrderBy("timestamp", firestore.Desc).
+		Limit(1000).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve observed shifts: %v", err)
+		}
+		data := doc.Data()
+		if station, ok := data["stnName"].(string); ok && station != "" && !containsFold(options.Stations, station) {
+			options.Stations = append(options.Stations, station)
+		}
+		if group, ok := data["shiftGroup"].(string); ok && group != "" && !containsFold(options.Groups, group) {
+			options.Groups = append(options.Groups, group)
+		}
+	}
+	sort.Strings(options.Stations)
+	return options, nil
+}
@@ -0,0 +1,73 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateShiftConfig(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"shift_start_date": "2024-05-06",
+			"shift_range":      "week",
+			"shift_group":      "A, C1",
+		}
+	}
+	tests := []struct {
+		name    string
+		change  map[string]interface{}
+		remove  string
+		wantErr []string
+	}{
+		{name: "minimal"},
+		{name: "group seen on the board", change: map[string]interface{}{"shift_group": "A,D"}},
+		{name: "weighted strategy", change: map[string]interface{}{
+			"strategy":           StrategyWeighted,
+			"preferred_stations": []interface{}{"North"},
+			"preferred_groups":   map[string]interface{}{"B": 2.0},
+			"hours_weight":       -0.5,
+			"date_weight":        1.0,
+			"max_weekly_hours":   40.0,
+		}},
+		{name: "missing start date", remove: "shift_start_date", wantErr: []string{"'shift_start_date' is required"}},
+		{name: "bad start date", change: map[string]interface{}{"shift_start_date": "05/06/2024"}, wantErr: []string{"YYYY-MM-DD"}},
+		{name: "unknown range", change: map[string]interface{}{"shift_range": "year"}, wantErr: []string{"'shift_range' must be one of"}},
+		{name: "missing group", remove: "shift_group", wantErr: []string{"'shift_group' is required"}},
+		// Groups are listed by name, not run together
+		{name: "groups run together", change: map[string]interface{}{"shift_group": "AC1"}, wantErr: []string{"unknown shift group 'AC1'"}},
+		{name: "part of a group", change: map[string]interface{}{"shift_group": "A,C"}, wantErr: []string{"unknown shift group 'C'"}},
+		{name: "unknown field", change: map[string]interface{}{"shift_groups": "A"}, wantErr: []string{"unknown field 'shift_groups'"}},
+		{name: "unknown strategy", change: map[string]interface{}{"strategy": "random"}, wantErr: []string{`unknown strategy "random"`}},
+		{name: "weight not a number", change: map[string]interface{}{"hours_weight": "high"}, wantErr: []string{"'hours_weight' must be a number"}},
+		{name: "negative weekly hours", change: map[string]interface{}{"max_weekly_hours": -1.0}, wantErr: []string{"'max_weekly_hours' must be a non-negative number"}},
+		{name: "unknown station", change: map[string]interface{}{"preferred_stations": []interface{}{"Nowhere"}}, wantErr: []string{"unknown station 'Nowhere'"}},
+		{name: "unknown preferred group", change: map[string]interface{}{"preferred_groups": []interface{}{"Z"}}, wantErr: []string{"unknown shift group 'Z' in 'preferred_groups'"}},
+		{name: "invalid rules", change: map[string]interface{}{"rules": "claim everything"}, wantErr: []string{"invalid 'rules'"}},
+		{name: "every problem at once", change: map[string]interface{}{"shift_range": "year", "shift_group": "Z", "max_weekly_hours": -1.0}, wantErr: []string{
+			"'shift_range' must be one of", "unknown shift group 'Z'", "'max_weekly_hours' must be a non-negative number",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, store := newPollService(t, nil, 0)
+			store.seed("available_shifts/1-1", map[string]interface{}{"stnName": "North", "shiftGroup": "D"})
+			shiftConfig := valid()
+			for field, value := range tt.change {
+				shiftConfig[field] = value
+			}
+			delete(shiftConfig, tt.remove)
+
+			err := s.validateShiftConfig(context.Background(), shiftConfig)
+			if len(tt.wantErr) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidShiftConfig)
+			for _, want := range tt.wantErr {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
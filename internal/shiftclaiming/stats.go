@@ -0,0 +1,293 @@
+package shiftclaiming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxStatsDays bounds the range a single stats request covers.
+const maxStatsDays = 366
+
+// maxRollupDays bounds the range a single rollup covers, since each day is
+// rolled up in turn and costs several store round trips.
+const maxRollupDays = 31
+
+// DailyStats is the rollup of one day of polling and claiming, in the
+// configured time zone.
+type DailyStats struct {
+	Date            string           `json:"date" firestore:"date"`
+	Claims          int              `json:"claims" firestore:"claims"`
+	ClaimsSucceeded int              `json:"claims_succeeded" firestore:"claimsSucceeded"`
+	SuccessRate     float64          `json:"success_rate" firestore:"successRate"`
+	HoursClaimed    float64          `json:"hours_claimed" firestore:"hoursClaimed"`
+	Polls           int64            `json:"polls" firestore:"polls"`
+	PollResults     map[string]int64 `json:"poll_results" firestore:"pollResults"`
+	Cooldowns       int64            `json:"cooldowns" firestore:"cooldowns"`
+	TasksCreated    int64            `json:"tasks_created" firestore:"tasksCreated"`
+	NewShifts       int64            `json:"new_shifts" firestore:"newShifts"`
+	RemovedShifts   int64            `json:"removed_shifts" firestore:"removedShifts"`
+	// AvgBoardWait estimates how long a shift sat on the board before a poll
+	// saw it, as half the gap between that poll and the one before it.
+	AvgBoardWait float64 `json:"avg_board_wait_seconds" firestore:"avgBoardWaitSeconds"`
+	// Unavailable names the figures that could not be computed for a day from
+	// before usage counters were kept; they are reported as zero.
+	Unavailable []string  `json:"unavailable,omitempty" firestore:"unavailable,omitempty"`
+	ComputedAt  time.Time `json:"computed_at" firestore:"computedAt"`
+}
+
+// derivedUnavailable are the figures a day without usage counters cannot
+// give, since nothing else stored them.
+var derivedUnavailable = []string{"cooldowns", "tasks_created", "new_shifts", "removed_shifts", "avg_board_wait_seconds"}
+
+// legacyPollGap is the most time between two board snapshots of the same
+// poll. Before the board was diffed, a poll stored a snapshot of every shift
+// on the board one after another, and polls are at least seconds apart.
+const legacyPollGap = time.Second
+
+// usageDay returns the day t falls on in the configured time zone.
+func (s *Service) usageDay(t time.Time) string {
+	location, err := time.LoadLocation(s.config.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	return t.In(location).Format("2006-01-02")
+}
+
+// usageCounters returns the increments a poll adds to the usage counters of
+// its day. Only the polls that find an empty board store a request, so the
+// rollup job reads these counters rather than the requests.
+func usageCounters(poll pollRecord, now time.Time) map[string]interface{} {
+	counters := map[string]interface{}{
+		"polls":       firestore.Increment(1),
+		"pollResults": map[string]interface{}{poll.Result: firestore.Increment(1)},
+	}
+	if poll.Cooldown {
+		counters["cooldowns"] = firestore.Increment(1)
+	}
+	// Without a previous board there is nothing to tell new shifts from
+	// ones that were already there
+	if board := poll.Board; board != nil && !board.PreviousAt.IsZero() {
+		if len(board.Added) > 0 {
+			// Shifts are assumed to arrive evenly between the two polls
+			boardWait := time.Duration(len(board.Added)) * now.Sub(board.PreviousAt) / 2
+			counters["newShifts"] = firestore.Increment(len(board.Added))
+			counters["boardWaitSeconds"] = firestore.Increment(boardWait.Seconds())
+		}
+		if len(board.Removed) > 0 {
+			counters["removedShifts"] = firestore.Increment(len(board.Removed))
+		}
+	}
+	return counters
+}
+
+// RollupStats computes the daily rollups for every day from one date to
+// another, inclusive, from the stored claims and usage counters. Days from
+// before the counters were kept are derived from the requests and board
+// snapshots stored then, which give their polls but not the rest.
+func (s *Service) RollupStats(ctx context.Context, from, to string) ([]*DailyStats, error) {
+	days, err := statsDays(from, to, maxRollupDays)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(s.config.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone: %v", err)
+	}
+
+	rollups := []*DailyStats{}
+	for _, day := range days {
+		stats, err := s.rollupDay(ctx, day, location)
+		if err != nil {
+			return nil, err
+		}
+		storeCtx, cancel := s.storeContext(ctx)
+		_, err = s.firestoreClient.Collection("stats_daily").Doc(day).Set(storeCtx, stats)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to store rollup for %s: %v", day, err)
+		}
+		rollups = append(rollups, stats)
+	}
+	fmt.Printf(`{"message": "Usage rollups computed", "from": "%s", "to": "%s", "severity": "info"}`+"\n", days[0], days[len(days)-1])
+	return rollups, nil
+}
+
+func (s *Service) rollupDay(ctx context.Context, day string, location *time.Location) (*DailyStats, error) {
+	start, _ := time.ParseInLocation("2006-01-02", day, location)
+	end := start.AddDate(0, 0, 1)
+	stats := &DailyStats{Date: day, PollResults: map[string]int64{}, ComputedAt: time.Now()}
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+
+	iter := s.firestoreClient.Collection("claims").
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve claims for %s: %v", day, err)
+		}
+		stats.Claims++
+		// Claims the schedule showed did not take are not counted as claimed
+		if doc.Data()["claimingStatus"] == "success" && doc.Data()["verification"] != VerificationUnverified {
+			stats.ClaimsSucceeded++
+			hours, _ := toFloat(doc.Data()["hours"])
+			stats.HoursClaimed += hours
+		}
+	}
+	if stats.Claims > 0 {
+		stats.SuccessRate = float64(stats.ClaimsSucceeded) / float64(stats.Claims)
+	}
+
+	countersSnap, err := s.firestoreClient.Collection("usage_counters").Doc(day).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to retrieve usage counters for %s: %v", day, err)
+	}
+	if !countersSnap.Exists() {
+		if err := s.deriveUsage(ctx, stats, start, end); err != nil {
+			return nil, fmt.Errorf("failed to derive usage for %s: %v", day, err)
+		}
+		return stats, nil
+	}
+	counters := countersSnap.Data()
+	stats.Polls, _ = counters["polls"].(int64)
+	stats.Cooldowns, _ = counters["cooldowns"].(int64)
+	stats.TasksCreated, _ = counters["tasksCreated"].(int64)
+	stats.NewShifts, _ = counters["newShifts"].(int64)
+	stats.RemovedShifts, _ = counters["removedShifts"].(int64)
+	if results, ok := counters["pollResults"].(map[string]interface{}); ok {
+		for result, count := range results {
+			stats.PollResults[result], _ = count.(int64)
+		}
+	}
+	if boardWait, ok := toFloat(counters["boardWaitSeconds"]); ok && stats.NewShifts > 0 {
+		stats.AvgBoardWait = boardWait / float64(stats.NewShifts)
+	}
+	return stats, nil
+}
+
+// deriveUsage fills in the polls of a day without usage counters. Every poll
+// that reached the swapboard then stored either a request noting the board
+// was empty or a snapshot of each shift on it; polls that failed or hit a
+// cooldown stored nothing and are not counted.
+func (s *Service) deriveUsage(ctx context.Context, stats *DailyStats, start, end time.Time) error {
+	emptyQuery := s.firestoreClient.Collection("requests").
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end)
+	result, err := emptyQuery.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count requests: %v", err)
+	}
+	var emptyPolls int64
+	if count, ok := result["count"].(*firestorepb.Value); ok {
+		emptyPolls = count.GetIntegerValue()
+	}
+
+	iter := s.firestoreClient.Collection("available_shifts").
+		Where("timestamp", ">=", start).
+		Where("timestamp", "<", end).
+		OrderBy("timestamp", firestore.Asc).
+		Select("timestamp").
+		Documents(ctx)
+	defer iter.Stop()
+	var snapshots []time.Time
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve board snapshots: %v", err)
+		}
+		if timestamp, ok := doc.Data()["timestamp"].(time.Time); ok {
+			snapshots = append(snapshots, timestamp)
+		}
+	}
+
+	stats.Polls = emptyPolls + countPolls(snapshots)
+	if stats.Polls > 0 {
+		stats.PollResults[PollOK] = stats.Polls
+	}
+	stats.Unavailable = derivedUnavailable
+	return nil
+}
+
+// countPolls counts the polls among board snapshots in time order, taking
+// snapshots less than legacyPollGap apart to be from the same poll.
+func countPolls(snapshots []time.Time) int64 {
+	var polls int64
+	for i, snapshot := range snapshots {
+		if i == 0 || snapshot.Sub(snapshots[i-1]) >= legacyPollGap {
+			polls++
+		}
+	}
+	return polls
+}
+
+// GetStats returns the stored daily rollups from one date to another,
+// inclusive.
+func (s *Service) GetStats(ctx context.Context, from, to string) ([]*DailyStats, error) {
+	days, err := statsDays(from, to, maxStatsDays)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	iter := s.firestoreClient.Collection("stats_daily").
+		Where("date", ">=", days[0]).
+		Where("date", "<=", days[len(days)-1]).
+		OrderBy("date", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+	rollups := []*DailyStats{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve rollups: %v", err)
+		}
+		var stats DailyStats
+		if err := doc.DataTo(&stats); err != nil {
+			return nil, fmt.Errorf("failed to parse rollup %s: %v", doc.Ref.ID, err)
+		}
+		rollups = append(rollups, &stats)
+	}
+	return rollups, nil
+}
+
+// statsDays lists the days from one YYYY-MM-DD date to another, inclusive.
+func statsDays(from, to string, maxDays int) ([]string, error) {
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 'from' must use the YYYY-MM-DD format", ErrInvalidQuery)
+	}
+	end, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 'to' must use the YYYY-MM-DD format", ErrInvalidQuery)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: 'to' is before 'from'", ErrInvalidQuery)
+	}
+	if end.Sub(start) >= time.Duration(maxDays)*24*time.Hour {
+		return nil, fmt.Errorf("%w: at most %d days can be requested", ErrInvalidQuery, maxDays)
+	}
+	var days []string
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days, nil
+}
@@ -0,0 +1,126 @@
+package shiftclaiming
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsDays(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		want    []string
+		wantErr string
+	}{
+		{"single day", "2024-05-06", "2024-05-06", []string{"2024-05-06"}, ""},
+		{"across a month", "2024-04-29", "2024-05-02", []string{"2024-04-29", "2024-04-30", "2024-05-01", "2024-05-02"}, ""},
+		{"leap day", "2024-02-28", "2024-03-01", []string{"2024-02-28", "2024-02-29", "2024-03-01"}, ""},
+		{"bad from", "05/06/2024", "2024-05-06", nil, "'from' must use the YYYY-MM-DD format"},
+		{"bad to", "2024-05-06", "", nil, "'to' must use the YYYY-MM-DD format"},
+		{"reversed", "2024-05-06", "2024-05-05", nil, "'to' is before 'from'"},
+		{"too long", "2024-01-01", "2025-01-01", nil, "at most 366 days"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, err := statsDays(tt.from, tt.to, maxStatsDays)
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrInvalidQuery)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, days)
+		})
+	}
+
+	days, err := statsDays("2024-01-01", "2024-12-31", maxStatsDays)
+	require.NoError(t, err)
+	assert.Len(t, days, maxStatsDays)
+
+	days, err = statsDays("2024-05-01", "2024-05-31", maxRollupDays)
+	require.NoError(t, err)
+	assert.Len(t, days, maxRollupDays)
+	_, err = statsDays("2024-05-01", "2024-06-01", maxRollupDays)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestUsageCounters(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 0, 10, 0, time.UTC)
+	tests := []struct {
+		name string
+		poll pollRecord
+		want map[string]interface{}
+	}{
+		{
+			name: "plain poll",
+			poll: pollRecord{Result: PollOK},
+			want: map[string]interface{}{
+				"polls":       firestore.Increment(1),
+				"pollResults": map[string]interface{}{PollOK: firestore.Increment(1)},
+			},
+		},
+		{
+			name: "cooldown",
+			poll: pollRecord{Result: PollPleaseWait, Cooldown: true},
+			want: map[string]interface{}{
+				"polls":       firestore.Increment(1),
+				"pollResults": map[string]interface{}{PollPleaseWait: firestore.Increment(1)},
+				"cooldowns":   firestore.Increment(1),
+			},
+		},
+		{
+			name: "first board has no new shifts",
+			poll: pollRecord{Result: PollOK, Board: &BoardDiff{Added: []Shift{{SchId: 1}}}},
+			want: map[string]interface{}{
+				"polls":       firestore.Increment(1),
+				"pollResults": map[string]interface{}{PollOK: firestore.Increment(1)},
+			},
+		},
+		{
+			name: "shifts added and removed",
+			poll: pollRecord{Result: PollOK, Board: &BoardDiff{
+				Added:      []Shift{{SchId: 1}, {SchId: 2}},
+				Removed:    []Shift{{SchId: 3}},
+				PreviousAt: now.Add(-10 * time.Second),
+			}},
+			want: map[string]interface{}{
+				"polls":            firestore.Increment(1),
+				"pollResults":      map[string]interface{}{PollOK: firestore.Increment(1)},
+				"newShifts":        firestore.Increment(2),
+				"boardWaitSeconds": firestore.Increment(10.0),
+				"removedShifts":    firestore.Increment(1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, usageCounters(tt.poll, now))
+		})
+	}
+}
+
+func TestCountPolls(t *testing.T) {
+	at := func(offset time.Duration) time.Time {
+		return time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC).Add(offset)
+	}
+	tests := []struct {
+		name      string
+		snapshots []time.Time
+		want      int64
+	}{
+		{"no snapshots", nil, 0},
+		{"one poll", []time.Time{at(0), at(3 * time.Millisecond), at(7 * time.Millisecond)}, 1},
+		{"polls seconds apart", []time.Time{at(0), at(2 * time.Millisecond), at(5 * time.Second), at(10 * time.Second)}, 3},
+		{"gap of exactly the limit", []time.Time{at(0), at(legacyPollGap)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, countPolls(tt.snapshots))
+		})
+	}
+}
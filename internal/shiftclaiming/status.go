@@ -0,0 +1,293 @@
+package shiftclaiming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestores "github.com/yesaswi/shift-claiming-automation/internal/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	PollStarted          = "started"
+	PollDisabled         = "disabled"
+	PollPaused           = "paused"
+	PollOK               = "ok"
+	PollSwapListDisabled = "swap_list_disabled"
+	PollPleaseWait       = "please_wait"
+	PollSessionTimeout   = "session_timeout"
+	PollError            = "error"
+)
+
+const (
+	PollerStopped        = "stopped"
+	PollerPolling        = "polling"
+	PollerPaused         = "paused"
+	PollerCooldown       = "cooldown"
+	PollerSessionExpired = "session_expired"
+)
+
+var pollerStates = []string{PollerStopped, PollerPolling, PollerPaused, PollerCooldown, PollerSessionExpired}
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// pollRecord is the outcome of a poll as kept in the state/poller document.
+type pollRecord struct {
+	Result           string
+	Message          string
+	NextPollAt       time.Time
+	Paused           bool
+	Cooldown         bool
+	CredentialsValid *bool
+	ShiftsSeen       int
+	// Interval is the wait until the next poll, and is zero when the poll
+	// did not choose one.
+	Interval pollInterval
+	// Board is the change in the swapboard, and is nil when the poll did not
+	// read it.
+	Board *BoardDiff
+}
+
+// state returns the poller state the poll leaves the poller in.
+func (p pollRecord) state() string {
+	switch {
+	case p.Result == PollDisabled:
+		return PollerStopped
+	case p.Cooldown:
+		return PollerCooldown
+	case p.Paused:
+		return PollerPaused
+	case p.CredentialsValid != nil && !*p.CredentialsValid:
+		return PollerSessionExpired
+	}
+	return PollerPolling
+}
+
+// recordPoll merges the outcome of a poll into the poller state document,
+// counts it towards the usage counters of the day and updates the poll metrics.
+func (s *Service) recordPoll(ctx context.Context, poll pollRecord) {
+	metrics.PollsTotal.WithLabelValues(poll.Result).Inc()
+	metrics.ShiftsSeenTotal.Add(float64(poll.ShiftsSeen))
+	metrics.SetPollerState(poll.state(), pollerStates)
+	if poll.Cooldown {
+		metrics.CooldownsTotal.WithLabelValues(poll.Result).Inc()
+	}
+
+	now := time.Now()
+	data := map[string]interface{}{
+		"lastPollAt":      now,
+		"lastPollResult":  poll.Result,
+		"lastPollMessage": poll.Message,
+		"shiftsSeen":      poll.ShiftsSeen,
+		"nextPollAt":      firestore.Delete,
+		"pausedUntil":     firestore.Delete,
+		"cooldownUntil":   firestore.Delete,
+		"pollInterval":    firestore.Delete,
+	}
+	if !poll.NextPollAt.IsZero() {
+		data["nextPollAt"] = poll.NextPollAt
+		if poll.Paused {
+			data["pausedUntil"] = poll.NextPollAt
+		}
+		if poll.Cooldown {
+			data["cooldownUntil"] = poll.NextPollAt
+		}
+	}
+	if poll.Interval.Interval > 0 {
+		data["pollInterval"] = map[string]interface{}{
+			"seconds": poll.Interval.Interval.Seconds(),
+			"reason":  poll.Interval.Reason,
+		}
+	}
+	if poll.Board != nil {
+		data["boardSeenAt"] = poll.Board.SeenAt
+	}
+	if poll.CredentialsValid != nil {
+		data["credentialsValid"] = *poll.CredentialsValid
+		data["credentialsCheckedAt"] = now
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("poll.result", poll.Result),
+		attribute.Int("poll.shifts_seen", poll.ShiftsSeen),
+	)
+	s.write(ctx, s.firestoreClient.Collection("state").Doc("poller"), data, firestore.MergeAll)
+	s.write(ctx, s.firestoreClient.Collection("usage_counters").Doc(s.usageDay(now)), usageCounters(poll, now), firestore.MergeAll)
+
+	event := map[string]interface{}{"result": poll.Result, "shifts_seen": poll.ShiftsSeen}
+	if poll.Message != "" {
+		event["message"] = poll.Message
+	}
+	if !poll.NextPollAt.IsZero() {
+		event["next_poll_at"] = poll.NextPollAt
+	}
+	s.events.Publish(EventPoll, event)
+	if poll.Cooldown {
+		s.events.Publish(EventCooldown, map[string]interface{}{"reason": poll.Result, "until": poll.NextPollAt})
+	}
+}
+
+// Status summarises whether claiming is enabled and what the poller is doing.
+type Status struct {
+	Enabled              bool       `json:"enabled"`
+	DryRun               bool       `json:"dry_run"`
+	PollerState          string     `json:"poller_state"`
+	CooldownRemaining    float64    `json:"cooldown_remaining_seconds"`
+	CooldownUntil        *time.Time `json:"cooldown_until,omitempty"`
+	PausedUntil          *time.Time `json:"paused_until,omitempty"`
+	LastPollAt           *time.Time `json:"last_poll_at,omitempty"`
+	LastPollResult       string     `json:"last_poll_result,omitempty"`
+	LastPollMessage      string     `json:"last_poll_message,omitempty"`
+	ShiftsSeen           int        `json:"shifts_seen"`
+	NextPollAt           *time.Time `json:"next_poll_at,omitempty"`
+	CredentialsValid     *bool      `json:"credentials_valid,omitempty"`
+	CredentialsCheckedAt *time.Time `json:"credentials_checked_at,omitempty"`
+	PollerMode           string     `json:"poller_mode"`
+	LeaseHolder          string     `json:"lease_holder,omitempty"`
+	LeaseToken           int64      `json:"lease_token,omitempty"`
+	LeaseExpiresAt       *time.Time `json:"lease_expires_at,omitempty"`
+	// PollInterval is the wait the last poll chose until the next one, and
+	// PollIntervalReason why it was chosen.
+	PollInterval       float64 `json:"poll_interval_seconds,omitempty"`
+	PollIntervalReason string  `json:"poll_interval_reason,omitempty"`
+}
+
+// GetStatus combines the configuration flags with the recorded poller state.
+func (s *Service) GetStatus(ctx context.Context) (*Status, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	configDocSnap, err := s.firestoreClient.Collection("configuration").Doc("config").Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to retrieve configuration: %v", err)
+	}
+	stateDocSnap, err := s.firestoreClient.Collection("state").Doc("poller").Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("failed to retrieve poller state: %v", err)
+	}
+	configData := configDocSnap.Data()
+	stateData := stateDocSnap.Data()
+	held, err := s.leases.Current(ctx, pollerLease)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve poller lease: %v", err)
+	}
+
+	now := time.Now()
+	st := &Status{
+		LastPollAt:           timeField(stateData, "lastPollAt"),
+		NextPollAt:           timeField(stateData, "nextPollAt"),
+		PausedUntil:          timeField(stateData, "pausedUntil"),
+		CooldownUntil:        timeField(stateData, "cooldownUntil"),
+		CredentialsCheckedAt: timeField(stateData, "credentialsCheckedAt"),
+		PollerMode:           s.config.PollerMode,
+	}
+	if held != nil {
+		st.LeaseHolder = held.Holder
+		st.LeaseToken = held.Token
+		st.LeaseExpiresAt = &held.ExpiresAt
+	}
+	st.Enabled, _ = configData["startStopFlag"].(bool)
+	st.DryRun, _ = configData["dryRun"].(bool)
+	st.LastPollResult, _ = stateData["lastPollResult"].(string)
+	st.LastPollMessage, _ = stateData["lastPollMessage"].(string)
+	if shiftsSeen, ok := stateData["shiftsSeen"].(int64); ok {
+		st.ShiftsSeen = int(shiftsSeen)
+	}
+	if credentialsValid, ok := stateData["credentialsValid"].(bool); ok {
+		st.CredentialsValid = &credentialsValid
+	}
+	if interval, ok := stateData["pollInterval"].(map[string]interface{}); ok {
+		st.PollInterval, _ = toFloat(interval["seconds"])
+		st.PollIntervalReason, _ = interval["reason"].(string)
+	}
+
+	switch {
+	case !st.Enabled:
+		st.PollerState = PollerStopped
+	case st.CooldownUntil != nil && st.CooldownUntil.After(now):
+		st.PollerState = PollerCooldown
+		st.CooldownRemaining = st.CooldownUntil.Sub(now).Seconds()
+	case st.PausedUntil != nil && st.PausedUntil.After(now):
+		st.PollerState = PollerPaused
+	case st.CredentialsValid != nil && !*st.CredentialsValid:
+		st.PollerState = PollerSessionExpired
+	default:
+		st.PollerState = PollerPolling
+	}
+	return st, nil
+}
+
+// HistoryQuery filters and paginates the claims and seen shifts collections.
+type HistoryQuery struct {
+	From   time.Time
+	To     time.Time
+	Status string
+	Cursor string
+	Limit  int
+}
+
+// ListClaims returns stored claim attempts, newest first.
+func (s *Service) ListClaims(ctx context.Context, q HistoryQuery) (*firestores.Page, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	collection := s.firestoreClient.Collection("claims")
+	query := historyQuery(collection, q)
+	if q.Status != "" {
+		query = query.Where("claimingStatus", "==", q.Status)
+	}
+	return firestores.ListPage(ctx, collection, query.OrderBy("timestamp", firestore.Desc), q.Cursor, q.Limit)
+}
+
+// ListSeenShifts returns the shifts seen on the swapboard with when they were
+// first and last seen, most recently posted first. Shifts still on the board
+// were last seen by the last poll, whatever their documents say.
+func (s *Service) ListSeenShifts(ctx context.Context, q HistoryQuery) (*firestores.Page, error) {
+	if q.Status != "" {
+		return nil, fmt.Errorf("%w: status filter is not supported for seen shifts", ErrInvalidQuery)
+	}
+	seenAt, err := s.boardSeenAt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	collection := s.firestoreClient.Collection("available_shifts")
+	page, err := firestores.ListPage(ctx, collection, historyQuery(collection, q).OrderBy("timestamp", firestore.Desc), q.Cursor, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range page.Items {
+		lastSeenAt, _ := item["lastSeenAt"].(time.Time)
+		if _, removed := item["removedAt"]; !removed && seenAt.After(lastSeenAt) {
+			item["lastSeenAt"] = seenAt
+		}
+	}
+	return page, nil
+}
+
+func historyQuery(collection *firestore.CollectionRef, q HistoryQuery) firestore.Query {
+	query := collection.Query
+	if !q.From.IsZero() {
+		query = query.Where("timestamp", ">=", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("timestamp", "<", q.To)
+	}
+	return query
+}
+
+func timeField(data map[string]interface{}, field string) *time.Time {
+	if t, ok := data[field].(time.Time); ok {
+		return &t
+	}
+	return nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
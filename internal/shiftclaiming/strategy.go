@@ -0,0 +1,154 @@
+package shiftclaiming
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategy scores candidate shifts so that the most desirable ones are claimed
+// first when several of them compete for the remaining weekly hours or overlap.
+type Strategy interface {
+	Name() string
+	Score(shift Shift) float64
+}
+
+// ScoredShift is a candidate shift together with the score assigned by a Strategy.
+type ScoredShift struct {
+	Shift
+	Score float64
+}
+
+const (
+	StrategyBoard    = "board"
+	StrategySchID    = "schid_desc"
+	StrategyWeighted = "weighted"
+)
+
+// boardStrategy keeps the order in which the swapboard returned the shifts.
+type boardStrategy struct{}
+
+func (boardStrategy) Name() string { return StrategyBoard }
+
+func (boardStrategy) Score(shift Shift) float64 { return 0 }
+
+// schIDStrategy claims the most recently posted shifts first, like the legacy
+// Python checker which sorted the board by SchId descending.
+type schIDStrategy struct{}
+
+func (schIDStrategy) Name() string { return StrategySchID }
+
+func (schIDStrategy) Score(shift Shift) float64 { return float64(shift.SchId) }
+
+// weightedStrategy adds up weighted preferences for stations, shift groups,
+// shift length and how soon the shift starts.
+type weightedStrategy struct {
+	StationWeights map[string]float64
+	GroupWeights   map[string]float64
+	HoursWeight    float64
+	DateWeight     float64
+	now            func() time.Time
+}
+
+func (w weightedStrategy) Name() string { return StrategyWeighted }
+
+func (w weightedStrategy) Score(shift Shift) float64 {
+	score := w.StationWeights[shift.StnName] + w.GroupWeights[shift.ShiftGroup]
+	score += w.HoursWeight * shift.Hours
+	if shiftDate, err := time.Parse("2006-01-02T15:04:05", shift.Date); err == nil {
+		// Earlier dates score higher: DateWeight is taken off per day out.
+		days := shiftDate.Sub(w.now()).Hours() / 24
+		if days < 0 {
+			days = 0
+		}
+		score -= w.DateWeight * days
+	}
+	return score
+}
+
+// newStrategy builds the strategy selected in the shift configuration. An empty
+// name keeps the board order.
+func newStrategy(shiftConfig map[string]interface{}) (Strategy, error) {
+	name, _ := shiftConfig["strategy"].(string)
+	switch name {
+	case "", StrategyBoard:
+		return boardStrategy{}, nil
+	case StrategySchID:
+		return schIDStrategy{}, nil
+	case StrategyWeighted:
+		stationWeights, err := weightsFromConfig(shiftConfig["preferred_stations"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'preferred_stations' in claiming configuration: %v", err)
+		}
+		groupWeights, err := weightsFromConfig(shiftConfig["preferred_groups"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'preferred_groups' in claiming configuration: %v", err)
+		}
+		hoursWeight, _ := toFloat(shiftConfig["hours_weight"])
+		dateWeight, _ := toFloat(shiftConfig["date_weight"])
+		return weightedStrategy{
+			StationWeights: stationWeights,
+			GroupWeights:   groupWeights,
+			HoursWeight:    hoursWeight,
+			DateWeight:     dateWeight,
+			now:            time.Now,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q in claiming configuration", name)
+	}
+}
+
+// rankShifts scores every shift and orders them highest score first. Shifts
+// with equal scores keep their board order.
+func rankShifts(shifts []Shift, strategy Strategy) []ScoredShift {
+	ranked := make([]ScoredShift, 0, len(shifts))
+	for _, shift := range shifts {
+		ranked = append(ranked, ScoredShift{Shift: shift, Score: strategy.Score(shift)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// weightsFromConfig accepts either a map of name to weight or a list of names,
+// in which case every listed name gets a weight of one.
+func weightsFromConfig(value interface{}) (map[string]float64, error) {
+	weights := map[string]float64{}
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for name, raw := range v {
+			weight, ok := toFloat(raw)
+			if !ok {
+				return nil, fmt.Errorf("weight for %q is not a number", name)
+			}
+			weights[strings.TrimSpace(name)] = weight
+		}
+	case []interface{}:
+		for _, raw := range v {
+			name, ok := raw.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of names")
+			}
+			weights[strings.TrimSpace(name)] = 1
+		}
+	default:
+		return nil, fmt.Errorf("expected a map of weights or a list of names")
+	}
+	return weights, nil
+}
+
+// toFloat converts the numeric types Firestore hands back into a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
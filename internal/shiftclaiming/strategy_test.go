@@ -0,0 +1,105 @@
+package shiftclaiming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedStrategyScore(t *testing.T) {
+	now := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	strategy := weightedStrategy{
+		StationWeights: map[string]float64{"North": 3},
+		GroupWeights:   map[string]float64{"C1": 2},
+		HoursWeight:    0.5,
+		DateWeight:     1,
+		now:            func() time.Time { return now },
+	}
+	tests := []struct {
+		name  string
+		shift Shift
+		want  float64
+	}{
+		{"no preferences", Shift{Date: "2024-05-06T00:00:00"}, 0},
+		{"station and group", Shift{Date: "2024-05-06T00:00:00", StnName: "North", ShiftGroup: "C1"}, 5},
+		{"hours", Shift{Date: "2024-05-06T00:00:00", Hours: 8}, 4},
+		{"days out", Shift{Date: "2024-05-09T00:00:00"}, -3},
+		{"half a day out", Shift{Date: "2024-05-06T12:00:00"}, -0.5},
+		{"past dates count as today", Shift{Date: "2024-05-01T00:00:00"}, 0},
+		{"unparsable date is not scored", Shift{Date: "May 9", Hours: 8}, 4},
+		{"everything", Shift{Date: "2024-05-08T00:00:00", StnName: "North", ShiftGroup: "C1", Hours: 12}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, strategy.Score(tt.shift), 1e-9)
+		})
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		wantName string
+		wantErr  string
+	}{
+		{"default", map[string]interface{}{}, StrategyBoard, ""},
+		{"board", map[string]interface{}{"strategy": "board"}, StrategyBoard, ""},
+		{"schid", map[string]interface{}{"strategy": "schid_desc"}, StrategySchID, ""},
+		{"weighted", map[string]interface{}{"strategy": "weighted", "preferred_stations": []interface{}{"North"}, "hours_weight": int64(2)}, StrategyWeighted, ""},
+		{"unknown", map[string]interface{}{"strategy": "random"}, "", `unknown strategy "random"`},
+		{"wrong case", map[string]interface{}{"strategy": "Weighted"}, "", `unknown strategy "Weighted"`},
+		{"bad station weights", map[string]interface{}{"strategy": "weighted", "preferred_stations": "North"}, "", "invalid 'preferred_stations'"},
+		{"bad group weights", map[string]interface{}{"strategy": "weighted", "preferred_groups": map[string]interface{}{"A": "high"}}, "", "invalid 'preferred_groups'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := newStrategy(tt.config)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, strategy.Name())
+		})
+	}
+}
+
+func TestRankShifts(t *testing.T) {
+	shifts := []Shift{
+		{SchId: 1, StnName: "South"},
+		{SchId: 2, StnName: "North"},
+		{SchId: 3, StnName: "East"},
+		{SchId: 4, StnName: "North"},
+	}
+	schIDs := func(ranked []ScoredShift) []int {
+		ids := make([]int, len(ranked))
+		for i, shift := range ranked {
+			ids[i] = shift.SchId
+		}
+		return ids
+	}
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     []int
+	}{
+		{"board order", boardStrategy{}, []int{1, 2, 3, 4}},
+		{"newest posting first", schIDStrategy{}, []int{4, 3, 2, 1}},
+		// Equal scores keep their board order
+		{"ties", weightedStrategy{StationWeights: map[string]float64{"North": 1}, now: time.Now}, []int{2, 4, 1, 3}},
+		{"negative weights rank last", weightedStrategy{StationWeights: map[string]float64{"South": -1, "East": 1}, now: time.Now}, []int{3, 2, 4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := rankShifts(shifts, tt.strategy)
+			assert.Equal(t, tt.want, schIDs(ranked))
+			for _, shift := range ranked {
+				assert.Equal(t, tt.strategy.Score(shift.Shift), shift.Score)
+			}
+		})
+	}
+	assert.Empty(t, rankShifts(nil, boardStrategy{}))
+}
@@ -0,0 +1,174 @@
+package shiftclaiming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// boardSnapshot is the swapboard as read by the last poll that changed it,
+// kept in the state/board document. The polls that find it unchanged record
+// when they read it in the poller state instead, as boardSeenAt.
+type boardSnapshot struct {
+	SeenAt time.Time             `firestore:"seenAt"`
+	Shifts map[string]boardShift `firestore:"shifts"`
+}
+
+type boardShift struct {
+	Shift       Shift     `firestore:"shift"`
+	FirstSeenAt time.Time `firestore:"firstSeenAt"`
+}
+
+// BoardDiff is the change in the swapboard since the previous poll.
+type BoardDiff struct {
+	Added     []Shift
+	Removed   []Shift
+	Unchanged []Shift
+	// PreviousAt is when the previous board was read, and is zero when there
+	// was none.
+	PreviousAt time.Time
+	// SeenAt is when this board was read.
+	SeenAt time.Time
+}
+
+// shiftKey identifies a posting on the swapboard. The same shift can be
+// posted more than once, so the swap ID is combined with the shift ID.
+func shiftKey(shift Shift) string {
+	return fmt.Sprintf("%d-%d", shift.SchId, shift.Id)
+}
+
+// diffBoard compares the current board, read at now, with the previous
+// snapshot.
+func diffBoard(previous *boardSnapshot, current []Shift, now time.Time) *BoardDiff {
+	diff := &BoardDiff{PreviousAt: previous.SeenAt, SeenAt: now}
+	onBoard := make(map[string]bool, len(current))
+	for _, shift := range current {
+		key := shiftKey(shift)
+		onBoard[key] = true
+		if _, ok := previous.Shifts[key]; ok {
+			diff.Unchanged = append(diff.Unchanged, shift)
+		} else {
+			diff.Added = append(diff.Added, shift)
+		}
+	}
+	for key, seen := range previous.Shifts {
+		if !onBoard[key] {
+			diff.Removed = append(diff.Removed, seen.Shift)
+		}
+	}
+	return diff
+}
+
+// trackBoard diffs the board against the previous poll's snapshot and queues
+// the change for writing. available_shifts is only written when postings
+// appear or vanish, as is the snapshot itself: a shift's document holds when
+// it was first and last seen, and the shifts still on the board have their
+// last sighting brought up to date with each change. Between changes it lags
+// behind the polls, see boardSeenAt.
+func (s *Service) trackBoard(ctx context.Context, shifts []Shift, now time.Time) (*BoardDiff, error) {
+	boardDoc := s.firestoreClient.Collection("state").Doc("board")
+	storeCtx, cancel := s.storeContext(ctx)
+	snaps, err := s.firestoreClient.GetAll(storeCtx, []*firestore.DocumentRef{boardDoc, s.firestoreClient.Collection("state").Doc("poller")})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve previous board: %v", err)
+	}
+	previous := &boardSnapshot{}
+	if snaps[0].Exists() {
+		if err := snaps[0].DataTo(previous); err != nil {
+			return nil, fmt.Errorf("failed to parse previous board: %v", err)
+		}
+	}
+	previous.SeenAt = latestBoardSeenAt(snaps[0], snaps[1])
+	diff := diffBoard(previous, shifts, now)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		return diff, nil
+	}
+
+	current := &boardSnapshot{SeenAt: now, Shifts: make(map[string]boardShift, len(shifts))}
+	for _, shift := range diff.Unchanged {
+		key := shiftKey(shift)
+		current.Shifts[key] = boardShift{Shift: shift, FirstSeenAt: previous.Shifts[key].FirstSeenAt}
+	}
+	for _, shift := range diff.Added {
+		current.Shifts[shiftKey(shift)] = boardShift{Shift: shift, FirstSeenAt: now}
+	}
+
+	s.write(ctx, boardDoc, current)
+	seenShifts := s.firestoreClient.Collection("available_shifts")
+	for _, shift := range diff.Added {
+		s.write(ctx, seenShifts.Doc(shiftKey(shift)), map[string]interface{}{
+			"timestamp":      now,
+			"firstSeenAt":    now,
+			"lastSeenAt":     now,
+			"removedAt":      firestore.Delete,
+			"onBoardSeconds": firestore.Delete,
+			"id":             shift.Id,
+			"schId":          shift.SchId,
+			"locId":          shift.LocId,
+			"stnName":        shift.StnName,
+			"date":           shift.Date,
+			"hours":          shift.Hours,
+			"shiftGroup":     shift.ShiftGroup,
+			"start":          shift.Start,
+			"end":            shift.End,
+		}, firestore.MergeAll)
+	}
+	for _, shift := range diff.Unchanged {
+		s.write(ctx, seenShifts.Doc(shiftKey(shift)), map[string]interface{}{
+			"lastSeenAt": now,
+		}, firestore.MergeAll)
+	}
+	for _, shift := range diff.Removed {
+		// The shift went some time between the previous poll and this one
+		firstSeenAt := previous.Shifts[shiftKey(shift)].FirstSeenAt
+		s.write(ctx, seenShifts.Doc(shiftKey(shift)), map[string]interface{}{
+			"lastSeenAt":     previous.SeenAt,
+			"removedAt":      now,
+			"onBoardSeconds": previous.SeenAt.Sub(firstSeenAt).Seconds(),
+		}, firestore.MergeAll)
+	}
+
+	for _, shift := range diff.Added {
+		s.events.Publish(EventShiftAdded, map[string]interface{}{"shift": shift, "first_seen_at": now})
+	}
+	for _, shift := range diff.Removed {
+		firstSeenAt := previous.Shifts[shiftKey(shift)].FirstSeenAt
+		s.events.Publish(EventShiftRemoved, map[string]interface{}{
+			"shift":            shift,
+			"first_seen_at":    firstSeenAt,
+			"last_seen_at":     previous.SeenAt,
+			"on_board_seconds": previous.SeenAt.Sub(firstSeenAt).Seconds(),
+		})
+	}
+	fmt.Printf(`{"message": "Swapboard changed", "added": %d, "removed": %d, "unchanged": %d, "severity": "info"}`+"\n", len(diff.Added), len(diff.Removed), len(diff.Unchanged))
+	return diff, nil
+}
+
+// boardSeenAt returns when the swapboard was last read, by the last poll that
+// changed it or by a later one that found it unchanged, or the zero time when
+// it never was. Shifts still on the board were last seen then.
+func (s *Service) boardSeenAt(ctx context.Context) (time.Time, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	snaps, err := s.firestoreClient.GetAll(ctx, []*firestore.DocumentRef{
+		s.firestoreClient.Collection("state").Doc("board"),
+		s.firestoreClient.Collection("state").Doc("poller"),
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to retrieve board state: %v", err)
+	}
+	return latestBoardSeenAt(snaps[0], snaps[1]), nil
+}
+
+// latestBoardSeenAt takes the later of the board snapshot's read time and
+// the poller state's boardSeenAt.
+func latestBoardSeenAt(board, poller *firestore.DocumentSnapshot) time.Time {
+	seenAt, _ := board.Data()["seenAt"].(time.Time)
+	if pollSeenAt, ok := poller.Data()["boardSeenAt"].(time.Time); ok && pollSeenAt.After(seenAt) {
+		seenAt = pollSeenAt
+	}
+	return seenAt
+}
@@ -0,0 +1,86 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackBoard(t *testing.T) {
+	boardSeenAt := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	lastSeenAt := boardSeenAt.Add(time.Hour)
+	now := lastSeenAt.Add(time.Minute)
+	posted := Shift{Id: 7, SchId: 1234, Date: "2024-05-06T00:00:00", Hours: 8, ShiftGroup: "A"}
+	other := Shift{Id: 8, SchId: 5678, Date: "2024-05-07T00:00:00", Hours: 8, ShiftGroup: "A"}
+	tests := []struct {
+		name        string
+		board       []Shift
+		wantAdded   []Shift
+		wantRemoved []Shift
+		wantWritten []string
+	}{
+		{"unchanged", []Shift{posted}, nil, nil, nil},
+		{"added", []Shift{posted, other}, []Shift{other}, nil, []string{"state/board", "available_shifts/5678-8", "available_shifts/1234-7"}},
+		{"removed", nil, nil, []Shift{posted}, []string{"state/board", "available_shifts/1234-7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, store := newPollService(t, nil, 0)
+			store.seed("state/board", map[string]interface{}{
+				"seenAt": boardSeenAt,
+				"shifts": map[string]interface{}{
+					"1234-7": map[string]interface{}{
+						"firstSeenAt": boardSeenAt,
+						"shift":       map[string]interface{}{"Id": 7, "SchId": 1234, "Date": "2024-05-06T00:00:00", "Hours": 8.0, "ShiftGroup": "A"},
+					},
+				},
+			})
+			// Polls that found the board unchanged since
+			store.seed("state/poller", map[string]interface{}{"boardSeenAt": lastSeenAt})
+
+			diff, err := s.trackBoard(context.Background(), tt.board, now)
+			require.NoError(t, err)
+			require.NoError(t, s.writes.Flush(context.Background()))
+			assert.Equal(t, tt.wantAdded, diff.Added)
+			assert.Equal(t, tt.wantRemoved, diff.Removed)
+			assert.Equal(t, lastSeenAt, diff.PreviousAt)
+			assert.Equal(t, now, diff.SeenAt)
+			assert.ElementsMatch(t, tt.wantWritten, store.writtenDocs())
+		})
+	}
+}
+
+func TestListSeenShiftsLastSeenAt(t *testing.T) {
+	firstSeenAt := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	boardSeenAt := firstSeenAt.Add(time.Hour)
+	removedAt := firstSeenAt.Add(30 * time.Minute)
+	s, _, store := newPollService(t, nil, 0)
+	store.seed("state/board", map[string]interface{}{"seenAt": firstSeenAt})
+	// A later poll found the board unchanged
+	store.seed("state/poller", map[string]interface{}{"boardSeenAt": boardSeenAt})
+	store.seed("available_shifts/1234-7", map[string]interface{}{
+		"timestamp":   firstSeenAt,
+		"firstSeenAt": firstSeenAt,
+		"lastSeenAt":  firstSeenAt,
+	})
+	store.seed("available_shifts/5678-8", map[string]interface{}{
+		"timestamp":   firstSeenAt,
+		"firstSeenAt": firstSeenAt,
+		"lastSeenAt":  firstSeenAt,
+		"removedAt":   removedAt,
+	})
+
+	page, err := s.ListSeenShifts(context.Background(), HistoryQuery{Limit: 10})
+	require.NoError(t, err)
+	lastSeen := map[string]interface{}{}
+	for _, item := range page.Items {
+		lastSeen[item["documentId"].(string)] = item["lastSeenAt"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		"1234-7": boardSeenAt,
+		"5678-8": firstSeenAt,
+	}, lastSeen, "shifts still on the board were last seen by the last poll")
+}
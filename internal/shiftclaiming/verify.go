@@ -0,0 +1,414 @@
+package shiftclaiming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/yesaswi/shift-claiming-automation/internal/metrics"
+	"github.com/yesaswi/shift-claiming-automation/internal/portal"
+	"google.golang.org/api/iterator"
+)
+
+// Verifications of a successful claim against the user's schedule, as kept in
+// the claim's record.
+const (
+	VerificationPending    = "pending"
+	VerificationVerified   = "verified"
+	VerificationUnverified = "unverified"
+)
+
+// verifyAttempts is how many times the schedule is read before a claim that
+// is not on it is taken to have failed, since the portal may take a while to
+// put a claimed shift on the schedule.
+const verifyAttempts = 3
+
+// verifySweepAfter is how many VerifyDelays a claim is left pending before a
+// poll verifies it, by when a verification started in the background has
+// either finished or been lost.
+const verifySweepAfter = verifyAttempts + 1
+
+// verifySweepDays bounds the age of the claims a poll verifies. The schedule
+// read covers the swapboard's dates, so it may no longer list the shifts of
+// older claims, which are left pending.
+const verifySweepDays = 7
+
+// claimVerifications are the verifications running in this instance. They
+// are cancelled when the service closes, leaving their claims pending for a
+// later poll to verify.
+type claimVerifications struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// pending are the times of the claims made by this instance that are
+	// still pending verification, by claim ID
+	pending map[string]time.Time
+	// swept is set once a poll has verified the claims left pending in the
+	// store, such as by an earlier instance
+	swept bool
+}
+
+func newClaimVerifications() *claimVerifications {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &claimVerifications{ctx: ctx, cancel: cancel, pending: map[string]time.Time{}}
+}
+
+func (v *claimVerifications) add(claimed []ClaimingResult) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, result := range claimed {
+		v.pending[result.ClaimID] = result.Timestamp
+	}
+}
+
+func (v *claimVerifications) done(claimID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.pending, claimID)
+}
+
+// sweepDue reports whether a poll should look for pending claims in the store:
+// until the first sweep succeeded, and then only while a claim of this
+// instance made between since and before is still pending. Older claims are
+// forgotten, since the sweep no longer verifies them.
+func (v *claimVerifications) sweepDue(since, before time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	due := !v.swept
+	for claimID, claimedAt := range v.pending {
+		if claimedAt.Before(since) {
+			delete(v.pending, claimID)
+		} else if claimedAt.Before(before) {
+			due = true
+		}
+	}
+	return due
+}
+
+func (v *claimVerifications) setSwept() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.swept = true
+}
+
+// stop cancels the running verifications and waits for them to return.
+func (v *claimVerifications) stop() {
+	v.cancel()
+	v.wg.Wait()
+}
+
+// verifyClaims checks in the background that the successful claims among the
+// results made it onto the user's schedule, since the portal has answered
+// claims that did not take with success. The results must have been
+// recorded. In the tasks mode the instance may be throttled or shut down
+// once the request returns, and the verification never finishes; the claims
+// it leaves pending are verified by sweepVerifications in a later poll.
+func (s *Service) verifyClaims(claimingResults []ClaimingResult, settings *claimSettings) {
+	if s.config.VerifyDelay <= 0 {
+		return
+	}
+	var claimed []ClaimingResult
+	for _, result := range claimingResults {
+		if result.Outcome == ClaimClaimed && result.ClaimID != "" {
+			claimed = append(claimed, result)
+		}
+	}
+	if len(claimed) == 0 {
+		return
+	}
+	s.verifications.add(claimed)
+	s.verifications.wg.Add(1)
+	go func() {
+		defer s.verifications.wg.Done()
+		s.verifyClaimed(s.verifications.ctx, claimed, settings)
+	}()
+}
+
+// verifyClaimed reads the schedule a few times, VerifyDelay apart, until
+// every claim is on it. Claims still missing from the schedule then are
+// marked unverified and alerted on. If the schedule could not be read at all
+// the claims are left pending.
+func (s *Service) verifyClaimed(ctx context.Context, claimed []ClaimingResult, settings *claimSettings) {
+	pending := claimed
+	read := false
+	for attempt := 1; attempt <= verifyAttempts && len(pending) > 0; attempt++ {
+		wait := time.NewTimer(s.config.VerifyDelay)
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return
+		case <-wait.C:
+		}
+		scheduled, err := fetchSchedule(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
+		if err != nil {
+			fmt.Printf(`{"message": "Failed to read schedule", "attempt": %d, "error": "%v", "severity": "warning"}`+"\n", attempt, err)
+			continue
+		}
+		read = true
+		var verified, missing []ClaimingResult
+		for _, result := range pending {
+			schID, _ := strconv.Atoi(result.ShiftID)
+			if scheduled[schID] {
+				verified = append(verified, result)
+			} else {
+				missing = append(missing, result)
+			}
+		}
+		s.markVerifications(ctx, verified, VerificationVerified)
+		pending = missing
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if !read {
+		shiftIDs := make([]string, len(pending))
+		for i, result := range pending {
+			shiftIDs[i] = result.ShiftID
+		}
+		shiftIDsJSON, _ := json.Marshal(shiftIDs)
+		fmt.Printf(`{"message": "Claims left unverified, schedule could not be read", "shift_ids": %s, "severity": "warning"}`+"\n", shiftIDsJSON)
+		return
+	}
+	if unverified := s.markVerifications(ctx, pending, VerificationUnverified); len(unverified) > 0 {
+		s.alertUnverified(ctx, unverified)
+	}
+}
+
+// sweepVerifications verifies the claims left pending for longer than a
+// verification in the background takes, against a single read of the
+// schedule. Claims on the schedule are marked verified, and the rest
+// unverified and alerted on, since their verification in the background has
+// already waited for the portal. If the schedule cannot be read the claims
+// stay pending for the next poll.
+//
+// The store is only searched for pending claims by the first poll of the
+// instance and while one of the instance's own claims is overdue, so that
+// polls do not pay for a query when nothing is pending. Claims that another
+// instance left pending are verified once that instance polls again.
+func (s *Service) sweepVerifications(ctx context.Context, settings *claimSettings) error {
+	if s.config.VerifyDelay <= 0 {
+		return nil
+	}
+	now := time.Now()
+	since, before := now.AddDate(0, 0, -verifySweepDays), now.Add(-verifySweepAfter*s.config.VerifyDelay)
+	if !s.verifications.sweepDue(since, before) {
+		return nil
+	}
+	pending, err := s.pendingClaims(ctx, since, before)
+	if err != nil {
+		return fmt.Errorf("failed to load pending claims: %v", err)
+	}
+	if len(pending) == 0 {
+		s.verifications.setSwept()
+		return nil
+	}
+	scheduled, err := fetchSchedule(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
+	if err != nil {
+		return err
+	}
+	var verified, missing []ClaimingResult
+	for _, result := range pending {
+		schID, _ := strconv.Atoi(result.ShiftID)
+		if scheduled[schID] {
+			verified = append(verified, result)
+		} else {
+			missing = append(missing, result)
+		}
+	}
+	s.markVerifications(ctx, verified, VerificationVerified)
+	unverified := s.markVerifications(ctx, missing, VerificationUnverified)
+	s.verifications.setSwept()
+	if len(unverified) > 0 {
+		s.alertUnverified(ctx, unverified)
+	}
+	return nil
+}
+
+// pendingClaims returns the claims still pending verification that were made
+// between since and before. Claims are filtered by time here so that the
+// query needs no composite index.
+func (s *Service) pendingClaims(ctx context.Context, since, before time.Time) ([]ClaimingResult, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	var pending []ClaimingResult
+	iter := s.firestoreClient.Collection("claims").
+		Where("verification", "==", VerificationPending).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return pending, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := doc.Data()
+		result := ClaimingResult{ClaimID: doc.Ref.ID}
+		result.Timestamp, _ = data["timestamp"].(time.Time)
+		if result.Timestamp.Before(since) || !result.Timestamp.Before(before) {
+			continue
+		}
+		result.ShiftID, _ = data["shiftId"].(string)
+		result.Hours, _ = data["hours"].(float64)
+		result.Week, _ = data["week"].(string)
+		result.Campaign, _ = data["campaign"].(string)
+		pending = append(pending, result)
+	}
+}
+
+// markVerifications records the verification of claims and returns those
+// that were still pending it. Claims that could not be marked stay pending
+// for a later poll to verify.
+func (s *Service) markVerifications(ctx context.Context, results []ClaimingResult, verification string) []ClaimingResult {
+	var marked []ClaimingResult
+	for _, result := range results {
+		ok, err := s.markVerification(ctx, result, verification)
+		if err != nil {
+			fmt.Printf(`{"message": "Failed to record claim verification", "claim_id": "%s", "verification": "%s", "error": "%v", "severity": "error"}`+"\n", result.ClaimID, verification, err)
+			continue
+		}
+		s.verifications.done(result.ClaimID)
+		if ok {
+			marked = append(marked, result)
+		}
+	}
+	return marked
+}
+
+// markVerification moves a claim from pending to the given verification and
+// reports whether it was still pending. It is not fenced with the poller lease
+// since the poll that made the claim may be over.
+//
+// An unverified claim gives its campaign its share back in the same
+// transaction, so that a claim verified both in the background and by a poll
+// is refunded only once. Unverified claims no longer count toward the weekly
+// hours either, see claimedWeeklyHours. A campaign the claim completed is
+// reopened unless its deadline has passed, but claiming stays stopped if it
+// was stopped for it.
+func (s *Service) markVerification(ctx context.Context, result ClaimingResult, verification string) (bool, error) {
+	claimDoc := s.firestoreClient.Collection("claims").Doc(result.ClaimID)
+	var campaignDoc *firestore.DocumentRef
+	if verification == VerificationUnverified && result.Campaign != "" {
+		campaignDoc = s.firestoreClient.Collection("campaigns").Doc(result.Campaign)
+	}
+	now := time.Now()
+	var marked bool
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	err := s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		marked = false
+		snap, err := tx.Get(claimDoc)
+		if err != nil {
+			return err
+		}
+		if current, _ := snap.Data()["verification"].(string); current != VerificationPending {
+			return nil
+		}
+		var campaign Campaign
+		if campaignDoc != nil {
+			snap, err := tx.Get(campaignDoc)
+			if err != nil {
+				return err
+			}
+			if err := snap.DataTo(&campaign); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Update(claimDoc, []firestore.Update{
+			{Path: "verification", Value: verification},
+			{Path: "verifiedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		if campaignDoc != nil {
+			if err := tx.Update(campaignDoc, campaign.refund(result.Hours, now)); err != nil {
+				return err
+			}
+		}
+		marked = true
+		return nil
+	})
+	if err != nil || !marked {
+		return false, err
+	}
+	metrics.ClaimVerificationsTotal.WithLabelValues(verification).Inc()
+	s.events.Publish(EventClaimVerified, map[string]interface{}{
+		"claim_id":     result.ClaimID,
+		"shift_id":     result.ShiftID,
+		"verification": verification,
+	})
+	return true, nil
+}
+
+// alertUnverified tells the user about claims the portal accepted but that
+// are not on their schedule, so that they can check the shifts themselves.
+func (s *Service) alertUnverified(ctx context.Context, unverified []ClaimingResult) {
+	shiftIDs := make([]string, len(unverified))
+	lines := make([]string, len(unverified))
+	for i, result := range unverified {
+		shiftIDs[i] = result.ShiftID
+		lines[i] = fmt.Sprintf("Shift %s (%.1fh, week of %s) was claimed at %s but is not on the schedule", result.ShiftID, result.Hours, result.Week, result.Timestamp.Format(time.RFC3339))
+	}
+	shiftIDsJSON, _ := json.Marshal(shiftIDs)
+	fmt.Printf(`{"message": "Claimed shifts are not on the schedule", "shift_ids": %s, "severity": "alert"}`+"\n", shiftIDsJSON)
+	if err := s.notifyClient.Send(ctx, "Claim not on schedule", strings.Join(lines, "\n"), map[string]interface{}{"shift_ids": shiftIDs}); err != nil {
+		fmt.Printf(`{"message": "Failed to send notification", "error": "%v", "severity": "warning"}`+"\n", err)
+	}
+}
+
+// fetchSchedule returns the SchIds of the shifts on the user's schedule over
+// the same dates as the swapboard.
+func fetchSchedule(ctx context.Context, client *portal.Client, cookie, xAPIToken, shiftStartDate, shiftRange string) (map[int]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", client.ScheduleURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Cookie", cookie)
+	req.Header.Set("X-API-Token", xAPIToken)
+	q := req.URL.Query()
+	q.Add("date", shiftStartDate)
+	q.Add("range", shiftRange)
+	req.URL.RawQuery = q.Encode()
+	resp, err := client.Do(portal.EndpointSchedule, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schedule: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch schedule: %s", truncate(string(body), maxDiagnosticBody))
+	}
+	return decodeSchedule(body)
+}
+
+// decodeSchedule decodes the SchIds of a schedule response, accepting them as
+// numbers or strings like the swapboard's. Only the SchId is decoded, so that
+// a shift is not missed over a field the claim does not need.
+func decodeSchedule(body []byte) (map[int]bool, error) {
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule: %v", err)
+	}
+	scheduled := make(map[int]bool, len(records))
+	for _, record := range records {
+		var schID int
+		if value, ok := record["SchId"]; ok && decodeField(value, &schID) == nil && schID != 0 {
+			scheduled[schID] = true
+		}
+	}
+	return scheduled, nil
+}
@@ -0,0 +1,159 @@
+package shiftclaiming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[int]bool
+		wantErr string
+	}{
+		{"numbers", `[{"SchId": 1234}, {"SchId": 5678}]`, map[int]bool{1234: true, 5678: true}, ""},
+		{"strings", `[{"SchId": "1234"}, {"SchId": " 5678 "}]`, map[int]bool{1234: true, 5678: true}, ""},
+		{"other fields ignored", `[{"SchId": 1234, "Hours": "soon", "Date": null}]`, map[int]bool{1234: true}, ""},
+		{"bad or missing SchId skipped", `[{"SchId": 1234}, {"SchId": "soon"}, {"SchId": 0}, {"Id": 7}]`, map[int]bool{1234: true}, ""},
+		{"empty schedule", `[]`, map[int]bool{}, ""},
+		{"not a list", `{"error": "maintenance"}`, nil, "failed to parse schedule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduled, err := decodeSchedule([]byte(tt.body))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, scheduled)
+		})
+	}
+}
+
+func TestSweepVerifications(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		schedule string
+		want     map[string]string
+	}{
+		{"schedule read", `[{"SchId": 1234}]`, map[string]string{"old-on": VerificationVerified, "old-off": VerificationUnverified}},
+		{"schedule unavailable", "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, store := newPollService(t, nil, 0)
+			s.config.VerifyDelay = time.Second
+			if tt.schedule != "" {
+				fake.schedule = []byte(tt.schedule)
+			}
+			store.seed("claims/old-on", map[string]interface{}{"timestamp": now.Add(-time.Hour), "shiftId": "1234", "verification": VerificationPending})
+			store.seed("claims/old-off", map[string]interface{}{"timestamp": now.Add(-time.Hour), "shiftId": "5678", "verification": VerificationPending})
+			// Too old for the schedule to list
+			store.seed("claims/stale", map[string]interface{}{"timestamp": now.AddDate(0, 0, -verifySweepDays-1), "shiftId": "5678", "verification": VerificationPending})
+			// Still within the verification running in the background
+			store.seed("claims/recent", map[string]interface{}{"timestamp": now, "shiftId": "1234", "verification": VerificationPending})
+
+			settings, err := s.loadClaimSettings(context.Background())
+			require.NoError(t, err)
+			err = s.sweepVerifications(context.Background(), settings)
+			if tt.schedule == "" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			sub, backlog := s.events.Subscribe(0, []string{EventClaimVerified})
+			defer s.events.Unsubscribe(sub)
+			verifications := map[string]string{}
+			for _, event := range backlog {
+				data := event.Data.(map[string]interface{})
+				verifications[data["claim_id"].(string)] = data["verification"].(string)
+			}
+			assert.Equal(t, tt.want, verifications)
+		})
+	}
+}
+
+func TestSweepVerificationsOnlyWhenPending(t *testing.T) {
+	now := time.Now()
+	s, fake, store := newPollService(t, nil, 0)
+	s.config.VerifyDelay = time.Second
+	fake.schedule = []byte(`[{"SchId": 1234}]`)
+	settings, err := s.loadClaimSettings(context.Background())
+	require.NoError(t, err)
+	sweep := func() []string {
+		sub, _ := s.events.Subscribe(0, []string{EventClaimVerified})
+		defer s.events.Unsubscribe(sub)
+		require.NoError(t, s.sweepVerifications(context.Background(), settings))
+		var claimIDs []string
+		for len(sub.C) > 0 {
+			event := <-sub.C
+			claimIDs = append(claimIDs, event.Data.(map[string]interface{})["claim_id"].(string))
+		}
+		return claimIDs
+	}
+
+	// The first poll verifies what earlier instances left pending
+	store.seed("claims/earlier", map[string]interface{}{"timestamp": now.Add(-time.Hour), "shiftId": "1234", "verification": VerificationPending})
+	assert.Equal(t, []string{"earlier"}, sweep())
+	// The fake store does not apply writes, so a second query would find the
+	// claim again
+	assert.Empty(t, sweep())
+
+	// A claim of this instance that is not due yet is left to the
+	// verification in the background
+	store.seed("claims/own", map[string]interface{}{"timestamp": now.Add(-time.Hour), "shiftId": "1234", "verification": VerificationPending})
+	s.verifications.add([]ClaimingResult{{ClaimID: "own", Timestamp: now}})
+	assert.Empty(t, sweep())
+	s.verifications.add([]ClaimingResult{{ClaimID: "own", Timestamp: now.Add(-time.Hour)}})
+	assert.ElementsMatch(t, []string{"earlier", "own"}, sweep())
+	assert.Empty(t, sweep())
+}
+
+func TestClaimedWeeklyHoursLeavesOutUnverified(t *testing.T) {
+	s, _, store := newPollService(t, nil, 0)
+	store.seed("claims/verified", map[string]interface{}{"week": "2024-W19", "hours": 8.0, "claimingStatus": "success", "verification": VerificationVerified})
+	store.seed("claims/pending", map[string]interface{}{"week": "2024-W19", "hours": 6.0, "claimingStatus": "success", "verification": VerificationPending})
+	store.seed("claims/unverified", map[string]interface{}{"week": "2024-W19", "hours": 7.5, "claimingStatus": "success", "verification": VerificationUnverified})
+	store.seed("claims/failed", map[string]interface{}{"week": "2024-W19", "hours": 4.0, "claimingStatus": "failure"})
+
+	weeklyHours, err := s.claimedWeeklyHours(context.Background(), "2024-W19")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"2024-W19": 14}, weeklyHours)
+}
+
+func TestMarkVerification(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      string
+		verification string
+		campaign     string
+		wantMarked   bool
+		wantWritten  []string
+	}{
+		{"verified", VerificationPending, VerificationVerified, "c1", true, []string{"claims/claim"}},
+		{"unverified refunds campaign", VerificationPending, VerificationUnverified, "c1", true, []string{"claims/claim", "campaigns/c1"}},
+		{"unverified without campaign", VerificationPending, VerificationUnverified, "", true, []string{"claims/claim"}},
+		{"already unverified is not refunded again", VerificationUnverified, VerificationUnverified, "c1", false, nil},
+		{"already verified", VerificationVerified, VerificationUnverified, "c1", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, store := newPollService(t, nil, 0)
+			store.seed("claims/claim", map[string]interface{}{"shiftId": "1234", "verification": tt.current})
+			store.seed("campaigns/c1", map[string]interface{}{"status": CampaignActive, "targetShifts": 2, "claimedShifts": 1, "claimedHours": 8.0})
+
+			marked, err := s.markVerification(context.Background(), ClaimingResult{ClaimID: "claim", ShiftID: "1234", Hours: 8, Campaign: tt.campaign}, tt.verification)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMarked, marked)
+			assert.Equal(t, tt.wantWritten, store.writtenDocs())
+		})
+	}
+}
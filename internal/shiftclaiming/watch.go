@@ -0,0 +1,304 @@
+package shiftclaiming
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInvalidClaimLink = errors.New("invalid or expired claim link")
+	ErrShiftNotOnBoard  = errors.New("shift is no longer on the swapboard")
+	ErrPollRunning      = errors.New("a poll is running, try again shortly")
+	ErrShiftClosed      = errors.New("shift is closed to claims")
+	ErrClaimingHalted   = errors.New("claiming is halted")
+)
+
+// notifyShifts sends one notification per shift matched by a notify rule. A
+// shift is only announced once, however many polls see it, and is recorded as
+// announced once the notification was sent, so that a failed send is retried
+// by the next poll.
+func (s *Service) notifyShifts(ctx context.Context, shifts []ScoredShift, settings *claimSettings) {
+	for _, shift := range shifts {
+		action, reason := evaluateShift(shift.Shift, settings)
+		if action != RuleActionNotify {
+			continue
+		}
+		doc := s.firestoreClient.Collection("notifications").Doc(strconv.Itoa(shift.SchId))
+		storeCtx, cancel := s.storeContext(ctx)
+		_, err := doc.Get(storeCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.NotFound {
+			fmt.Printf(`{"message": "Failed to read notification record", "shift_id": %d, "error": "%v", "severity": "error"}`+"\n", shift.SchId, err)
+			continue
+		}
+
+		text := fmt.Sprintf("%s on %s, %s-%s (%.2fh), group %s. Shift %d %s.",
+			shift.StnName, shift.Date, shift.Start, shift.End, shift.Hours, shift.ShiftGroup, shift.SchId, reason)
+		fields := map[string]interface{}{
+			"schId":      shift.SchId,
+			"station":    shift.StnName,
+			"date":       shift.Date,
+			"start":      shift.Start,
+			"end":        shift.End,
+			"hours":      shift.Hours,
+			"shiftGroup": shift.ShiftGroup,
+		}
+		if link := s.claimLinkURL(shift.SchId, time.Now()); link != "" {
+			text += "\nClaim it: " + link
+			fields["claimLink"] = link
+		}
+		if err := s.notifyClient.Send(ctx, "Shift available", text, fields); err != nil {
+			fmt.Printf(`{"message": "Failed to send notification", "shift_id": %d, "error": "%v", "severity": "error"}`+"\n", shift.SchId, err)
+			continue
+		}
+
+		storeCtx, cancel = s.storeContext(ctx)
+		_, err = doc.Set(storeCtx, map[string]interface{}{
+			"timestamp": time.Now(),
+			"schId":     shift.SchId,
+			"reason":    reason,
+		})
+		cancel()
+		if err != nil {
+			fmt.Printf(`{"message": "Failed to record notification", "shift_id": %d, "error": "%v", "severity": "error"}`+"\n", shift.SchId, err)
+		}
+	}
+}
+
+// claimLink is a claim link whose signature and expiry have been checked.
+type claimLink struct {
+	schID   int
+	expires int64
+	// nonce makes the link single-use
+	nonce string
+	sig   string
+}
+
+// claimLinkURL returns a signed link to the confirmation page of a shift's
+// claim, or an empty string when no signing secret is configured. Opening the
+// link claims nothing, so that link previews and mail scanners cannot claim
+// shifts.
+func (s *Service) claimLinkURL(schID int, now time.Time) string {
+	if s.config.ClaimLinkSecret == "" {
+		return ""
+	}
+	expires := now.Add(s.config.ClaimLinkTTL).Unix()
+	nonce := newRequestID()
+	q := url.Values{}
+	q.Set("schid", strconv.Itoa(schID))
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	q.Set("n", nonce)
+	q.Set("sig", signClaimLink(s.config.ClaimLinkSecret, schID, expires, nonce))
+	return s.config.ServiceURL + "/claim/link?" + q.Encode()
+}
+
+func signClaimLink(secret string, schID int, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%d:%s", schID, expires, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyClaimLink checks the signature and expiry of a claim link. Whether the
+// link was already used is only known once it is spent.
+func (s *Service) verifyClaimLink(q url.Values, now time.Time) (claimLink, error) {
+	if s.config.ClaimLinkSecret == "" {
+		return claimLink{}, ErrInvalidClaimLink
+	}
+	schID, err := strconv.Atoi(q.Get("schid"))
+	if err != nil {
+		return claimLink{}, ErrInvalidClaimLink
+	}
+	expires, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return claimLink{}, ErrInvalidClaimLink
+	}
+	nonce := q.Get("n")
+	if nonce == "" {
+		return claimLink{}, ErrInvalidClaimLink
+	}
+	expected := signClaimLink(s.config.ClaimLinkSecret, schID, expires, nonce)
+	if !hmac.Equal([]byte(expected), []byte(q.Get("sig"))) {
+		return claimLink{}, ErrInvalidClaimLink
+	}
+	return claimLink{schID: schID, expires: expires, nonce: nonce, sig: q.Get("sig")}, nil
+}
+
+// spendClaimLink marks a claim link as used, failing if it already was. The
+// records carry the link's expiry so that a TTL policy can remove them.
+func (s *Service) spendClaimLink(ctx context.Context, link claimLink) error {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	_, err := s.firestoreClient.Collection("claim_links").Doc(link.nonce).Create(ctx, map[string]interface{}{
+		"timestamp": time.Now(),
+		"schId":     link.schID,
+		"expiresAt": time.Unix(link.expires, 0),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return fmt.Errorf("%w: the link has already been used", ErrInvalidClaimLink)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record claim link use: %v", err)
+	}
+	return nil
+}
+
+// ClaimLinkedShift claims the shift of a verified one-click link if it is
+// still on the swapboard. Filter rules are bypassed since a person chose the
+// shift, but the weekly hours cap and conflict checks still apply, and like a
+// poll it claims neither a posting closed by an earlier permanent failure nor
+// anything while the last poll found the session expired or the portal asking
+// to wait. The claim holds the poller lease so that no poll claims alongside it, and the link is
+// only spent right before the claim is sent, so that it can be used again
+// after any failure up to then.
+func (s *Service) ClaimLinkedShift(ctx context.Context, link claimLink, origin Origin) (claimed *ClaimingResult, err error) {
+	schID := link.schID
+	defer func() {
+		var after interface{}
+		if claimed != nil {
+			after = map[string]interface{}{"claimingStatus": claimed.ClaimingStatus, "campaign": claimed.Campaign}
+		}
+		s.audit(ctx, origin, AuditClaimLinkedShift, fmt.Sprintf("shifts/%d", schID), nil, after, err)
+	}()
+	fmt.Printf(`{"message": "Claiming linked shift...", "shift_id": %d, "severity": "info"}`+"\n", schID)
+
+	held, release, err := s.holdPollerLease(ctx, origin)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	settings, err := s.loadClaimSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := s.haltReason(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return nil, fmt.Errorf("%w: %s", ErrClaimingHalted, reason)
+	}
+	budget, err := s.newClaimBudget(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	availableShifts, err := fetchAvailableShifts(ctx, s.portalClient, settings.cookie, settings.xAPIToken, settings.shiftStartDate, settings.shiftRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch available shifts: %v", err)
+	}
+	for _, shift := range availableShifts {
+		if shift.SchId != schID {
+			continue
+		}
+		if reason := settings.closedReason(shift); reason != "" {
+			return nil, fmt.Errorf("%w: %s", ErrShiftClosed, reason)
+		}
+		if reason := budget.check(shift); reason != "" {
+			return nil, fmt.Errorf("shift %d cannot be claimed: %s", schID, reason)
+		}
+		checkCtx, cancel := s.storeContext(ctx)
+		err := s.leases.Check(checkCtx, held)
+		cancel()
+		if errors.Is(err, lease.ErrLost) {
+			return nil, ErrPollRunning
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check poller lease: %v", err)
+		}
+		if err := s.spendClaimLink(ctx, link); err != nil {
+			return nil, err
+		}
+		result, err := claimShift(ctx, s.portalClient, ScoredShift{Shift: shift}, "link", settings)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim shift: %v", err)
+		}
+		if result.ClaimingStatus == "success" {
+			result.Campaign = budget.reserve(shift)
+		}
+		claimingResults := []ClaimingResult{result}
+		if err := s.recordClaims(ctx, claimingResults); err != nil {
+			fmt.Printf(`{"message": "Failed to record claims", "error": "%v", "severity": "error"}`+"\n", err)
+		}
+		s.verifyClaims(claimingResults, settings)
+		return &claimingResults[0], nil
+	}
+	return nil, ErrShiftNotOnBoard
+}
+
+// haltReason returns why no claim can succeed now, going by the poller state
+// the last poll left, or an empty string when claims may be sent.
+func (s *Service) haltReason(ctx context.Context, now time.Time) (string, error) {
+	ctx, cancel := s.storeContext(ctx)
+	defer cancel()
+	stateDocSnap, err := s.firestoreClient.Collection("state").Doc("poller").Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve poller state: %v", err)
+	}
+	return pollerHaltReason(stateDocSnap.Data(), now), nil
+}
+
+// pollerHaltReason reads the halting outcome of the last poll from the poller
+// state: an expired session, until the credentials are updated, or a portal
+// cooldown or request to wait, until the next poll is due.
+func pollerHaltReason(stateData map[string]interface{}, now time.Time) string {
+	if credentialsValid, ok := stateData["credentialsValid"].(bool); ok && !credentialsValid {
+		return "the portal session expired, sign in again"
+	}
+	if cooldownUntil := timeField(stateData, "cooldownUntil"); cooldownUntil != nil && now.Before(*cooldownUntil) {
+		return fmt.Sprintf("the portal is cooling down until %s", cooldownUntil.Format(time.RFC3339))
+	}
+	if result, _ := stateData["lastPollResult"].(string); result == PollPleaseWait {
+		if nextPollAt := timeField(stateData, "nextPollAt"); nextPollAt != nil && now.Before(*nextPollAt) {
+			return fmt.Sprintf("the portal asked to wait until %s", nextPollAt.Format(time.RFC3339))
+		}
+	}
+	return ""
+}
+
+// holdPollerLease takes the poller lease for a claim made outside of a poll
+// and returns a function that gives it up. The lease of this instance's poll
+// loop is borrowed instead, between two of its polls.
+func (s *Service) holdPollerLease(ctx context.Context, origin Origin) (*lease.Lease, func(), error) {
+	s.loop.mu.Lock()
+	loopCtx := s.loop.ctx
+	s.loop.mu.Unlock()
+	if loopCtx != nil {
+		s.loopPolls.Lock()
+		if loopCtx.Err() == nil {
+			return pollLease(loopCtx), s.loopPolls.Unlock, nil
+		}
+		s.loopPolls.Unlock()
+	}
+
+	held, err := s.leases.Acquire(ctx, pollerLease, s.instanceID+"/"+origin.RequestID, s.config.LeaseTTL)
+	if errors.Is(err, lease.ErrHeld) {
+		return nil, nil, ErrPollRunning
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire poller lease: %v", err)
+	}
+	return held, func() {
+		releaseCtx, cancel := s.storeContext(context.WithoutCancel(ctx))
+		defer cancel()
+		if err := s.leases.Release(releaseCtx, held); err != nil {
+			fmt.Printf(`{"message": "Failed to release poller lease", "error": "%v", "severity": "warning"}`+"\n", err)
+		}
+	}, nil
+}
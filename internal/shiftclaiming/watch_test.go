@@ -0,0 +1,212 @@
+package shiftclaiming
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yesaswi/shift-claiming-automation/internal/lease"
+	"github.com/yesaswi/shift-claiming-automation/pkg/config"
+)
+
+func TestVerifyClaimLink(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	s := &Service{config: &config.Config{
+		ClaimLinkSecret: "secret",
+		ClaimLinkTTL:    time.Hour,
+		ServiceURL:      "https://example.com",
+	}}
+	issued, err := url.Parse(s.claimLinkURL(4242, now))
+	require.NoError(t, err)
+	assert.Equal(t, "/claim/link", issued.Path)
+
+	with := func(name, value string) url.Values {
+		q := url.Values{}
+		for k, v := range issued.Query() {
+			q[k] = v
+		}
+		if value == "" {
+			q.Del(name)
+		} else {
+			q.Set(name, value)
+		}
+		return q
+	}
+	tests := []struct {
+		name    string
+		query   url.Values
+		at      time.Time
+		wantErr bool
+	}{
+		{"valid", issued.Query(), now, false},
+		{"at expiry", issued.Query(), now.Add(time.Hour), false},
+		{"expired", issued.Query(), now.Add(time.Hour + time.Second), true},
+		{"other shift", with("schid", "4243"), now, true},
+		{"extended expiry", with("exp", strconv.FormatInt(now.Add(24*time.Hour).Unix(), 10)), now, true},
+		{"other nonce", with("n", "0000"), now, true},
+		{"missing nonce", with("n", ""), now, true},
+		{"bad signature", with("sig", "00"), now, true},
+		{"missing signature", with("sig", ""), now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := s.verifyClaimLink(tt.query, tt.at)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidClaimLink)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 4242, link.schID)
+			assert.Equal(t, issued.Query().Get("n"), link.nonce)
+		})
+	}
+}
+
+func TestClaimLinkNonces(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	s := &Service{config: &config.Config{ClaimLinkSecret: "secret", ClaimLinkTTL: time.Hour}}
+	first, err := url.Parse(s.claimLinkURL(1, now))
+	require.NoError(t, err)
+	second, err := url.Parse(s.claimLinkURL(1, now))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Query().Get("n"), second.Query().Get("n"))
+}
+
+func TestClaimLinkWithoutSecret(t *testing.T) {
+	s := &Service{config: &config.Config{}}
+	assert.Empty(t, s.claimLinkURL(1, time.Now()))
+	_, err := s.verifyClaimLink(url.Values{"schid": {"1"}}, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidClaimLink)
+}
+
+func TestHoldPollerLease(t *testing.T) {
+	ctx := context.Background()
+	newService := func() *Service {
+		return &Service{
+			config:     &config.Config{LeaseTTL: time.Minute, StoreTimeout: time.Second},
+			leases:     lease.NewMemory(),
+			instanceID: "instance",
+		}
+	}
+	origin := Origin{RequestID: "request"}
+
+	t.Run("free lease is taken and given back", func(t *testing.T) {
+		s := newService()
+		held, release, err := s.holdPollerLease(ctx, origin)
+		require.NoError(t, err)
+		assert.Equal(t, "instance/request", held.Holder)
+		_, _, err = s.holdPollerLease(ctx, Origin{RequestID: "other"})
+		assert.ErrorIs(t, err, ErrPollRunning)
+		release()
+		current, err := s.leases.Current(ctx, pollerLease)
+		require.NoError(t, err)
+		assert.Nil(t, current)
+	})
+
+	t.Run("poll of another instance", func(t *testing.T) {
+		s := newService()
+		_, err := s.leases.Acquire(ctx, pollerLease, "other-instance", time.Minute)
+		require.NoError(t, err)
+		_, _, err = s.holdPollerLease(ctx, origin)
+		assert.ErrorIs(t, err, ErrPollRunning)
+	})
+
+	t.Run("lease of this instance's loop is borrowed between polls", func(t *testing.T) {
+		s := newService()
+		loopLease, err := s.leases.Acquire(ctx, pollerLease, "instance", time.Minute)
+		require.NoError(t, err)
+		loopCtx, cancel := context.WithCancel(withPollLease(ctx, loopLease))
+		defer cancel()
+		s.loop.ctx = loopCtx
+
+		s.loopPolls.Lock()
+		borrowed := make(chan *lease.Lease)
+		go func() {
+			held, release, err := s.holdPollerLease(ctx, origin)
+			assert.NoError(t, err)
+			release()
+			borrowed <- held
+		}()
+		select {
+		case <-borrowed:
+			t.Fatal("lease borrowed during a poll")
+		case <-time.After(20 * time.Millisecond):
+		}
+		s.loopPolls.Unlock()
+		assert.Equal(t, loopLease, <-borrowed)
+		// Giving the borrowed lease back leaves it with the loop
+		current, err := s.leases.Current(ctx, pollerLease)
+		require.NoError(t, err)
+		assert.Equal(t, loopLease.Token, current.Token)
+	})
+}
+
+func TestPollerHaltReason(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Minute)
+	tests := []struct {
+		name  string
+		state map[string]interface{}
+		want  string
+	}{
+		{"no poll yet", nil, ""},
+		{"polling", map[string]interface{}{"lastPollResult": PollOK, "credentialsValid": true, "nextPollAt": later}, ""},
+		{"session expired", map[string]interface{}{"lastPollResult": PollSessionTimeout, "credentialsValid": false}, "session expired"},
+		{"cooling down", map[string]interface{}{"lastPollResult": PollSwapListDisabled, "cooldownUntil": later}, "cooling down"},
+		{"cooldown over", map[string]interface{}{"lastPollResult": PollSwapListDisabled, "cooldownUntil": now.Add(-time.Minute)}, ""},
+		{"asked to wait", map[string]interface{}{"lastPollResult": PollPleaseWait, "nextPollAt": later}, "asked to wait"},
+		{"wait over", map[string]interface{}{"lastPollResult": PollPleaseWait, "nextPollAt": now}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := pollerHaltReason(tt.state, now)
+			if tt.want == "" {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimLinkedShift(t *testing.T) {
+	shift := benchBoard(1, time.Now().AddDate(0, 0, 1).Truncate(24*time.Hour))[0]
+	tests := []struct {
+		name      string
+		seed      map[string]map[string]interface{}
+		wantErr   error
+		wantClaim bool
+	}{
+		{"open posting", nil, nil, true},
+		{"closed posting", map[string]map[string]interface{}{
+			"closed_shifts/" + shiftKey(shift): {"timestamp": time.Now(), "outcome": ClaimNotEligible},
+		}, ErrShiftClosed, false},
+		{"session expired", map[string]map[string]interface{}{
+			"state/poller": {"lastPollResult": PollSessionTimeout, "credentialsValid": false},
+		}, ErrClaimingHalted, false},
+		{"portal cooling down", map[string]map[string]interface{}{
+			"state/poller": {"lastPollResult": PollSwapListDisabled, "cooldownUntil": time.Now().Add(time.Hour)},
+		}, ErrClaimingHalted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, store := newPollService(t, []Shift{shift}, 0)
+			for path, fields := range tt.seed {
+				store.seed(path, fields)
+			}
+
+			result, err := s.ClaimLinkedShift(context.Background(), claimLink{schID: shift.SchId, nonce: "nonce"}, Origin{RequestID: "request"})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "success", result.ClaimingStatus)
+			}
+			assert.Equal(t, tt.wantClaim, fake.firstClaim() >= 0, "claim sent")
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package shiftclaiming
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activeWindow is a recurring period during which polling is allowed.
+type activeWindow interface {
+	contains(t time.Time) bool
+	// nextStart returns the first window start strictly after t.
+	nextStart(t time.Time) time.Time
+}
+
+// activeSchedule is the set of active windows in the configured time zone. A
+// schedule without windows is always active.
+type activeSchedule struct {
+	location *time.Location
+	windows  []activeWindow
+}
+
+func (a *activeSchedule) active(t time.Time) bool {
+	if a == nil || len(a.windows) == 0 {
+		return true
+	}
+	t = t.In(a.location)
+	for _, window := range a.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns t if it falls in an active window, otherwise the start of the
+// next window.
+func (a *activeSchedule) next(t time.Time) time.Time {
+	if a.active(t) {
+		return t
+	}
+	t = t.In(a.location)
+	var next time.Time
+	for _, window := range a.windows {
+		start := window.nextStart(t)
+		if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
+
+// newActiveSchedule parses the 'activeWindows' and 'timeZone' fields of the
+// configuration document. Windows are either weekly ranges such as
+// "Mon-Fri 06:00-09:00" or cron expressions with a duration such as
+// "cron 0 6 * * 1-5 3h".
+func newActiveSchedule(configData map[string]interface{}, defaultTimeZone string) (*activeSchedule, error) {
+	rawWindows, err := stringsFromConfig(configData["activeWindows"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'activeWindows' in configuration: %v", err)
+	}
+	timeZone, _ := configData["timeZone"].(string)
+	if timeZone == "" {
+		timeZone = defaultTimeZone
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'timeZone' in configuration: %v", err)
+	}
+	schedule := &activeSchedule{location: location}
+	for _, rawWindow := range rawWindows {
+		window, err := parseActiveWindow(rawWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid active window %q: %v", rawWindow, err)
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+	return schedule, nil
+}
+
+func parseActiveWindow(value string) (activeWindow, error) {
+	fields := strings.Fields(value)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "cron") {
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("expected \"cron <minute> <hour> <day> <month> <weekday> <duration>\"")
+		}
+		spec, err := parseCron(fields[1:6])
+		if err != nil {
+			return nil, err
+		}
+		duration, err := time.ParseDuration(fields[6])
+		if err != nil || duration <= 0 || duration > 24*time.Hour {
+			return nil, fmt.Errorf("duration must be between 1m and 24h")
+		}
+		return cronWindow{spec: spec, duration: duration}, nil
+	}
+	return parseWeeklyWindow(fields)
+}
+
+// weeklyWindow is a daily time range on selected weekdays. A range that ends
+// before it starts runs past midnight.
+type weeklyWindow struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+func parseWeeklyWindow(fields []string) (weeklyWindow, error) {
+	window := weeklyWindow{}
+	if len(fields) != 2 {
+		return window, fmt.Errorf("expected \"<days> <HH:MM>-<HH:MM>\"")
+	}
+	if fields[0] == "*" || strings.EqualFold(fields[0], "daily") {
+		for i := range window.days {
+			window.days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(fields[0], ",") {
+			bounds := strings.SplitN(part, "-", 2)
+			first, ok := parseWeekday(bounds[0])
+			if !ok {
+				return window, fmt.Errorf("unknown weekday %q", bounds[0])
+			}
+			last := first
+			if len(bounds) == 2 {
+				if last, ok = parseWeekday(bounds[1]); !ok {
+					return window, fmt.Errorf("unknown weekday %q", bounds[1])
+				}
+			}
+			for day := first; ; day = (day + 1) % 7 {
+				window.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		}
+	}
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return window, fmt.Errorf("expected a time range like 06:00-09:00")
+	}
+	var err error
+	if window.start, err = parseClock(times[0]); err != nil {
+		return window, err
+	}
+	if window.end, err = parseClock(times[1]); err != nil {
+		return window, err
+	}
+	if window.start == window.end {
+		return window, fmt.Errorf("time range is empty")
+	}
+	return window, nil
+}
+
+func (w weeklyWindow) bounds(day time.Time) (time.Time, time.Time) {
+	start := atClock(day, 0, w.start)
+	end := atClock(day, 0, w.end)
+	if w.end <= w.start {
+		end = atClock(day, 1, w.end)
+	}
+	return start, end
+}
+
+// atClock returns the wall clock time on the given day, which stays correct
+// across daylight saving changes unlike adding a duration to midnight.
+func atClock(day time.Time, dayOffset int, clock time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day()+dayOffset, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+func (w weeklyWindow) contains(t time.Time) bool {
+	// Check today's window and yesterday's, which may run past midnight
+	for offset := 0; offset >= -1; offset-- {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, t.Location())
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		start, end := w.bounds(day)
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w weeklyWindow) nextStart(t time.Time) time.Time {
+	for offset := 0; offset <= 7; offset++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, t.Location())
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		if start, _ := w.bounds(day); start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// cronWindow opens at every time matched by a five-field cron expression and
+// stays open for a fixed duration.
+type cronWindow struct {
+	spec     cronSpec
+	duration time.Duration
+}
+
+func (w cronWindow) contains(t time.Time) bool {
+	minute := t.Truncate(time.Minute)
+	for fire := minute; t.Sub(fire) < w.duration; fire = fire.Add(-time.Minute) {
+		if w.spec.matches(fire) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSearchYears bounds the search for the next start of a cron window,
+// which for a spec such as February 30 never comes.
+const cronSearchYears = 5
+
+// nextStart steps field by field, skipping a whole month, day or hour that
+// cannot match, rather than trying every minute.
+func (w cronWindow) nextStart(t time.Time) time.Time {
+	location := t.Location()
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	fire := t.Truncate(time.Minute).Add(time.Minute)
+	for fire.Before(limit) {
+		switch {
+		case !w.spec.months[int(fire.Month())]:
+			fire = later(fire, time.Date(fire.Year(), fire.Month()+1, 1, 0, 0, 0, 0, location))
+		case !w.spec.dayMatches(fire):
+			fire = later(fire, time.Date(fire.Year(), fire.Month(), fire.Day()+1, 0, 0, 0, 0, location))
+		case !w.spec.hours[fire.Hour()]:
+			fire = later(fire, time.Date(fire.Year(), fire.Month(), fire.Day(), fire.Hour()+1, 0, 0, 0, location))
+		case !w.spec.minutes[fire.Minute()]:
+			fire = fire.Add(time.Minute)
+		default:
+			return fire
+		}
+	}
+	return time.Time{}
+}
+
+// later returns next, or the next minute after t when a daylight saving change
+// has moved next to or before t.
+func later(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+type cronSpec struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyDay   bool
+	anyWeek  bool
+}
+
+func parseCron(fields []string) (cronSpec, error) {
+	var spec cronSpec
+	var err error
+	if spec.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return spec, fmt.Errorf("minute: %v", err)
+	}
+	if spec.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return spec, fmt.Errorf("hour: %v", err)
+	}
+	if spec.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return spec, fmt.Errorf("day of month: %v", err)
+	}
+	if spec.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return spec, fmt.Errorf("month: %v", err)
+	}
+	if spec.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return spec, fmt.Errorf("day of week: %v", err)
+	}
+	if spec.weekdays[7] {
+		spec.weekdays[0] = true
+	}
+	spec.anyDay = fields[2] == "*"
+	spec.anyWeek = fields[4] == "*"
+	return spec, nil
+}
+
+// parseCronField supports "*", single values, ranges, lists and steps.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("value out of range in %q", part)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (c cronSpec) matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+	return c.dayMatches(t)
+}
+
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dayMatch := c.days[t.Day()]
+	weekMatch := c.weekdays[int(t.Weekday())]
+	// Like cron, a restricted day of month and day of week match either one
+	if !c.anyDay && !c.anyWeek {
+		return dayMatch || weekMatch
+	}
+	return dayMatch && weekMatch
+}
@@ -0,0 +1,143 @@
+package shiftclaiming
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSchedule(t *testing.T, timeZone string, windows ...string) *activeSchedule {
+	t.Helper()
+	rawWindows := make([]interface{}, len(windows))
+	for i, window := range windows {
+		rawWindows[i] = window
+	}
+	schedule, err := newActiveSchedule(map[string]interface{}{"activeWindows": rawWindows, "timeZone": timeZone}, "UTC")
+	require.NoError(t, err)
+	return schedule
+}
+
+func TestActiveScheduleNext(t *testing.T) {
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return parsed
+	}
+	tests := []struct {
+		name    string
+		windows []string
+		at      string
+		want    string
+	}{
+		{"no windows is always active", nil, "2024-05-06 03:00", "2024-05-06 03:00"},
+		{"inside a weekly window", []string{"Mon-Fri 06:00-09:00"}, "2024-05-06 07:30", "2024-05-06 07:30"},
+		{"window end is exclusive", []string{"Mon-Fri 06:00-09:00"}, "2024-05-06 09:00", "2024-05-07 06:00"},
+		{"before today's window", []string{"Mon-Fri 06:00-09:00"}, "2024-05-06 05:59", "2024-05-06 06:00"},
+		{"over the weekend", []string{"Mon-Fri 06:00-09:00"}, "2024-05-10 10:00", "2024-05-13 06:00"},
+		{"overnight window from yesterday", []string{"Fri 22:00-02:00"}, "2024-05-11 01:00", "2024-05-11 01:00"},
+		{"overnight window has ended", []string{"Fri 22:00-02:00"}, "2024-05-11 02:00", "2024-05-17 22:00"},
+		{"wrapping weekday range", []string{"Sat-Mon 10:00-11:00"}, "2024-05-07 12:00", "2024-05-11 10:00"},
+		{"earliest of several windows", []string{"Wed 08:00-09:00", "daily 12:00-13:00"}, "2024-05-07 13:00", "2024-05-08 08:00"},
+		{"inside a cron window", []string{"cron 30 6 * * 1-5 2h"}, "2024-05-06 08:29", "2024-05-06 08:29"},
+		{"cron window end is exclusive", []string{"cron 30 6 * * 1-5 2h"}, "2024-05-06 08:30", "2024-05-07 06:30"},
+		{"cron day of month or weekday", []string{"cron 0 9 15 * 0 1h"}, "2024-05-06 10:00", "2024-05-12 09:00"},
+		{"cron steps", []string{"cron */20 */6 * * * 10m"}, "2024-05-06 06:50", "2024-05-06 12:00"},
+		{"cron in a later month", []string{"cron 0 0 1 1 * 1h"}, "2024-05-06 10:00", "2025-01-01 00:00"},
+		{"cron leap day", []string{"cron 0 0 29 2 * 1h"}, "2024-03-01 00:00", "2028-02-29 00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := newTestSchedule(t, "UTC", tt.windows...)
+			assert.Equal(t, utc(tt.want), schedule.next(utc(tt.at)).UTC())
+		})
+	}
+}
+
+func TestCronWindowNeverStarts(t *testing.T) {
+	schedule := newTestSchedule(t, "UTC", "cron 0 0 30 2 * 1h")
+	assert.True(t, schedule.next(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)).IsZero())
+}
+
+// TestCronWindowNextStartMatchesScan checks the field by field search against
+// trying every minute, including across daylight saving changes.
+func TestCronWindowNextStartMatchesScan(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	specs := []string{
+		"0 6 * * 1-5",
+		"*/15 2 * * *",
+		"30 1 * * 0",
+		"0 0 1,15 * *",
+		"0 12 * 3,11 6",
+		"59 23 31 * *",
+		"5 */7 10-12 * 2",
+	}
+	starts := []time.Time{
+		time.Date(2024, 3, 9, 23, 17, 0, 0, location),
+		time.Date(2024, 3, 10, 1, 59, 30, 0, location),
+		time.Date(2024, 11, 3, 0, 45, 0, 0, location),
+		time.Date(2024, 11, 3, 1, 30, 0, 0, location).Add(time.Hour),
+		time.Date(2024, 12, 31, 23, 59, 0, 0, location),
+	}
+	for _, spec := range specs {
+		cronSpec, err := parseCron(strings.Fields(spec))
+		require.NoError(t, err)
+		window := cronWindow{spec: cronSpec, duration: time.Hour}
+		for _, start := range starts {
+			assert.Equal(t, scanNextStart(window, start), window.nextStart(start), "%s after %s", spec, start)
+		}
+	}
+}
+
+func scanNextStart(w cronWindow, t time.Time) time.Time {
+	limit := t.AddDate(1, 0, 0)
+	for fire := t.Truncate(time.Minute).Add(time.Minute); fire.Before(limit); fire = fire.Add(time.Minute) {
+		if w.spec.matches(fire) {
+			return fire
+		}
+	}
+	return time.Time{}
+}
+
+func TestParseActiveWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{value: "Mon-Fri 06:00-09:00"},
+		{value: "daily 22:00-02:00"},
+		{value: "cron 0 6 * * 1-5 3h"},
+		{value: "Mon", wantErr: `expected "<days> <HH:MM>-<HH:MM>"`},
+		{value: "Mon 06:00", wantErr: "expected a time range like 06:00-09:00"},
+		{value: "Someday 06:00-09:00", wantErr: `unknown weekday "Someday"`},
+		{value: "Mon 06:00-06:00", wantErr: "time range is empty"},
+		{value: "Mon 6am-9am", wantErr: `invalid time "6am", expected HH:MM`},
+		{value: "cron 0 6 * * 1-5", wantErr: `expected "cron <minute> <hour> <day> <month> <weekday> <duration>"`},
+		{value: "cron 60 6 * * * 1h", wantErr: `minute: value out of range in "60"`},
+		{value: "cron 0 6 * * * 25h", wantErr: "duration must be between 1m and 24h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			_, err := parseActiveWindow(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func BenchmarkCronWindowNextStart(b *testing.B) {
+	spec, err := parseCron(strings.Fields("0 0 1 1 *"))
+	require.NoError(b, err)
+	window := cronWindow{spec: spec, duration: time.Hour}
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		window.nextStart(start)
+	}
+}
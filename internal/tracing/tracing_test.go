@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), ExporterNone, "test")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), "jaeger", "test")
+	assert.Error(t, err)
+}
+
+func TestInjectExtract(t *testing.T) {
+	_, err := Setup(context.Background(), ExporterNone, "test")
+	require.NoError(t, err)
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x0a, 0xf7, 0x65, 0x19},
+		SpanID:     trace.SpanID{0xb7, 0xad, 0x6b, 0x71},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	carrier := Inject(trace.ContextWithSpanContext(context.Background(), parent))
+	assert.Contains(t, carrier, "traceparent")
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+	assert.Equal(t, parent.TraceID(), extracted.TraceID())
+	assert.Equal(t, parent.SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+
+	// Without a trace context there is nothing to carry or continue
+	assert.Empty(t, Inject(context.Background()))
+	assert.False(t, trace.SpanContextFromContext(Extract(context.Background(), nil)).IsValid())
+}
+
+func TestEnd(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+
+	_, span := Tracer().Start(context.Background(), "ok")
+	End(span, nil)
+	_, span = Tracer().Start(context.Background(), "failed")
+	End(span, errors.New("unavailable"))
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+	assert.Equal(t, codes.Unset, ended[0].Status().Code)
+	assert.Empty(t, ended[0].Events())
+	assert.Equal(t, codes.Error, ended[1].Status().Code)
+	assert.Equal(t, "unavailable", ended[1].Status().Description)
+	assert.Len(t, ended[1].Events(), 1, "the error is recorded on the span")
+}
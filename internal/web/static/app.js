@@ -0,0 +1,165 @@
+"use strict";
+
+let status = null;
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "-";
+}
+
+function formatDuration(seconds) {
+  const total = Math.max(0, Math.round(seconds));
+  const minutes = Math.floor(total / 60);
+  return minutes + "m " + String(total % 60).padStart(2, "0") + "s";
+}
+
+function cell(row, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text === undefined || text === null ? "" : text;
+  if (className) {
+    td.className = className;
+  }
+  row.appendChild(td);
+}
+
+async function fetchJSON(url, options) {
+  const resp = await fetch(url, options);
+  if (!resp.ok) {
+    throw new Error((await resp.text()) || resp.statusText);
+  }
+  return resp.json();
+}
+
+async function loadStatus() {
+  try {
+    status = await fetchJSON("status");
+    status.loadedAt = Date.now();
+    renderStatus();
+  } catch (err) {
+    document.getElementById("toggle-message").textContent = err.message;
+  }
+}
+
+function renderStatus() {
+  const state = document.getElementById("poller-state");
+  state.textContent = status.poller_state + (status.dry_run ? " (dry run)" : "");
+  state.className = "badge " + status.poller_state;
+
+  document.getElementById("last-poll").textContent =
+    formatTime(status.last_poll_at) + (status.last_poll_result ? " - " + status.last_poll_result : "");
+  document.getElementById("next-poll").textContent = formatTime(status.next_poll_at);
+  document.getElementById("credentials").textContent =
+    status.credentials_valid === undefined ? "unknown" : status.credentials_valid ? "valid" : "expired, sign in again";
+  renderCooldown();
+
+  const toggle = document.getElementById("toggle");
+  toggle.disabled = false;
+  toggle.textContent = status.enabled ? "Stop claiming" : "Start claiming";
+  toggle.className = status.enabled ? "stop" : "";
+}
+
+function renderCooldown() {
+  const cooldown = document.getElementById("cooldown");
+  if (!status || !status.cooldown_until) {
+    cooldown.textContent = "-";
+    return;
+  }
+  const remaining = (new Date(status.cooldown_until).getTime() - Date.now()) / 1000;
+  cooldown.textContent = remaining > 0 ? formatDuration(remaining) + " remaining" : "-";
+}
+
+async function toggleClaiming() {
+  const toggle = document.getElementById("toggle");
+  const message = document.getElementById("toggle-message");
+  toggle.disabled = true;
+  message.textContent = "";
+  try {
+    const resp = await fetch(status.enabled ? "stop" : "start", { method: "POST" });
+    if (!resp.ok) {
+      throw new Error(await resp.text());
+    }
+  } catch (err) {
+    message.textContent = err.message;
+  }
+  await loadStatus();
+}
+
+async function loadBoard() {
+  const button = document.getElementById("refresh-board");
+  const message = document.getElementById("board-message");
+  const rows = document.getElementById("board-rows");
+  button.disabled = true;
+  message.textContent = "";
+  try {
+    const plan = await fetchJSON("plan");
+    rows.replaceChildren();
+    if (plan.shifts.length === 0) {
+      message.textContent = "No shifts on the board.";
+    }
+    for (const shift of plan.shifts) {
+      const row = document.createElement("tr");
+      cell(row, shift.Date.split("T")[0]);
+      cell(row, shift.Start + " - " + shift.End);
+      cell(row, shift.StnName);
+      cell(row, shift.ShiftGroup);
+      cell(row, shift.Hours);
+      cell(row, shift.score.toFixed(2));
+      cell(row, shift.action, "verdict-" + shift.action);
+      cell(row, shift.reason);
+      rows.appendChild(row);
+    }
+  } catch (err) {
+    message.textContent = err.message;
+  }
+  button.disabled = false;
+}
+
+async function loadClaims() {
+  try {
+    const page = await fetchJSON("claims?limit=15");
+    const rows = document.getElementById("claim-rows");
+    rows.replaceChildren();
+    for (const claim of page.items) {
+      const row = document.createElement("tr");
+      cell(row, formatTime(claim.timestamp));
+      cell(row, claim.shiftId);
+      cell(row, claim.claimingStatus, "status-" + claim.claimingStatus);
+      cell(row, claim.strategy);
+      cell(row, claim.hours);
+      rows.appendChild(row);
+    }
+  } catch (err) {
+    console.error("failed to load claims", err);
+  }
+}
+
+// debounce collapses the burst of replayed events sent when the stream
+// connects into a single reload.
+function debounce(fn, wait) {
+  let timer = null;
+  return () => {
+    clearTimeout(timer);
+    timer = setTimeout(fn, wait);
+  };
+}
+
+function listenForEvents() {
+  if (!window.EventSource) {
+    return;
+  }
+  const source = new EventSource("events/stream?types=poll,claim,cooldown,claiming_started,claiming_stopped");
+  const reloadStatus = debounce(loadStatus, 500);
+  const reloadClaims = debounce(loadClaims, 500);
+  for (const type of ["poll", "cooldown", "claiming_started", "claiming_stopped"]) {
+    source.addEventListener(type, reloadStatus);
+  }
+  source.addEventListener("claim", reloadClaims);
+}
+
+document.getElementById("toggle").addEventListener("click", toggleClaiming);
+document.getElementById("refresh-board").addEventListener("click", loadBoard);
+
+loadStatus();
+loadClaims();
+listenForEvents();
+setInterval(renderCooldown, 1000);
+setInterval(loadStatus, 30000);
@@ -0,0 +1,141 @@
+"use strict";
+
+const form = document.getElementById("config-form");
+const message = document.getElementById("form-message");
+
+function option(select, value, selected) {
+  const opt = document.createElement("option");
+  opt.value = value;
+  opt.textContent = value;
+  opt.selected = selected;
+  select.appendChild(opt);
+}
+
+function cell(row, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text === undefined || text === null ? "" : text;
+  if (className) {
+    td.className = className;
+  }
+  row.appendChild(td);
+}
+
+async function loadConfig() {
+  const resp = await fetch("config/shift");
+  if (!resp.ok) {
+    message.textContent = await resp.text();
+    return;
+  }
+  const view = await resp.json();
+  const config = view.config || {};
+  const options = view.options;
+
+  form.shift_start_date.value = config.shift_start_date || "";
+  for (const range of options.ranges) {
+    option(form.shift_range, range, range === config.shift_range);
+  }
+  for (const strategy of options.strategies) {
+    option(form.strategy, strategy, strategy === (config.strategy || "board"));
+  }
+  const preferredStations = config.preferred_stations || {};
+  const stationSelected = (station) =>
+    Array.isArray(preferredStations) ? preferredStations.includes(station) : station in preferredStations;
+  for (const station of options.stations) {
+    option(form.preferred_stations, station, stationSelected(station));
+  }
+
+  const selectedGroups = (config.shift_group || "").split(",").map((g) => g.trim());
+  const groups = document.getElementById("groups");
+  for (const group of options.groups) {
+    const label = document.createElement("label");
+    const box = document.createElement("input");
+    box.type = "checkbox";
+    box.name = "shift_group";
+    box.value = group;
+    box.checked = selectedGroups.includes(group);
+    label.append(box, " " + group);
+    groups.appendChild(label);
+  }
+
+  form.max_weekly_hours.value = config.max_weekly_hours || 0;
+  form.hours_weight.value = config.hours_weight || 0;
+  form.date_weight.value = config.date_weight || 0;
+  form.rules.value = JSON.stringify(config.rules || [], null, 2);
+}
+
+// collect builds the shift configuration document from the form.
+function collect() {
+  const config = {
+    shift_start_date: form.shift_start_date.value,
+    shift_range: form.shift_range.value,
+    shift_group: Array.from(form.querySelectorAll("input[name=shift_group]:checked")).map((b) => b.value).join(","),
+    strategy: form.strategy.value,
+  };
+  const stations = Array.from(form.preferred_stations.selectedOptions).map((o) => o.value);
+  if (stations.length > 0) {
+    config.preferred_stations = stations;
+  }
+  for (const field of ["max_weekly_hours", "hours_weight", "date_weight"]) {
+    const value = parseFloat(form[field].value);
+    if (value > 0) {
+      config[field] = value;
+    }
+  }
+  const rules = JSON.parse(form.rules.value || "[]");
+  if (rules.length > 0) {
+    config.rules = rules;
+  }
+  return config;
+}
+
+async function send(url, method) {
+  message.textContent = "";
+  let body;
+  try {
+    body = JSON.stringify(collect());
+  } catch (err) {
+    message.textContent = "Rules are not valid JSON: " + err.message;
+    return null;
+  }
+  const resp = await fetch(url, { method: method, headers: { "Content-Type": "application/json" }, body: body });
+  if (!resp.ok) {
+    message.textContent = await resp.text();
+    return null;
+  }
+  return resp;
+}
+
+async function preview() {
+  const resp = await send("config/shift/preview", "POST");
+  if (!resp) {
+    return;
+  }
+  const plan = await resp.json();
+  const rows = document.getElementById("preview-rows");
+  rows.replaceChildren();
+  for (const shift of plan.shifts) {
+    const row = document.createElement("tr");
+    cell(row, shift.Date.split("T")[0]);
+    cell(row, shift.Start + " - " + shift.End);
+    cell(row, shift.StnName);
+    cell(row, shift.ShiftGroup);
+    cell(row, shift.Hours);
+    cell(row, shift.score.toFixed(2));
+    cell(row, shift.action, "verdict-" + shift.action);
+    cell(row, shift.reason);
+    rows.appendChild(row);
+  }
+  if (plan.shifts.length === 0) {
+    message.textContent = "No shifts on the board right now.";
+  }
+}
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  if (await send("config/shift", "PUT")) {
+    message.textContent = "Saved.";
+  }
+});
+document.getElementById("preview").addEventListener("click", preview);
+
+loadConfig();
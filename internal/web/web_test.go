@@ -0,0 +1,56 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, path string) *http.Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Result()
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/", "text/html; charset=utf-8"},
+		{"/config.html", "text/html; charset=utf-8"},
+		{"/app.js", "text/javascript; charset=utf-8"},
+		{"/config.js", "text/javascript; charset=utf-8"},
+		{"/style.css", "text/css; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp := get(t, tt.path)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.NotEmpty(t, body)
+		})
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(t, "/missing.js").StatusCode)
+}
+
+// TestPageAssets checks that the assets the pages link to are embedded.
+func TestPageAssets(t *testing.T) {
+	links := regexp.MustCompile(`(?:src|href)="([^"]+)"`)
+	for _, page := range []string{"/", "/config.html"} {
+		body, err := io.ReadAll(get(t, page).Body)
+		require.NoError(t, err)
+		for _, match := range links.FindAllStringSubmatch(string(body), -1) {
+			assert.Equal(t, http.StatusOK, get(t, "/"+match[1]).StatusCode, "%s links to %s", page, match[1])
+		}
+	}
+}
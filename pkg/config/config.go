@@ -3,12 +3,37 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
-	Port              int
-	ProjectID         string
-	DatabaseID        string
+	Port                int
+	ProjectID           string
+	DatabaseID          string
+	ServiceURL          string
+	NotifyWebhookURL    string
+	ClaimLinkSecret     string
+	ClaimLinkTTL        time.Duration
+	TimeZone            string
+	TraceExporter       string
+	ClaimParallelism    int
+	PollerMode          string
+	LeaseTTL            time.Duration
+	HeartbeatInterval   time.Duration
+	LeaseStore          string
+	PortalTimeout       time.Duration
+	PortalProxyURL      string
+	PortalCAFile        string
+	PrewarmLead         time.Duration
+	StoreTimeout        time.Duration
+	TasksTimeout        time.Duration
+	ShutdownTimeout     time.Duration
+	SchedulePath        string
+	VerifyDelay         time.Duration
+	TasksServiceAccount string
+	AuthAudience        string
+	IAPAudience         string
+	TrustIngress        bool
 }
 
 func LoadConfig() (*Config, error) {
@@ -25,9 +50,106 @@ func LoadConfig() (*Config, error) {
 	if databaseID == "" {
 		databaseID = "autoclaimer-42-db"
 	}
+	serviceURL := os.Getenv("SERVICE_URL")
+	if serviceURL == "" {
+		serviceURL = "https://autoclaimer-h5km45tdpq-uk.a.run.app"
+	}
+	claimLinkTTL, err := time.ParseDuration(os.Getenv("CLAIM_LINK_TTL"))
+	if err != nil {
+		claimLinkTTL = 2 * time.Hour
+	}
+	timeZone := os.Getenv("TIME_ZONE")
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	traceExporter := os.Getenv("TRACE_EXPORTER")
+	if traceExporter == "" {
+		traceExporter = "none"
+	}
+	claimParallelism, err := strconv.Atoi(os.Getenv("CLAIM_PARALLELISM"))
+	if err != nil || claimParallelism <= 0 {
+		claimParallelism = 4
+	}
+	pollerMode := os.Getenv("POLLER_MODE")
+	if pollerMode == "" {
+		pollerMode = "tasks"
+	}
+	leaseTTL, err := time.ParseDuration(os.Getenv("LEASE_TTL"))
+	if err != nil {
+		leaseTTL = 30 * time.Second
+	}
+	heartbeatInterval, err := time.ParseDuration(os.Getenv("HEARTBEAT_INTERVAL"))
+	if err != nil {
+		heartbeatInterval = 2 * time.Minute
+	}
+	leaseStore := os.Getenv("LEASE_STORE")
+	if leaseStore == "" {
+		leaseStore = "firestore"
+	}
+	portalTimeout, err := time.ParseDuration(os.Getenv("PORTAL_TIMEOUT"))
+	if err != nil {
+		portalTimeout = 10 * time.Second
+	}
+	prewarmLead, err := time.ParseDuration(os.Getenv("PREWARM_LEAD"))
+	if err != nil {
+		prewarmLead = 20 * time.Second
+	}
+	storeTimeout, err := time.ParseDuration(os.Getenv("STORE_TIMEOUT"))
+	if err != nil {
+		storeTimeout = 10 * time.Second
+	}
+	tasksTimeout, err := time.ParseDuration(os.Getenv("TASKS_TIMEOUT"))
+	if err != nil {
+		tasksTimeout = 10 * time.Second
+	}
+	// Cloud Run gives an instance 10 seconds after SIGTERM
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil {
+		shutdownTimeout = 9 * time.Second
+	}
+	// A delay of 0 turns off the verification of claims against the schedule
+	verifyDelay, err := time.ParseDuration(os.Getenv("VERIFY_DELAY"))
+	if err != nil {
+		verifyDelay = 30 * time.Second
+	}
+	// ID tokens, including those Cloud Tasks sends as TASKS_SERVICE_ACCOUNT,
+	// are accepted for this audience. IAP assertions are only trusted when
+	// IAP_AUDIENCE is set.
+	authAudience := os.Getenv("AUTH_AUDIENCE")
+	if authAudience == "" {
+		authAudience = serviceURL
+	}
+	// Routes that change state or expose the configuration require a verified
+	// caller, unless IAP or an IAM-restricted ingress already authenticates
+	// every caller and TRUST_INGRESS is set to true
+	trustIngress := os.Getenv("TRUST_INGRESS") == "true"
 	return &Config{
-		Port:              port,
-		ProjectID:         projectID,
-		DatabaseID:        databaseID,
+		Port:                port,
+		ProjectID:           projectID,
+		DatabaseID:          databaseID,
+		ServiceURL:          serviceURL,
+		NotifyWebhookURL:    os.Getenv("NOTIFY_WEBHOOK_URL"),
+		ClaimLinkSecret:     os.Getenv("CLAIM_LINK_SECRET"),
+		ClaimLinkTTL:        claimLinkTTL,
+		TimeZone:            timeZone,
+		TraceExporter:       traceExporter,
+		ClaimParallelism:    claimParallelism,
+		PollerMode:          pollerMode,
+		LeaseTTL:            leaseTTL,
+		HeartbeatInterval:   heartbeatInterval,
+		LeaseStore:          leaseStore,
+		PortalTimeout:       portalTimeout,
+		PortalProxyURL:      os.Getenv("PORTAL_PROXY_URL"),
+		PortalCAFile:        os.Getenv("PORTAL_CA_FILE"),
+		PrewarmLead:         prewarmLead,
+		StoreTimeout:        storeTimeout,
+		TasksTimeout:        tasksTimeout,
+		ShutdownTimeout:     shutdownTimeout,
+		SchedulePath:        os.Getenv("SCHEDULE_PATH"),
+		VerifyDelay:         verifyDelay,
+		TasksServiceAccount: os.Getenv("TASKS_SERVICE_ACCOUNT"),
+		AuthAudience:        authAudience,
+		IAPAudience:         os.Getenv("IAP_AUDIENCE"),
+		TrustIngress:        trustIngress,
 	}, nil
 }